@@ -0,0 +1,229 @@
+// Package dhpbfv implements distributed (multiparty) protocols for the HP-BFV scheme
+// in which the secret key is additively shared among the parties.
+package dhpbfv
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+
+	"hp-bfv/hpbfv"
+	"hp-bfv/ring"
+	"hp-bfv/rlwe"
+	"hp-bfv/utils"
+)
+
+// ErrInvalidShare is returned when a decryption share is not a polynomial of the ring Q of the parameters.
+var ErrInvalidShare = errors.New("invalid decryption share")
+
+// SmudgingBound returns the bound B of the smudging noise, uniform in [-B, B], that the parties add to their
+// decryption shares of ciphertexts whose noise budget, see hpbfv.Decryptor.NoiseBudget, is at least noiseBudget,
+// so that the shares leak nothing on the noise of the ciphertexts but with probability 2^-lambda.
+//
+// The noise budget bounds the decoding noise (X^d - b) * e of the ciphertexts, hence their noise e by a factor b - 1,
+// and B is 2^lambda * N times this bound. It returns an error if the decoding noise of the N coefficients smudged
+// by the parties would exceed Q/2, i.e. if the decryption could fail.
+func SmudgingBound(params hpbfv.Parameters, noiseBudget float64, lambda, parties int) (bound *big.Int, err error) {
+	if lambda < 1 || parties < 1 {
+		return nil, fmt.Errorf("cannot SmudgingBound: lambda and parties must be positive")
+	}
+
+	qHalf := new(big.Int).Rsh(params.QBigInt(), 1)
+	b := new(big.Float).SetInt(params.B())
+
+	logNoise := log2(new(big.Float).SetInt(qHalf)) - noiseBudget
+	logE := logNoise - log2(new(big.Float).Sub(b, big.NewFloat(1)))
+	logB := math.Ceil(logE + float64(lambda) + float64(params.LogN()))
+	if logB < 0 {
+		logB = 0
+	}
+
+	bound = new(big.Int).Lsh(big.NewInt(1), uint(logB))
+
+	// parties * B * (b + 1) + 2^logNoise < Q/2
+	smudged := new(big.Float).SetInt(new(big.Int).Mul(bound, big.NewInt(int64(parties))))
+	smudged.Mul(smudged, new(big.Float).Add(b, big.NewFloat(1)))
+	smudged.Add(smudged, new(big.Float).SetMantExp(big.NewFloat(1), int(math.Ceil(logNoise))))
+	if smudged.Cmp(new(big.Float).SetInt(qHalf)) >= 0 {
+		return nil, fmt.Errorf("cannot SmudgingBound: %w: the smudging noise of 2^%d exceeds the noise budget", hpbfv.ErrInvalidParameters, int(logB))
+	}
+
+	return
+}
+
+// log2 returns log2(x) for x > 0.
+func log2(x *big.Float) float64 {
+	mant := new(big.Float)
+	exp := x.MantExp(mant)
+	f, _ := mant.Float64()
+	return float64(exp) + math.Log2(f)
+}
+
+// DecryptionShare is the share of a party in the distributed decryption of a Ciphertext.
+type DecryptionShare struct {
+	Value *ring.Poly
+}
+
+// ThresholdDecryptor is the party-side of the distributed decryption protocol.
+// It stores the secret key share of the party.
+type ThresholdDecryptor struct {
+	params hpbfv.Parameters
+	sk     *rlwe.SecretKey
+
+	prng          utils.PRNG
+	smudgingBound *big.Int
+	smudgingWidth *big.Int // 2 * smudgingBound + 1
+	smudgingBytes []byte
+	smudgingPool  []*big.Int
+
+	ecd      *hpbfv.Encoder
+	zt       *hpbfv.ZT
+	ptxtPool *hpbfv.Plaintext
+	buff     *ring.Poly
+}
+
+// NewThresholdDecryptor creates a new ThresholdDecryptor from the secret key share of a party.
+// The decryption shares are smudged by a noise uniform in [-smudgingBound, smudgingBound], see SmudgingBound.
+// It returns an error if the PRNG of the smudging noise cannot be created.
+func NewThresholdDecryptor(params hpbfv.Parameters, skShare *rlwe.SecretKey, smudgingBound *big.Int) (dec *ThresholdDecryptor, err error) {
+	prng, err := utils.NewPRNG()
+	if err != nil {
+		return nil, fmt.Errorf("cannot NewThresholdDecryptor: %w", err)
+	}
+
+	dec = new(ThresholdDecryptor)
+	dec.params = params
+	dec.sk = skShare
+	dec.prng = prng
+	dec.smudgingBound = new(big.Int).Set(smudgingBound)
+	dec.smudgingWidth = new(big.Int).Add(new(big.Int).Lsh(smudgingBound, 1), big.NewInt(1))
+	// 64 more bits than the width, so that the reduction of the sampled bytes is statistically close to uniform.
+	dec.smudgingBytes = make([]byte, (dec.smudgingWidth.BitLen()+64+7)/8)
+	dec.smudgingPool = make([]*big.Int, params.N())
+	for i := range dec.smudgingPool {
+		dec.smudgingPool[i] = new(big.Int)
+	}
+	dec.ecd = hpbfv.NewEncoder(params)
+	dec.zt = hpbfv.NewZT(params)
+	dec.ptxtPool = hpbfv.NewPlaintext(params)
+	dec.buff = params.RingQ().NewPoly()
+
+	return
+}
+
+// AllocateShare allocates a new DecryptionShare.
+func AllocateShare(params hpbfv.Parameters) *DecryptionShare {
+	return &DecryptionShare{Value: params.RingQ().NewPoly()}
+}
+
+// GenShare computes the decryption share c1 * s_i + e_i of the party for the ciphertext ct,
+// which must have degree 1.
+func (dec *ThresholdDecryptor) GenShare(ct *hpbfv.Ciphertext, share *DecryptionShare) (err error) {
+	if ct.Degree() != 1 {
+		return fmt.Errorf("cannot GenShare: %w: expected 1, got %d", hpbfv.ErrInvalidDegree, ct.Degree())
+	}
+
+	ringQ := dec.params.RingQ()
+
+	ringQ.NTT(ct.Value[1], dec.buff)
+	ringQ.MulCoeffsMontgomery(dec.buff, dec.sk.Value.Q, share.Value)
+	ringQ.InvNTT(share.Value, share.Value)
+
+	if err = dec.sampleSmudging(dec.buff); err != nil {
+		return fmt.Errorf("cannot GenShare: %w", err)
+	}
+	ringQ.Add(share.Value, dec.buff, share.Value)
+
+	return
+}
+
+// sampleSmudging samples a polynomial whose coefficients are uniform in [-smudgingBound, smudgingBound] on pol.
+func (dec *ThresholdDecryptor) sampleSmudging(pol *ring.Poly) (err error) {
+	for _, c := range dec.smudgingPool {
+		if _, err = dec.prng.Read(dec.smudgingBytes); err != nil {
+			return
+		}
+		c.SetBytes(dec.smudgingBytes)
+		c.Mod(c, dec.smudgingWidth)
+		c.Sub(c, dec.smudgingBound)
+	}
+
+	dec.params.RingQ().SetCoefficientsBigint(dec.smudgingPool, pol)
+	return
+}
+
+// GenShareNew computes the decryption share of the party for the ciphertext ct and returns it.
+func (dec *ThresholdDecryptor) GenShareNew(ct *hpbfv.Ciphertext) (share *DecryptionShare, err error) {
+	share = AllocateShare(dec.params)
+	if err = dec.GenShare(ct, share); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// GenAdditiveShare samples a uniform message maskOut and computes the decryption share
+// c1 * s_i + e_i - Encode(maskOut). If all parties but one use this method, the combined
+// decryption yields m - sum(maskOut) so that the plaintext ends up additively shared mod T.
+func (dec *ThresholdDecryptor) GenAdditiveShare(ct *hpbfv.Ciphertext, maskOut *hpbfv.Message, share *DecryptionShare) (err error) {
+	if err = dec.zt.SampleUniform(dec.prng, maskOut.Value); err != nil {
+		return fmt.Errorf("cannot GenAdditiveShare: %w", err)
+	}
+
+	if err = dec.GenShare(ct, share); err != nil {
+		return
+	}
+
+	dec.ecd.Encode(maskOut, dec.ptxtPool)
+	dec.params.RingQ().Sub(share.Value, dec.ptxtPool.Value, share.Value)
+
+	return
+}
+
+// AggregateShares adds share0 and share1 and writes the result on shareOut.
+func AggregateShares(params hpbfv.Parameters, share0, share1, shareOut *DecryptionShare) {
+	params.RingQ().Add(share0.Value, share1.Value, shareOut.Value)
+}
+
+// Combiner recovers the plaintext of a ciphertext from the aggregated decryption shares.
+type Combiner struct {
+	params   hpbfv.Parameters
+	dcd      *hpbfv.Decoder
+	ptxtPool *hpbfv.Plaintext
+}
+
+// NewCombiner creates a new Combiner.
+func NewCombiner(params hpbfv.Parameters) (cmb *Combiner) {
+	cmb = new(Combiner)
+	cmb.params = params
+	cmb.dcd = hpbfv.NewDecoder(params)
+	cmb.ptxtPool = hpbfv.NewPlaintext(params)
+	return
+}
+
+// Combine computes c0 + sum(c1 * s_i + e_i) and decodes the result over X^D - B on msgOut.
+// ct must have degree 1, and aggShare must be the aggregation of the decryption shares of all the parties.
+func (cmb *Combiner) Combine(ct *hpbfv.Ciphertext, aggShare *DecryptionShare, msgOut *hpbfv.Message) (err error) {
+	if ct.Degree() != 1 {
+		return fmt.Errorf("cannot Combine: %w: expected 1, got %d", hpbfv.ErrInvalidDegree, ct.Degree())
+	}
+
+	ringQ := cmb.params.RingQ()
+	if aggShare == nil || aggShare.Value == nil || aggShare.Value.N() != ringQ.N || aggShare.Value.Level() != len(ringQ.Modulus)-1 {
+		return fmt.Errorf("cannot Combine: %w", ErrInvalidShare)
+	}
+
+	ringQ.Add(ct.Value[0], aggShare.Value, cmb.ptxtPool.Value)
+	cmb.dcd.Decode(cmb.ptxtPool, msgOut)
+
+	return
+}
+
+// CombineNew combines the aggregated decryption shares and returns the result on a new Message.
+func (cmb *Combiner) CombineNew(ct *hpbfv.Ciphertext, aggShare *DecryptionShare) (msgOut *hpbfv.Message, err error) {
+	msgOut = hpbfv.NewMessage(cmb.params)
+	if err = cmb.Combine(ct, aggShare, msgOut); err != nil {
+		return nil, err
+	}
+	return
+}
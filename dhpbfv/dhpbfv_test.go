@@ -0,0 +1,316 @@
+package dhpbfv
+
+import (
+	"crypto/rand"
+	"math"
+	"math/big"
+	"testing"
+
+	"hp-bfv/hpbfv"
+	"hp-bfv/ring"
+	"hp-bfv/rlwe"
+	"hp-bfv/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const parties = 3
+
+type testContext struct {
+	params   hpbfv.Parameters
+	kgen     hpbfv.KeyGenerator
+	skShares []*rlwe.SecretKey
+	sk       *rlwe.SecretKey
+	pk       *rlwe.PublicKey
+	crs      *utils.KeyedPRNG
+}
+
+func genTestContext(params hpbfv.Parameters) (testctx *testContext) {
+	testctx = new(testContext)
+	testctx.params = params
+	testctx.kgen = hpbfv.NewKeyGenerator(params)
+
+	testctx.sk = rlwe.NewSecretKey(params.Parameters)
+	testctx.skShares = make([]*rlwe.SecretKey, parties)
+	for i := range testctx.skShares {
+		testctx.skShares[i] = testctx.kgen.GenSecretKey()
+		params.RingQP().AddLvl(params.QCount()-1, params.PCount()-1, testctx.sk.Value, testctx.skShares[i].Value, testctx.sk.Value)
+	}
+
+	var err error
+	if testctx.crs, err = utils.NewKeyedPRNG([]byte("dhpbfv test crs")); err != nil {
+		panic(err)
+	}
+
+	ckg, err := NewPublicKeyGenProtocol(params)
+	if err != nil {
+		panic(err)
+	}
+	crp := ckg.SampleCRP(testctx.crs)
+	agg := ckg.AllocateShare()
+	share := ckg.AllocateShare()
+	for i := range testctx.skShares {
+		ckg.GenShare(testctx.skShares[i], crp, share)
+		ckg.AggregateShares(agg, share, agg)
+	}
+	testctx.pk = rlwe.NewPublicKey(params.Parameters)
+	ckg.GenPublicKey(agg, crp, testctx.pk)
+
+	return
+}
+
+// newThresholdDecryptors creates the ThresholdDecryptors of the parties of testctx, whose smudging noise
+// is sized for the smallest noise budget of cts.
+func newThresholdDecryptors(t *testing.T, testctx *testContext, cts ...*hpbfv.Ciphertext) (decs []*ThresholdDecryptor) {
+	dec := hpbfv.NewDecryptor(testctx.params, testctx.sk)
+	budget := dec.NoiseBudget(cts[0])
+	for _, ct := range cts[1:] {
+		budget = math.Min(budget, dec.NoiseBudget(ct))
+	}
+
+	bound, err := SmudgingBound(testctx.params, budget, 40, parties)
+	require.NoError(t, err)
+
+	decs = make([]*ThresholdDecryptor, parties)
+	for i := range decs {
+		decs[i], err = NewThresholdDecryptor(testctx.params, testctx.skShares[i], bound)
+		require.NoError(t, err)
+	}
+	return
+}
+
+// thresholdDecrypt jointly decrypts ct with the secret key shares of testctx.
+func thresholdDecrypt(t *testing.T, testctx *testContext, decs []*ThresholdDecryptor, ct *hpbfv.Ciphertext) *hpbfv.Message {
+	agg, err := decs[0].GenShareNew(ct)
+	require.NoError(t, err)
+	for i := 1; i < len(decs); i++ {
+		share, err := decs[i].GenShareNew(ct)
+		require.NoError(t, err)
+		AggregateShares(testctx.params, agg, share, agg)
+	}
+	msg, err := NewCombiner(testctx.params).CombineNew(ct, agg)
+	require.NoError(t, err)
+	return msg
+}
+
+func genTestMessage(params hpbfv.Parameters) (msg *hpbfv.Message) {
+	msg = hpbfv.NewMessage(params)
+	if err := hpbfv.NewZT(params).SampleUniform(rand.Reader, msg.Value); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func TestDHPBFV(t *testing.T) {
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.HPN13D10T128)
+	require.NoError(t, err)
+	testctx := genTestContext(params)
+
+	testPublicKeyGen(testctx, t)
+	testThresholdDecryption(testctx, t)
+	testRelinKeyGen(testctx, t)
+	testRotationKeyGen(testctx, t)
+}
+
+func testPublicKeyGen(testctx *testContext, t *testing.T) {
+	t.Run("PublicKeyGen", func(t *testing.T) {
+		log2Bound := 10 + testctx.params.LogN()
+		assert.True(t, rlwe.PublicKeyIsCorrect(testctx.pk, testctx.sk, testctx.params.Parameters, log2Bound))
+	})
+
+	t.Run("PublicKeyGen/Marshal", func(t *testing.T) {
+		ckg, err := NewPublicKeyGenProtocol(testctx.params)
+		require.NoError(t, err)
+		share := ckg.AllocateShare()
+		ckg.GenShare(testctx.skShares[0], ckg.SampleCRP(testctx.crs), share)
+
+		data, err := share.MarshalBinary()
+		assert.NoError(t, err)
+
+		shareNew := new(PublicKeyGenShare)
+		assert.NoError(t, shareNew.UnmarshalBinary(data))
+		assert.True(t, share.Value.Equals(shareNew.Value))
+	})
+}
+
+func testRelinKeyGen(testctx *testContext, t *testing.T) {
+	params := testctx.params
+
+	t.Run("RelinKeyGen", func(t *testing.T) {
+		rkg, err := NewRelinKeyGenProtocol(params)
+		require.NoError(t, err)
+		crp := rkg.SampleCRP(testctx.crs)
+
+		ephSks := make([]*rlwe.SecretKey, parties)
+		round1 := rkg.AllocateShare()
+		share := rkg.AllocateShare()
+		for i := range ephSks {
+			ephSks[i] = rkg.GenEphemeralKey()
+			rkg.GenShareRoundOne(testctx.skShares[i], ephSks[i], crp, share)
+			rkg.AggregateShares(round1, share, round1)
+		}
+
+		round2 := rkg.AllocateShare()
+		for i := range ephSks {
+			rkg.GenShareRoundTwo(testctx.skShares[i], ephSks[i], round1, share)
+			rkg.AggregateShares(round2, share, round2)
+		}
+
+		rlk := rlwe.NewRelinearizationKey(params.Parameters, 1)
+		rkg.GenRelinearizationKey(round1, round2, rlk)
+
+		data, err := share.MarshalBinary()
+		assert.NoError(t, err)
+		shareNew := new(RelinKeyGenShare)
+		assert.NoError(t, shareNew.UnmarshalBinary(data))
+		assert.True(t, share.Equals(&shareNew.GadgetCiphertext))
+
+		enc := hpbfv.NewEncryptor(params, testctx.pk)
+		eval := hpbfv.NewEvaluator(params)
+		msg0 := genTestMessage(params)
+		msg1 := genTestMessage(params)
+		ct := eval.MulAndRelinNew(enc.EncryptMsgNew(msg0), enc.EncryptMsgNew(msg1), rlk)
+		msgOut := thresholdDecrypt(t, testctx, newThresholdDecryptors(t, testctx, ct), ct)
+
+		for i := range msgOut.Value {
+			want := new(big.Int).Mul(msg0.Value[i].BigInt(), msg1.Value[i].BigInt())
+			want.Mod(want, params.T())
+			assert.Equal(t, want.Text(10), msgOut.Value[i].Text(10))
+		}
+	})
+}
+
+func testRotationKeyGen(testctx *testContext, t *testing.T) {
+	params := testctx.params
+	dim := 2
+
+	t.Run("RotationKeyGen/MatMul", func(t *testing.T) {
+		kgen := hpbfv.NewKeyGenerator(params)
+		rlk := kgen.GenRelinearizationKey(testctx.sk, 1)
+
+		rtg, err := NewRotationKeyGenProtocol(params)
+		require.NoError(t, err)
+		rks := &rlwe.RotationKeySet{Keys: map[uint64]*rlwe.SwitchingKey{}}
+		share := rtg.AllocateShare()
+		for _, galEl := range params.GaloisElementsForMatMul(dim) {
+			crp := rtg.SampleCRP(testctx.crs)
+			agg := rtg.AllocateShare()
+			for i := range testctx.skShares {
+				rtg.GenShare(testctx.skShares[i], galEl, crp, share)
+				rtg.AggregateShares(agg, share, agg)
+			}
+			rks.Keys[galEl] = rlwe.NewSwitchingKey(params.Parameters, params.QCount()-1, params.PCount()-1)
+			rtg.GenRotationKey(agg, crp, rks.Keys[galEl])
+		}
+
+		pack := params.Slots() / dim
+		M0 := make([][][]*big.Int, pack)
+		M1 := make([][][]*big.Int, pack)
+		for l := 0; l < pack; l++ {
+			M0[l] = [][]*big.Int{{big.NewInt(1), big.NewInt(2)}, {big.NewInt(3), big.NewInt(4)}}
+			M1[l] = [][]*big.Int{{big.NewInt(5), big.NewInt(6)}, {big.NewInt(7), big.NewInt(8)}}
+		}
+
+		ecd := hpbfv.NewMatrixEncoder(params)
+		enc := hpbfv.NewMatrixEncryptor(params, testctx.pk, nil)
+		eval := hpbfv.NewMatrixEvaluator(params, rlk, rks)
+		pt0, err := ecd.EncodeMatrixNew(M0, true)
+		require.NoError(t, err)
+		pt1, err := ecd.EncodeMatrixNew(M1, false)
+		require.NoError(t, err)
+		ct0, err := enc.EncryptNew(pt0)
+		require.NoError(t, err)
+		ct1, err := enc.EncryptNew(pt1)
+		require.NoError(t, err)
+		ctOut, err := eval.MulNew(ct0, ct1)
+		require.NoError(t, err)
+
+		decs := newThresholdDecryptors(t, testctx, ctOut.Value...)
+
+		em, err := hpbfv.NewMatrixMessage(params, dim, true)
+		require.NoError(t, err)
+		for i := range ctOut.Value {
+			em.Value[i] = thresholdDecrypt(t, testctx, decs, ctOut.Value[i])
+		}
+		MOut, err := ecd.DecodeMatrixMessageNew(em)
+		require.NoError(t, err)
+
+		want := [][]int64{{19, 22}, {43, 50}}
+		for l := 0; l < pack; l++ {
+			for i := 0; i < dim; i++ {
+				for j := 0; j < dim; j++ {
+					assert.Equal(t, want[i][j], MOut[l][i][j].Int64())
+				}
+			}
+		}
+	})
+}
+
+func testThresholdDecryption(testctx *testContext, t *testing.T) {
+	params := testctx.params
+	enc := hpbfv.NewEncryptor(params, testctx.pk)
+	cmb := NewCombiner(params)
+
+	decs := newThresholdDecryptors(t, testctx, enc.EncryptMsgNew(genTestMessage(params)))
+
+	t.Run("ThresholdDecryption", func(t *testing.T) {
+		msg := genTestMessage(params)
+		ct := enc.EncryptMsgNew(msg)
+
+		msgOut := thresholdDecrypt(t, testctx, decs, ct)
+		for i := range msg.Value {
+			assert.Equal(t, msg.Value[i].Text(10), msgOut.Value[i].Text(10))
+		}
+	})
+
+	t.Run("ThresholdDecryption/Additive", func(t *testing.T) {
+		msg := genTestMessage(params)
+		ct := enc.EncryptMsgNew(msg)
+
+		masks := make([]*hpbfv.Message, parties)
+		agg, err := decs[0].GenShareNew(ct)
+		require.NoError(t, err)
+		for i := 1; i < parties; i++ {
+			masks[i] = hpbfv.NewMessage(params)
+			share := AllocateShare(params)
+			require.NoError(t, decs[i].GenAdditiveShare(ct, masks[i], share))
+			AggregateShares(params, agg, share, agg)
+		}
+		masks[0], err = cmb.CombineNew(ct, agg)
+		require.NoError(t, err)
+
+		for i := range msg.Value {
+			sum := big.NewInt(0)
+			for j := range masks {
+				sum.Add(sum, masks[j].Value[i].BigInt())
+			}
+			sum.Mod(sum, params.T())
+			assert.Equal(t, msg.Value[i].Text(10), sum.Text(10))
+		}
+	})
+
+	t.Run("ThresholdDecryption/InvalidDegree", func(t *testing.T) {
+		ct := hpbfv.NewCiphertext(params, 2)
+		_, err := decs[0].GenShareNew(ct)
+		assert.ErrorIs(t, err, hpbfv.ErrInvalidDegree)
+
+		_, err = cmb.CombineNew(ct, AllocateShare(params))
+		assert.ErrorIs(t, err, hpbfv.ErrInvalidDegree)
+	})
+
+	t.Run("ThresholdDecryption/InvalidShare", func(t *testing.T) {
+		ct := enc.EncryptMsgNew(genTestMessage(params))
+		_, err := cmb.CombineNew(ct, &DecryptionShare{Value: ring.NewPoly(params.N(), 0)})
+		assert.ErrorIs(t, err, ErrInvalidShare)
+	})
+
+	t.Run("ThresholdDecryption/SmudgingBound", func(t *testing.T) {
+		_, err := SmudgingBound(params, 100, 40, parties)
+		assert.NoError(t, err)
+
+		// The smudging noise of 2^200 * N times the noise does not fit in the noise budget.
+		_, err = SmudgingBound(params, 100, 200, parties)
+		assert.ErrorIs(t, err, hpbfv.ErrInvalidParameters)
+	})
+}
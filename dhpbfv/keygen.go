@@ -0,0 +1,335 @@
+package dhpbfv
+
+import (
+	"fmt"
+
+	"hp-bfv/hpbfv"
+	"hp-bfv/ring"
+	"hp-bfv/rlwe"
+	"hp-bfv/rlwe/ringqp"
+	"hp-bfv/utils"
+)
+
+// keyGenBase stores the elements shared by the collective key generation protocols.
+type keyGenBase struct {
+	params          hpbfv.Parameters
+	levelQ, levelP  int
+	gaussianSampler *ring.GaussianSampler
+	ternarySampler  *ring.TernarySampler
+	buffQ           *ring.Poly
+	buffQP          ringqp.Poly
+	// buffSk stores the key derived from the secret key of the party, phi(s_i) or u_i - s_i.
+	buffSk ringqp.Poly
+}
+
+// newKeyGenBase returns an error if the PRNG of the samplers cannot be created.
+func newKeyGenBase(params hpbfv.Parameters) (base keyGenBase, err error) {
+	prng, err := utils.NewPRNG()
+	if err != nil {
+		return base, err
+	}
+
+	base.params = params
+	base.levelQ = params.QCount() - 1
+	base.levelP = params.PCount() - 1
+	base.gaussianSampler = ring.NewGaussianSampler(prng, params.RingQ(), params.Sigma(), int(6*params.Sigma()))
+	base.ternarySampler = ring.NewTernarySamplerWithHammingWeight(prng, params.RingQ(), params.HammingWeight(), false)
+	base.buffQ = params.RingQ().NewPoly()
+	base.buffQP = params.RingQP().NewPoly()
+	base.buffSk = params.RingQP().NewPoly()
+	return
+}
+
+// addErrorQP samples a Gaussian error and adds it to p, which is in the NTT and Montgomery domain.
+func (base *keyGenBase) addErrorQP(p ringqp.Poly) {
+	ringQP := base.params.RingQP()
+	e := base.buffQP
+	base.gaussianSampler.Read(e.Q)
+	if base.levelP > -1 {
+		ringQP.ExtendBasisSmallNormAndCenter(e.Q, base.levelP, nil, e.P)
+	}
+	ringQP.NTTLvl(base.levelQ, base.levelP, e, e)
+	ringQP.MFormLvl(base.levelQ, base.levelP, e, e)
+	ringQP.AddLvl(base.levelQ, base.levelP, p, e, p)
+}
+
+// sampleCRPs samples the common random polynomials of a gadget ciphertext from the CRS.
+func (base *keyGenBase) sampleCRPs(crs utils.PRNG) (crp [][]ringqp.Poly) {
+	ringQP := base.params.RingQP()
+	decompRNS := base.params.DecompRNS(base.levelQ, base.levelP)
+	decompBIT := base.params.DecompPw2(base.levelQ, base.levelP)
+	us := ringqp.NewUniformSampler(crs, *ringQP)
+
+	crp = make([][]ringqp.Poly, decompRNS)
+	for i := range crp {
+		crp[i] = make([]ringqp.Poly, decompBIT)
+		for j := range crp[i] {
+			crp[i][j] = ringQP.NewPoly()
+			us.Read(crp[i][j])
+		}
+	}
+	return
+}
+
+// PublicKeyGenShare is the share of a party in the collective public key generation.
+type PublicKeyGenShare struct {
+	Value ringqp.Poly
+}
+
+// MarshalBinary encodes the share on a slice of bytes.
+func (share *PublicKeyGenShare) MarshalBinary() ([]byte, error) {
+	return share.Value.MarshalBinary()
+}
+
+// UnmarshalBinary decodes a slice of bytes on the share.
+func (share *PublicKeyGenShare) UnmarshalBinary(data []byte) error {
+	return share.Value.UnmarshalBinary(data)
+}
+
+// PublicKeyGenProtocol is the one-round protocol generating the collective public key (-a*s + e, a)
+// with s = sum(s_i), where a is sampled from the common reference string.
+type PublicKeyGenProtocol struct {
+	keyGenBase
+}
+
+// NewPublicKeyGenProtocol creates a new PublicKeyGenProtocol.
+// It returns an error if the PRNG of the protocol cannot be created.
+func NewPublicKeyGenProtocol(params hpbfv.Parameters) (ckg *PublicKeyGenProtocol, err error) {
+	base, err := newKeyGenBase(params)
+	if err != nil {
+		return nil, fmt.Errorf("cannot NewPublicKeyGenProtocol: %w", err)
+	}
+	return &PublicKeyGenProtocol{base}, nil
+}
+
+// AllocateShare allocates a new PublicKeyGenShare.
+func (ckg *PublicKeyGenProtocol) AllocateShare() *PublicKeyGenShare {
+	return &PublicKeyGenShare{Value: ckg.params.RingQP().NewPoly()}
+}
+
+// SampleCRP samples the common random polynomial a from the CRS.
+func (ckg *PublicKeyGenProtocol) SampleCRP(crs utils.PRNG) (crp ringqp.Poly) {
+	crp = ckg.params.RingQP().NewPoly()
+	ringqp.NewUniformSampler(crs, *ckg.params.RingQP()).Read(crp)
+	return
+}
+
+// GenShare generates the share -a*s_i + e_i of the party.
+func (ckg *PublicKeyGenProtocol) GenShare(sk *rlwe.SecretKey, crp ringqp.Poly, shareOut *PublicKeyGenShare) {
+	ringQP := ckg.params.RingQP()
+	shareOut.Value.Q.Zero()
+	if shareOut.Value.P != nil {
+		shareOut.Value.P.Zero()
+	}
+	ckg.addErrorQP(shareOut.Value)
+	ringQP.MulCoeffsMontgomeryAndSubLvl(ckg.levelQ, ckg.levelP, crp, sk.Value, shareOut.Value)
+}
+
+// AggregateShares adds share0 and share1 and writes the result on shareOut.
+func (ckg *PublicKeyGenProtocol) AggregateShares(share0, share1, shareOut *PublicKeyGenShare) {
+	ckg.params.RingQP().AddLvl(ckg.levelQ, ckg.levelP, share0.Value, share1.Value, shareOut.Value)
+}
+
+// GenPublicKey writes the collective public key from the aggregated shares on pkOut.
+func (ckg *PublicKeyGenProtocol) GenPublicKey(aggShare *PublicKeyGenShare, crp ringqp.Poly, pkOut *rlwe.PublicKey) {
+	pkOut.Value[0].Copy(aggShare.Value)
+	pkOut.Value[1].Copy(crp)
+}
+
+// RelinKeyGenShare is the share of a party in a round of the collective relinearization key generation.
+type RelinKeyGenShare struct {
+	rlwe.GadgetCiphertext
+}
+
+// RelinKeyGenProtocol is the two-round protocol generating the collective relinearization key,
+// i.e. a switching key from s^2 to s with s = sum(s_i).
+//
+// Round one: each party samples an ephemeral key u_i and outputs (-u_i*a + s_i*w + e, s_i*a + e),
+// which aggregate into (h0, h1) = (-u*a + s*w + e, s*a + e).
+//
+// Round two: each party outputs (s_i*h0 + e, (u_i - s_i)*h1 + e), which aggregate into
+// (-u*s*a + s^2*w + e, u*s*a - s^2*a + e), so that the key is (sum of round two, h1).
+type RelinKeyGenProtocol struct {
+	keyGenBase
+}
+
+// NewRelinKeyGenProtocol creates a new RelinKeyGenProtocol.
+// It returns an error if the PRNG of the protocol cannot be created.
+func NewRelinKeyGenProtocol(params hpbfv.Parameters) (rkg *RelinKeyGenProtocol, err error) {
+	base, err := newKeyGenBase(params)
+	if err != nil {
+		return nil, fmt.Errorf("cannot NewRelinKeyGenProtocol: %w", err)
+	}
+	return &RelinKeyGenProtocol{base}, nil
+}
+
+// AllocateShare allocates a new RelinKeyGenShare.
+func (rkg *RelinKeyGenProtocol) AllocateShare() *RelinKeyGenShare {
+	return &RelinKeyGenShare{rlwe.NewSwitchingKey(rkg.params.Parameters, rkg.levelQ, rkg.levelP).GadgetCiphertext}
+}
+
+// SampleCRP samples the common random polynomials of the gadget ciphertext from the CRS.
+func (rkg *RelinKeyGenProtocol) SampleCRP(crs utils.PRNG) [][]ringqp.Poly {
+	return rkg.sampleCRPs(crs)
+}
+
+// GenEphemeralKey samples the ephemeral secret key u_i of the party.
+func (rkg *RelinKeyGenProtocol) GenEphemeralKey() (ephSk *rlwe.SecretKey) {
+	ringQP := rkg.params.RingQP()
+	ephSk = rlwe.NewSecretKey(rkg.params.Parameters)
+	rkg.ternarySampler.Read(ephSk.Value.Q)
+	if rkg.levelP > -1 {
+		ringQP.ExtendBasisSmallNormAndCenter(ephSk.Value.Q, rkg.levelP, nil, ephSk.Value.P)
+	}
+	ringQP.NTTLvl(rkg.levelQ, rkg.levelP, ephSk.Value, ephSk.Value)
+	ringQP.MFormLvl(rkg.levelQ, rkg.levelP, ephSk.Value, ephSk.Value)
+	return
+}
+
+// GenShareRoundOne generates the share of the first round of the party.
+func (rkg *RelinKeyGenProtocol) GenShareRoundOne(sk, ephSk *rlwe.SecretKey, crp [][]ringqp.Poly, shareOut *RelinKeyGenShare) {
+	ringQP := rkg.params.RingQP()
+	for i := range shareOut.Value {
+		for j := range shareOut.Value[i] {
+			el := shareOut.Value[i][j].Value
+
+			// -u_i*a + e
+			el[0].Q.Zero()
+			if el[0].P != nil {
+				el[0].P.Zero()
+			}
+			rkg.addErrorQP(el[0])
+			ringQP.MulCoeffsMontgomeryAndSubLvl(rkg.levelQ, rkg.levelP, crp[i][j], ephSk.Value, el[0])
+
+			// s_i*a + e
+			ringQP.MulCoeffsMontgomeryLvl(rkg.levelQ, rkg.levelP, crp[i][j], sk.Value, el[1])
+			rkg.addErrorQP(el[1])
+		}
+	}
+
+	// + s_i*w
+	rlwe.AddPolyTimesGadgetVectorToGadgetCiphertext(sk.Value.Q, []rlwe.GadgetCiphertext{shareOut.GadgetCiphertext}, *ringQP, rkg.params.Pow2Base(), rkg.buffQ)
+}
+
+// GenShareRoundTwo generates the share of the second round of the party from the aggregated shares of round one.
+func (rkg *RelinKeyGenProtocol) GenShareRoundTwo(sk, ephSk *rlwe.SecretKey, round1 *RelinKeyGenShare, shareOut *RelinKeyGenShare) {
+	ringQP := rkg.params.RingQP()
+
+	// u_i - s_i
+	uMinusS := rkg.buffSk
+	ringQP.SubLvl(rkg.levelQ, rkg.levelP, ephSk.Value, sk.Value, uMinusS)
+
+	for i := range shareOut.Value {
+		for j := range shareOut.Value[i] {
+			el := shareOut.Value[i][j].Value
+			h := round1.Value[i][j].Value
+
+			// s_i*h0 + e
+			ringQP.MulCoeffsMontgomeryLvl(rkg.levelQ, rkg.levelP, h[0], sk.Value, el[0])
+			rkg.addErrorQP(el[0])
+
+			// (u_i - s_i)*h1 + e
+			ringQP.MulCoeffsMontgomeryLvl(rkg.levelQ, rkg.levelP, h[1], uMinusS, el[1])
+			rkg.addErrorQP(el[1])
+		}
+	}
+}
+
+// AggregateShares adds share0 and share1 and writes the result on shareOut.
+func (rkg *RelinKeyGenProtocol) AggregateShares(share0, share1, shareOut *RelinKeyGenShare) {
+	aggregateGadgetCiphertexts(rkg.params, share0.GadgetCiphertext, share1.GadgetCiphertext, shareOut.GadgetCiphertext)
+}
+
+// GenRelinearizationKey writes the collective relinearization key from the aggregated shares of both rounds on rlkOut.
+func (rkg *RelinKeyGenProtocol) GenRelinearizationKey(round1, round2 *RelinKeyGenShare, rlkOut *rlwe.RelinearizationKey) {
+	ringQP := rkg.params.RingQP()
+	for i := range round2.Value {
+		for j := range round2.Value[i] {
+			el := rlkOut.Keys[0].Value[i][j].Value
+			ringQP.AddLvl(rkg.levelQ, rkg.levelP, round2.Value[i][j].Value[0], round2.Value[i][j].Value[1], el[0])
+			el[1].Copy(round1.Value[i][j].Value[1])
+		}
+	}
+}
+
+// RotationKeyGenShare is the share of a party in the collective generation of a rotation key.
+type RotationKeyGenShare struct {
+	rlwe.GadgetCiphertext
+}
+
+// RotationKeyGenProtocol is the one-round protocol generating the collective rotation keys
+// used by the MatrixEvaluator, i.e. switching keys from phi(s) to s for a Galois automorphism phi.
+// Each party outputs -a*s_i + phi(s_i)*w + e, where a is sampled from the CRS.
+type RotationKeyGenProtocol struct {
+	keyGenBase
+}
+
+// NewRotationKeyGenProtocol creates a new RotationKeyGenProtocol.
+// It returns an error if the PRNG of the protocol cannot be created.
+func NewRotationKeyGenProtocol(params hpbfv.Parameters) (rtg *RotationKeyGenProtocol, err error) {
+	base, err := newKeyGenBase(params)
+	if err != nil {
+		return nil, fmt.Errorf("cannot NewRotationKeyGenProtocol: %w", err)
+	}
+	return &RotationKeyGenProtocol{base}, nil
+}
+
+// AllocateShare allocates a new RotationKeyGenShare.
+func (rtg *RotationKeyGenProtocol) AllocateShare() *RotationKeyGenShare {
+	return &RotationKeyGenShare{rlwe.NewSwitchingKey(rtg.params.Parameters, rtg.levelQ, rtg.levelP).GadgetCiphertext}
+}
+
+// SampleCRP samples the common random polynomials of the gadget ciphertext from the CRS.
+func (rtg *RotationKeyGenProtocol) SampleCRP(crs utils.PRNG) [][]ringqp.Poly {
+	return rtg.sampleCRPs(crs)
+}
+
+// GenShare generates the share of the party for the Galois element galEl.
+func (rtg *RotationKeyGenProtocol) GenShare(sk *rlwe.SecretKey, galEl uint64, crp [][]ringqp.Poly, shareOut *RotationKeyGenShare) {
+	ringQP := rtg.params.RingQP()
+
+	rtg.params.RingQ().PermuteNTT(sk.Value.Q, galEl, rtg.buffSk.Q)
+
+	for i := range shareOut.Value {
+		for j := range shareOut.Value[i] {
+			el := shareOut.Value[i][j].Value
+
+			el[0].Q.Zero()
+			if el[0].P != nil {
+				el[0].P.Zero()
+			}
+			rtg.addErrorQP(el[0])
+			ringQP.MulCoeffsMontgomeryAndSubLvl(rtg.levelQ, rtg.levelP, crp[i][j], sk.Value, el[0])
+		}
+	}
+
+	rlwe.AddPolyTimesGadgetVectorToGadgetCiphertext(rtg.buffSk.Q, []rlwe.GadgetCiphertext{shareOut.GadgetCiphertext}, *ringQP, rtg.params.Pow2Base(), rtg.buffQ)
+}
+
+// AggregateShares adds share0 and share1 and writes the result on shareOut.
+func (rtg *RotationKeyGenProtocol) AggregateShares(share0, share1, shareOut *RotationKeyGenShare) {
+	aggregateGadgetCiphertexts(rtg.params, share0.GadgetCiphertext, share1.GadgetCiphertext, shareOut.GadgetCiphertext)
+}
+
+// GenRotationKey writes the collective switching key from the aggregated shares on swkOut.
+func (rtg *RotationKeyGenProtocol) GenRotationKey(aggShare *RotationKeyGenShare, crp [][]ringqp.Poly, swkOut *rlwe.SwitchingKey) {
+	for i := range aggShare.Value {
+		for j := range aggShare.Value[i] {
+			swkOut.Value[i][j].Value[0].Copy(aggShare.Value[i][j].Value[0])
+			swkOut.Value[i][j].Value[1].Copy(crp[i][j])
+		}
+	}
+}
+
+// aggregateGadgetCiphertexts adds the first elements of ct0 and ct1 and writes the result on ctOut.
+// The second elements are also added since they are either both shares or both equal to zero.
+func aggregateGadgetCiphertexts(params hpbfv.Parameters, ct0, ct1, ctOut rlwe.GadgetCiphertext) {
+	ringQP := params.RingQP()
+	levelQ, levelP := ctOut.LevelQ(), ctOut.LevelP()
+	for i := range ctOut.Value {
+		for j := range ctOut.Value[i] {
+			ringQP.AddLvl(levelQ, levelP, ct0.Value[i][j].Value[0], ct1.Value[i][j].Value[0], ctOut.Value[i][j].Value[0])
+			ringQP.AddLvl(levelQ, levelP, ct0.Value[i][j].Value[1], ct1.Value[i][j].Value[1], ctOut.Value[i][j].Value[1])
+		}
+	}
+}
@@ -6,23 +6,24 @@ import (
 	"hp-bfv/ring"
 )
 
+// Decoder decodes Plaintexts into Messages.
+// A Decoder is not safe for concurrent use: use ShallowCopy to obtain one Decoder per goroutine.
 type Decoder struct {
 	params Parameters
 
 	polyPool *ring.Poly
 
-	nttRoots   *Message
-	msgPool    *Message
 	coeffPool1 []*big.Int
 	coeffPool2 []*big.Int
+
+	nttSlots *slotsNTT
+	nttBuf   *slotsNTTBuffer
 }
 
 func NewDecoder(params Parameters) (dcd *Decoder) {
 	dcd = new(Decoder)
 	dcd.params = params
 	dcd.polyPool = params.RingQ().NewPoly()
-	dcd.nttRoots = NewMessage(params)
-	dcd.msgPool = NewMessage(params)
 	dcd.coeffPool1 = make([]*big.Int, params.N())
 	dcd.coeffPool2 = make([]*big.Int, params.N())
 	for i := 0; i < params.N(); i++ {
@@ -32,63 +33,50 @@ func NewDecoder(params Parameters) (dcd *Decoder) {
 
 	slots := params.Slots()
 	root := params.Root()
-	roots := dcd.nttRoots
+	roots := make([]*big.Int, slots)
 	k := params.N() / params.Slots()
 
 	//compute ntt roots
 	for i := 0; i < slots; i++ {
 		//roots.Value[i].Exp(root, big.NewInt(int64(2*k*i+1)), params.T)
 		e := ring.ModExp(5, uint64((k/2)*i), uint64(params.N()*2))
-		roots.Value[i].Exp(root, big.NewInt(int64(e)), params.T())
+		roots[i] = new(big.Int).Exp(root, big.NewInt(int64(e)), params.T())
 	}
 
+	dcd.nttSlots = newSlotsNTT(params, roots)
+	dcd.nttBuf = dcd.nttSlots.newBuffer()
+
 	return
 }
 
-func (dcd *Decoder) ntt(msgIn, msgOut *Message) {
-	slots := dcd.params.Slots()
-	roots := dcd.nttRoots
+// ShallowCopy creates a shallow copy of Decoder in which all the read-only data-structures are
+// shared with the receiver and the temporary buffers are reallocated. The receiver and the returned
+// Decoder can be used concurrently.
+func (dcd *Decoder) ShallowCopy() *Decoder {
+	params := dcd.params
 
-	if msgIn != msgOut {
-		for i := 0; i < slots; i++ {
-			msgOut.Value[i].Set(msgIn.Value[i])
-		}
+	dcdCopy := &Decoder{
+		params:     params,
+		polyPool:   params.RingQ().NewPoly(),
+		coeffPool1: make([]*big.Int, params.N()),
+		coeffPool2: make([]*big.Int, params.N()),
+		nttSlots:   dcd.nttSlots,
+		nttBuf:     dcd.nttSlots.newBuffer(),
 	}
 
-	//apply bit reversal
-	j := 0
-	for i := 1; i < slots; i++ {
-		bit := (slots >> 1)
-		for j >= bit {
-			j -= bit
-			bit >>= 1
-		}
-		j += bit
-		if i < j {
-			msgOut.Value[i], msgOut.Value[j] = msgOut.Value[j], msgOut.Value[i]
-		}
+	for i := 0; i < params.N(); i++ {
+		dcdCopy.coeffPool1[i] = big.NewInt(0)
+		dcdCopy.coeffPool2[i] = big.NewInt(0)
 	}
 
-	//apply inplace NTT
-	for i := 2; i <= slots; i <<= 1 {
-		step := slots / i
-		for j := 0; j < slots; j += i {
-			for k := 0; k < i/2; k++ {
-				u := new(big.Int).Set(msgOut.Value[j+k])
-				v := new(big.Int).Exp(roots.Value[k], big.NewInt(int64(step)), dcd.params.T())
-				v.Mul(msgOut.Value[j+k+i/2], v)
-				msgOut.Value[j+k].Add(u, v)
-				msgOut.Value[j+k+i/2].Sub(u, v)
-
-				msgOut.Value[j+k].Mod(msgOut.Value[j+k], dcd.params.T())
-				msgOut.Value[j+k+i/2].Mod(msgOut.Value[j+k+i/2], dcd.params.T())
-			}
-		}
-	}
+	return dcdCopy
+}
 
-	for i := 0; i < slots; i++ {
-		msgOut.Value[i].Mod(msgOut.Value[i], dcd.params.T())
-	}
+// ntt writes on msgOut the NTT of the Slots() first values of slotsIn mod T, evaluating it at the roots of X^d - b.
+func (dcd *Decoder) ntt(slotsIn []*big.Int, msgOut *Message) {
+	dcd.nttSlots.loadBig(slotsIn, dcd.nttBuf)
+	dcd.nttSlots.transform(dcd.nttBuf)
+	dcd.nttSlots.store(msgOut.Value, dcd.nttBuf)
 }
 
 func (dcd *Decoder) DecodeNew(ptxtIn *Plaintext) (msgOut *Message) {
@@ -99,8 +87,32 @@ func (dcd *Decoder) DecodeNew(ptxtIn *Plaintext) (msgOut *Message) {
 
 func (dcd *Decoder) Decode(ptxtIn *Plaintext, msgOut *Message) {
 	params := dcd.params
-	ringQ := params.RingQ()
 	slots := params.Slots()
+
+	// mult (X^d-b)/q to ptxt
+
+	dcd.mulXdMinusB(ptxtIn)
+
+	qHalf := new(big.Int).Div(params.QBigInt(), big.NewInt(2))
+	for i := 0; i < params.N(); i++ {
+		dcd.coeffPool2[i].Add(dcd.coeffPool2[i], qHalf)
+		dcd.coeffPool2[i].Div(dcd.coeffPool2[i], params.QBigInt())
+	}
+
+	for i := params.N() - 1; i >= slots; i-- {
+		dcd.coeffPool2[i].Mul(dcd.coeffPool2[i], params.b)
+		dcd.coeffPool2[i-slots].Add(dcd.coeffPool2[i-slots], dcd.coeffPool2[i])
+		dcd.coeffPool2[i-slots].Mod(dcd.coeffPool2[i-slots], dcd.params.T())
+	}
+
+	//apply NTT
+
+	dcd.ntt(dcd.coeffPool2, msgOut)
+}
+
+// mulXdMinusB writes (X^d - b) * ptxtIn, with the coefficients of ptxtIn in [0, Q), on coeffPool2.
+func (dcd *Decoder) mulXdMinusB(ptxtIn *Plaintext) {
+	params := dcd.params
 	d := int(params.D())
 
 	for i := 0; i < params.N(); i++ {
@@ -108,9 +120,7 @@ func (dcd *Decoder) Decode(ptxtIn *Plaintext, msgOut *Message) {
 		dcd.coeffPool2[i].SetInt64(0)
 	}
 
-	// mult (X^d-b)/q to ptxt
-
-	ringQ.PolyToBigint(ptxtIn.Value, 1, dcd.coeffPool1)
+	params.RingQ().PolyToBigint(ptxtIn.Value, 1, dcd.coeffPool1)
 
 	for i := 0; i < params.N(); i++ {
 		tmp := new(big.Int).Mul(dcd.coeffPool1[i], new(big.Int).Neg(params.b))
@@ -122,24 +132,30 @@ func (dcd *Decoder) Decode(ptxtIn *Plaintext, msgOut *Message) {
 			dcd.coeffPool2[i+d-params.N()].Sub(dcd.coeffPool2[i+d-params.N()], dcd.coeffPool1[i])
 		}
 	}
+}
 
-	qHalf := new(big.Int).Div(params.QBigInt(), big.NewInt(2))
-	for i := 0; i < params.N(); i++ {
-		dcd.coeffPool2[i].Add(dcd.coeffPool2[i], qHalf)
-		dcd.coeffPool2[i].Div(dcd.coeffPool2[i], params.QBigInt())
-	}
+// noise returns the infinity norm of the decoding noise of ptxtIn, i.e. of the distance of the
+// coefficients of (X^d - b) * ptxtIn to the closest multiples of Q, which Decode rounds away.
+// The decoding is correct as long as it is smaller than Q/2.
+func (dcd *Decoder) noise(ptxtIn *Plaintext) (norm *big.Int) {
+	params := dcd.params
+	Q := params.QBigInt()
+	qHalf := new(big.Int).Rsh(Q, 1)
 
-	for i := params.N() - 1; i >= slots; i-- {
-		dcd.coeffPool2[i].Mul(dcd.coeffPool2[i], params.b)
-		dcd.coeffPool2[i-slots].Add(dcd.coeffPool2[i-slots], dcd.coeffPool2[i])
-		dcd.coeffPool2[i-slots].Mod(dcd.coeffPool2[i-slots], dcd.params.T())
-	}
+	dcd.mulXdMinusB(ptxtIn)
 
-	//apply NTT
+	norm = new(big.Int)
+	for i := 0; i < params.N(); i++ {
+		c := dcd.coeffPool2[i]
+		c.Mod(c, Q)
+		if c.Cmp(qHalf) > 0 {
+			c.Sub(Q, c)
+		}
 
-	for i := 0; i < slots; i++ {
-		dcd.msgPool.Value[i].Set(dcd.coeffPool2[i])
+		if c.Cmp(norm) > 0 {
+			norm.Set(c)
+		}
 	}
 
-	dcd.ntt(dcd.msgPool, msgOut)
+	return
 }
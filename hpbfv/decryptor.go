@@ -1,9 +1,14 @@
 package hpbfv
 
 import (
+	"math"
+	"math/big"
+
 	"hp-bfv/rlwe"
 )
 
+// Decryptor decrypts Ciphertexts with a secret key.
+// A Decryptor is not safe for concurrent use: use ShallowCopy to obtain one Decryptor per goroutine.
 type Decryptor struct {
 	params   Parameters
 	dec      rlwe.Decryptor
@@ -21,6 +26,18 @@ func NewDecryptor(params Parameters, sk *rlwe.SecretKey) (dec *Decryptor) {
 	return
 }
 
+// ShallowCopy creates a shallow copy of Decryptor in which all the read-only data-structures are
+// shared with the receiver and the temporary buffers are reallocated. The receiver and the returned
+// Decryptor can be used concurrently.
+func (dec *Decryptor) ShallowCopy() *Decryptor {
+	return &Decryptor{
+		params:   dec.params,
+		dec:      dec.dec.ShallowCopy(),
+		dcd:      dec.dcd.ShallowCopy(),
+		ptxtPool: NewPlaintext(dec.params),
+	}
+}
+
 func (dec *Decryptor) Decrypt(ctIn *Ciphertext, ptOut *Plaintext) {
 	dec.dec.Decrypt(ctIn.Ciphertext, ptOut.Plaintext)
 }
@@ -41,3 +58,26 @@ func (dec *Decryptor) DecryptToMsgNew(ctIn *Ciphertext) (msgOut *Message) {
 	dec.DecryptToMsg(ctIn, msgOut)
 	return
 }
+
+// NoiseBudget returns log2(Q/2) - log2(||e||), where e is the decoding noise of ctIn, i.e. the number of bits
+// by which the noise can still grow before the decryption of ctIn fails. A negative budget means that
+// ctIn does not decrypt correctly anymore.
+func (dec *Decryptor) NoiseBudget(ctIn *Ciphertext) (budget float64) {
+	dec.Decrypt(ctIn, dec.ptxtPool)
+	return log2(new(big.Int).Rsh(dec.params.QBigInt(), 1)) - log2(dec.dcd.noise(dec.ptxtPool))
+}
+
+// log2 returns log2(x) for x > 0, and 0 for x = 0.
+func log2(x *big.Int) float64 {
+	if x.Sign() == 0 {
+		return 0
+	}
+
+	shift := x.BitLen() - 53
+	if shift < 0 {
+		shift = 0
+	}
+
+	f, _ := new(big.Float).SetInt(new(big.Int).Rsh(x, uint(shift))).Float64()
+	return float64(shift) + math.Log2(f)
+}
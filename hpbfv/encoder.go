@@ -6,18 +6,22 @@ import (
 	"hp-bfv/ring"
 )
 
+// Encoder encodes Messages into Plaintexts and PlaintextMuls.
+// An Encoder is not safe for concurrent use: use ShallowCopy to obtain one Encoder per goroutine.
 type Encoder struct {
 	params Parameters
 
 	polyPool *ring.Poly
 
-	nttRoots   *Message
-	rootPows   *Message
-	msgPool    *Message
+	slotPool   []*big.Int
 	coeffPool1 []*big.Int
 	coeffPool2 []*big.Int
 
-	dInvModT *big.Int
+	nttSlots *slotsNTT
+	nttBuf   *slotsNTTBuffer
+
+	// scale[i] = root^-i / slots * R mod T, in the Montgomery domain of nttSlots
+	scale [][]uint64
 
 	indexMap []int
 }
@@ -26,11 +30,11 @@ func NewEncoder(params Parameters) (ecd *Encoder) {
 	ecd = new(Encoder)
 	ecd.params = params
 	ecd.polyPool = params.RingQ().NewPoly()
-	ecd.nttRoots = NewMessage(params)
-	ecd.rootPows = NewMessage(params)
-	ecd.msgPool = NewMessage(params)
 	ecd.indexMap = make([]int, params.Slots())
-	ecd.dInvModT = new(big.Int).ModInverse(big.NewInt(int64(params.Slots())), params.T())
+	ecd.slotPool = make([]*big.Int, params.Slots())
+	for i := range ecd.slotPool {
+		ecd.slotPool[i] = big.NewInt(0)
+	}
 	ecd.coeffPool1 = make([]*big.Int, params.N())
 	ecd.coeffPool2 = make([]*big.Int, params.N())
 	for i := 0; i < params.N(); i++ {
@@ -40,14 +44,25 @@ func NewEncoder(params Parameters) (ecd *Encoder) {
 
 	slots := params.Slots()
 	root := params.Root()
-	roots := ecd.nttRoots
-	rootPows := ecd.rootPows
+	roots := make([]*big.Int, slots)
 	k := params.N() / params.Slots()
+	dInvModT := new(big.Int).ModInverse(big.NewInt(int64(slots)), params.T())
+
+	//compute i-th root
+	for i := 0; i < slots; i++ {
+		roots[i] = new(big.Int).Exp(root, big.NewInt(int64(2*params.N()-2*k*i)), params.T())
+	}
+
+	ecd.nttSlots = newSlotsNTT(params, roots)
+	ecd.nttBuf = ecd.nttSlots.newBuffer()
 
-	//compute i-th root and minus i-th power of root
+	//compute minus i-th power of root
+	ecd.scale = ecd.nttSlots.mont.newElements(slots)
+	rootPow := new(big.Int)
 	for i := 0; i < slots; i++ {
-		roots.Value[i].Exp(root, big.NewInt(int64(2*params.N()-2*k*i)), params.T())
-		rootPows.Value[i].Exp(root, big.NewInt(int64(2*params.N()-i)), params.T())
+		rootPow.Exp(root, big.NewInt(int64(2*params.N()-i)), params.T())
+		rootPow.Mul(rootPow, dInvModT)
+		ecd.nttSlots.mont.setBig(rootPow, ecd.scale[i], ecd.nttBuf.mont)
 	}
 
 	//compute indexMap[5^(ik/2)/2k)] = i
@@ -59,66 +74,42 @@ func NewEncoder(params Parameters) (ecd *Encoder) {
 	return
 }
 
-func (ecd *Encoder) invNtt(msgIn, msgOut *Message) {
-
-	ecd.permute(msgIn, ecd.msgPool)
-
-	slots := ecd.params.Slots()
-	roots := ecd.nttRoots
-
-	for i := 0; i < slots; i++ {
-		msgOut.Value[i].Set(ecd.msgPool.Value[i])
-	}
+// ShallowCopy creates a shallow copy of Encoder in which all the read-only data-structures are
+// shared with the receiver and the temporary buffers are reallocated. The receiver and the returned
+// Encoder can be used concurrently.
+func (ecd *Encoder) ShallowCopy() *Encoder {
+	params := ecd.params
 
-	//apply bit reversal
-	j := 0
-	for i := 1; i < slots; i++ {
-		bit := (slots >> 1)
-		for j >= bit {
-			j -= bit
-			bit >>= 1
-		}
-		j += bit
-		if i < j {
-			msgOut.Value[i], msgOut.Value[j] = msgOut.Value[j], msgOut.Value[i]
-		}
+	ecdCopy := &Encoder{
+		params:     params,
+		polyPool:   params.RingQ().NewPoly(),
+		slotPool:   make([]*big.Int, params.Slots()),
+		coeffPool1: make([]*big.Int, params.N()),
+		coeffPool2: make([]*big.Int, params.N()),
+		nttSlots:   ecd.nttSlots,
+		nttBuf:     ecd.nttSlots.newBuffer(),
+		scale:      ecd.scale,
+		indexMap:   ecd.indexMap,
 	}
 
-	//apply inplace NTT
-	for i := 2; i <= slots; i <<= 1 {
-		step := slots / i
-		for j := 0; j < slots; j += i {
-			for k := 0; k < i/2; k++ {
-				u := new(big.Int).Set(msgOut.Value[j+k])
-				v := new(big.Int).Exp(roots.Value[k], big.NewInt(int64(step)), ecd.params.T())
-				v.Mul(msgOut.Value[j+k+i/2], v)
-				msgOut.Value[j+k].Add(u, v)
-				msgOut.Value[j+k+i/2].Sub(u, v)
-
-				msgOut.Value[j+k].Mod(msgOut.Value[j+k], ecd.params.T())
-				msgOut.Value[j+k+i/2].Mod(msgOut.Value[j+k+i/2], ecd.params.T())
-			}
-		}
+	for i := range ecdCopy.slotPool {
+		ecdCopy.slotPool[i] = big.NewInt(0)
 	}
 
-	for i := 0; i < slots; i++ {
-		msgOut.Value[i].Mul(msgOut.Value[i], ecd.rootPows.Value[i])
-		msgOut.Value[i].Mul(msgOut.Value[i], ecd.dInvModT)
-		msgOut.Value[i].Mod(msgOut.Value[i], ecd.params.T())
+	for i := 0; i < params.N(); i++ {
+		ecdCopy.coeffPool1[i] = big.NewInt(0)
+		ecdCopy.coeffPool2[i] = big.NewInt(0)
 	}
 
+	return ecdCopy
 }
 
-func (ecd *Encoder) permute(msgIn, msgOut *Message) {
-	if msgIn == msgOut {
-		panic("Cannot permute: input and output message should be different!!")
-	}
-
-	slots := ecd.params.Slots()
-
-	for i := 0; i < slots; i++ {
-		msgOut.Value[i].Set(msgIn.Value[ecd.indexMap[i]])
-	}
+// invNtt writes on slotsOut the inverse NTT of the permutation of msgIn given by indexMap,
+// twisted by the powers of root^-1.
+func (ecd *Encoder) invNtt(msgIn *Message, slotsOut []*big.Int) {
+	ecd.nttSlots.load(msgIn.Value, ecd.indexMap, ecd.nttBuf)
+	ecd.nttSlots.transform(ecd.nttBuf)
+	ecd.nttSlots.storeBig(slotsOut, ecd.scale, ecd.nttBuf)
 }
 
 func (ecd *Encoder) EncodeNew(msgIn *Message) (ptxtOut *Plaintext) {
@@ -130,7 +121,7 @@ func (ecd *Encoder) EncodeNew(msgIn *Message) (ptxtOut *Plaintext) {
 func (ecd *Encoder) Encode(msgIn *Message, ptxtOut *Plaintext) {
 	params := ecd.params
 
-	ecd.invNtt(msgIn, ecd.msgPool)
+	ecd.invNtt(msgIn, ecd.slotPool)
 
 	// mult (X^(N-D) + bX^(N-2D) + ...)
 	d := params.Slots()
@@ -144,10 +135,11 @@ func (ecd *Encoder) Encode(msgIn *Message, ptxtOut *Plaintext) {
 		ecd.coeffPool2[i].SetInt64(0)
 	}
 
+	tmp := new(big.Int)
 	for i := 0; i < k; i++ {
 		for j := 0; j < d; j++ {
 			e := j + i*d
-			tmp := new(big.Int).Mul(ecd.msgPool.Value[j], ecd.coeffPool1[i*d])
+			tmp.Mul(ecd.slotPool[j], ecd.coeffPool1[i*d])
 			ecd.coeffPool2[e].Sub(ecd.coeffPool2[e], tmp)
 		}
 	}
@@ -163,3 +155,64 @@ func (ecd *Encoder) Encode(msgIn *Message, ptxtOut *Plaintext) {
 
 	params.RingQ().SetCoefficientsBigint(ecd.coeffPool2, ptxtOut.Value)
 }
+
+// EncodeMulNew encodes msgIn into a new PlaintextMul.
+func (ecd *Encoder) EncodeMulNew(msgIn *Message) (ptxtOut *PlaintextMul) {
+	ptxtOut = NewPlaintextMul(ecd.params)
+	ecd.EncodeMul(msgIn, ptxtOut)
+	return
+}
+
+// EncodeMul encodes msgIn into a PlaintextMul, whose coefficients are the balanced base b digits
+// of the representative of msgIn modulo X^d - b, in the NTT and Montgomery domain of RingQ.
+func (ecd *Encoder) EncodeMul(msgIn *Message, ptxtOut *PlaintextMul) {
+	ringQ := ecd.params.RingQ()
+	ecd.encodeSmall(msgIn, ptxtOut.Value)
+	ringQ.NTT(ptxtOut.Value, ptxtOut.Value)
+	ringQ.MForm(ptxtOut.Value, ptxtOut.Value)
+}
+
+// encodeSmall writes on pOut the representative of msgIn modulo X^d - b
+// whose coefficients are the balanced base b digits of the coefficients of msgIn.
+func (ecd *Encoder) encodeSmall(msgIn *Message, pOut *ring.Poly) {
+	params := ecd.params
+
+	ecd.invNtt(msgIn, ecd.slotPool)
+
+	coeffs := make([]*big.Int, params.N())
+	v := new(big.Int)
+	for j := 0; j < params.Slots(); j++ {
+		balancedDigits(params, v.Set(ecd.slotPool[j]), coeffs, j)
+	}
+
+	params.RingQ().SetCoefficientsBigint(coeffs, pOut)
+}
+
+// balancedDigits writes on coeffs[j + i*d] for 0 <= i < N/d the balanced base b digits of v mod T,
+// so that sum_i coeffs[j + i*d] X^(i*d) = v mod (X^d - b). v is overwritten.
+func balancedDigits(params Parameters, v *big.Int, coeffs []*big.Int, j int) {
+	d := params.Slots()
+	k := params.N() / d
+	b := params.b
+	T := params.t
+	bHalf := new(big.Int).Rsh(b, 1)
+	tHalf := new(big.Int).Rsh(T, 1)
+
+	v.Mod(v, T)
+	if v.Cmp(tHalf) > 0 {
+		v.Sub(v, T)
+	}
+
+	for i := 0; i < k; i++ {
+		r := new(big.Int).Mod(v, b)
+		if r.Cmp(bHalf) > 0 {
+			r.Sub(r, b)
+		}
+		coeffs[j+i*d] = r
+		v.Sub(v, r)
+		v.Quo(v, b)
+	}
+
+	// b^k = -1 mod T
+	coeffs[j].Sub(coeffs[j], v)
+}
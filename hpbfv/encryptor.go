@@ -4,6 +4,8 @@ import (
 	"hp-bfv/rlwe"
 )
 
+// Encryptor encrypts Plaintexts and Messages under a public key.
+// An Encryptor is not safe for concurrent use: use ShallowCopy to obtain one Encryptor per goroutine.
 type Encryptor struct {
 	params   Parameters
 	enc      rlwe.Encryptor
@@ -20,6 +22,18 @@ func NewEncryptor(params Parameters, pk *rlwe.PublicKey) (enc *Encryptor) {
 	return
 }
 
+// ShallowCopy creates a shallow copy of Encryptor in which all the read-only data-structures are
+// shared with the receiver and the temporary buffers and samplers are reallocated. The receiver and
+// the returned Encryptor can be used concurrently.
+func (enc *Encryptor) ShallowCopy() *Encryptor {
+	return &Encryptor{
+		params:   enc.params,
+		enc:      enc.enc.ShallowCopy(),
+		ecd:      enc.ecd.ShallowCopy(),
+		ptxtPool: NewPlaintext(enc.params),
+	}
+}
+
 func (enc *Encryptor) Encrypt(ptxtIn *Plaintext, ctxtOut *Ciphertext) {
 	enc.enc.Encrypt(ptxtIn.Plaintext, ctxtOut.Ciphertext)
 }
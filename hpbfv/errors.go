@@ -0,0 +1,26 @@
+package hpbfv
+
+import "errors"
+
+var (
+	// ErrInvalidParameters is returned when a parameter set is inconsistent.
+	ErrInvalidParameters = errors.New("invalid parameters")
+	// ErrDimNotDivisor is returned when a matrix dimension does not divide the number of slots.
+	ErrDimNotDivisor = errors.New("dim must divide the number of slots")
+	// ErrEncodingMismatch is returned when the dimension, pack or diagonal flag of matrix operands do not match.
+	ErrEncodingMismatch = errors.New("wrong encoding")
+	// ErrInvalidDegree is returned when a ciphertext does not have the expected degree.
+	ErrInvalidDegree = errors.New("invalid ciphertext degree")
+	// ErrMissingRotationKey is returned when a rotation key required by an operation has not been generated.
+	ErrMissingRotationKey = errors.New("missing rotation key")
+	// ErrMissingRelinearizationKey is returned when an operation requires a relinearization key.
+	ErrMissingRelinearizationKey = errors.New("missing relinearization key")
+	// ErrMissingSecretKey is returned when decrypting without a secret key.
+	ErrMissingSecretKey = errors.New("missing secret key")
+	// ErrOverflow is returned when a value does not fit in Z_T or a decoded value does not fit in the requested type.
+	ErrOverflow = errors.New("value out of range")
+	// ErrNotInvertible is returned when inverting an element of Z_T that has no inverse, i.e. zero.
+	ErrNotInvertible = errors.New("element is not invertible")
+	// ErrInvalidLayout is returned when the matrices of a MatrixLayout overlap or do not fit in the slots.
+	ErrInvalidLayout = errors.New("invalid matrix layout")
+)
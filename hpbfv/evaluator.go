@@ -9,6 +9,8 @@ import (
 	"hp-bfv/utils"
 )
 
+// Evaluator evaluates homomorphic operations on Ciphertexts.
+// An Evaluator is not safe for concurrent use: use ShallowCopy to obtain one Evaluator per goroutine.
 type Evaluator struct {
 	params        Parameters
 	ksw           *rlwe.Evaluator
@@ -36,6 +38,28 @@ func NewEvaluator(params Parameters) (eval *Evaluator) {
 	return eval
 }
 
+// ShallowCopy creates a shallow copy of Evaluator in which all the read-only data-structures are
+// shared with the receiver and the temporary buffers are reallocated. The receiver and the returned
+// Evaluator can be used concurrently.
+func (eval *Evaluator) ShallowCopy() *Evaluator {
+	params := eval.params
+
+	evalCopy := &Evaluator{
+		params:        params,
+		ksw:           eval.ksw.ShallowCopy(),
+		conv:          eval.conv.ShallowCopy(),
+		poolKeySwitch: rlwe.NewCiphertext(params.Parameters, 1, params.MaxLevel()),
+		poolCtMul:     NewCiphertext(params, 2),
+	}
+
+	for i := 0; i < len(evalCopy.poolQ); i++ {
+		evalCopy.poolQ[i] = params.RingQ().NewPoly()
+		evalCopy.poolQMul[i] = params.RingQMul().NewPoly()
+	}
+
+	return evalCopy
+}
+
 // getElemAndCheckBinary unwraps the elements from the operands and checks that the receiver has sufficiently large degree.
 func (eval *Evaluator) getElemAndCheckBinary(op0, op1, opOut *rlwe.Ciphertext, opOutMinDegree int) (el0, el1, elOut *rlwe.Ciphertext) {
 	if op0 == nil || op1 == nil || opOut == nil {
@@ -109,9 +133,9 @@ func (eval *Evaluator) RescaleQMul(ct0 *Ciphertext, ctOut []ringqp.Poly) {
 	levelQMul := len(ringQMul.Modulus) - 1
 
 	for i := 0; i < 2; i++ {
-		ringQ.MulScalarBigint(ct0.Value[i], ringQMul.ModulusAtLevel[levelQ], ctOut[i].Q)
+		ringQ.MulScalarBigint(ct0.Value[i], ringQMul.ModulusAtLevel[levelQMul], ctOut[i].Q)
 		ctOut[i].P.Zero()
-		eval.conv.ModDownQPtoQ(levelQ, levelQMul, ctOut[i].Q, ctOut[i].P, ctOut[i].P)
+		eval.conv.ModDownQPtoP(levelQ, levelQMul, ctOut[i].Q, ctOut[i].P, ctOut[i].P)
 		eval.conv.ModUpPtoQ(levelQMul, levelQ, ctOut[i].P, ctOut[i].Q)
 
 		ringQ.NTT(ctOut[i].Q, ctOut[i].Q)
@@ -185,7 +209,7 @@ func (eval *Evaluator) tensorAndRescale(ct0, ct1, ctOut *rlwe.Ciphertext) {
 }
 
 // tensorAndRescaleHoisted computes (ct0 x ct1) * (t/Q) and stores the result in ctOut.
-// ct0 should be created with ExtendQMulLeft and ct1 with ExtendQMulRight.
+// ct0 should be created with RescaleQMul.
 func (eval *Evaluator) tensorAndRescaleHoisted(ct0 []ringqp.Poly, ct1, ctOut *rlwe.Ciphertext) {
 	ringQ := eval.params.RingQ()
 	ringQMul := eval.params.RingQMul()
@@ -200,17 +224,17 @@ func (eval *Evaluator) tensorAndRescaleHoisted(ct0 []ringqp.Poly, ct1, ctOut *rl
 		ringQMul.NTT(eval.poolQMul[i], eval.poolQMul[i])
 	}
 
-	ringQ.MulCoeffsMontgomery(ct0[0].Q, eval.poolQ[0], eval.poolQ[4])
-	ringQMul.MulCoeffsMontgomery(ct0[0].P, eval.poolQMul[0], eval.poolQMul[4])
+	ringQ.MulCoeffsMontgomery(ct0[0].Q, eval.poolQ[2], eval.poolQ[4])
+	ringQMul.MulCoeffsMontgomery(ct0[0].P, eval.poolQMul[2], eval.poolQMul[4])
 
-	ringQ.MulCoeffsMontgomery(ct0[0].Q, eval.poolQ[1], eval.poolQ[5])
-	ringQMul.MulCoeffsMontgomery(ct0[0].P, eval.poolQMul[1], eval.poolQMul[5])
+	ringQ.MulCoeffsMontgomery(ct0[0].Q, eval.poolQ[3], eval.poolQ[5])
+	ringQMul.MulCoeffsMontgomery(ct0[0].P, eval.poolQMul[3], eval.poolQMul[5])
 
-	ringQ.MulCoeffsMontgomeryAndAdd(ct0[1].Q, eval.poolQ[0], eval.poolQ[5])
-	ringQMul.MulCoeffsMontgomeryAndAdd(ct0[1].P, eval.poolQMul[0], eval.poolQMul[5])
+	ringQ.MulCoeffsMontgomeryAndAdd(ct0[1].Q, eval.poolQ[2], eval.poolQ[5])
+	ringQMul.MulCoeffsMontgomeryAndAdd(ct0[1].P, eval.poolQMul[2], eval.poolQMul[5])
 
-	ringQ.MulCoeffsMontgomery(ct0[1].Q, eval.poolQ[0], eval.poolQ[6])
-	ringQMul.MulCoeffsMontgomery(ct0[1].P, eval.poolQMul[0], eval.poolQMul[6])
+	ringQ.MulCoeffsMontgomery(ct0[1].Q, eval.poolQ[3], eval.poolQ[6])
+	ringQMul.MulCoeffsMontgomery(ct0[1].P, eval.poolQMul[3], eval.poolQMul[6])
 
 	for i := 0; i < 3; i++ {
 		ringQ.InvNTT(eval.poolQ[i+4], eval.poolQ[i+4])
@@ -277,16 +301,18 @@ func (eval *Evaluator) NegNew(ctIn *Ciphertext) (ctOut *Ciphertext) {
 //
 // If only the power-of-two rotations are stored, the numbers k and n/2-k will be decomposed in base-2 and the rotation with the lowest
 // hamming weight will be chosen; then the specific rotation will be computed as a sum of powers of two rotations.
-func (eval *Evaluator) RotateColumns(ct0 *Ciphertext, rtks *rlwe.RotationKeySet, k int, ctOut *Ciphertext) {
+func (eval *Evaluator) RotateColumns(ct0 *Ciphertext, rtks *rlwe.RotationKeySet, k int, ctOut *Ciphertext) (err error) {
 
 	if ct0.Degree() != 1 || ctOut.Degree() != 1 {
-		panic("cannot RotateColumns: input and or output must be of degree 1")
+		return fmt.Errorf("cannot RotateColumns: %w: input and or output must be of degree 1", ErrInvalidDegree)
 	}
 
 	if k == 0 {
 
 		ctOut.Copy(ct0.El())
 
+	} else if rtks == nil {
+		return fmt.Errorf("cannot RotateColumns: %w: evaluator has no rotation key for rotation by %d", ErrMissingRotationKey, k)
 	} else {
 		galElL := eval.params.GaloisElementForColumnRotationBy(uint64(k))
 		// Looks in the rotation key if the corresponding rotation has been generated or if the input is a plaintext
@@ -295,15 +321,19 @@ func (eval *Evaluator) RotateColumns(ct0 *Ciphertext, rtks *rlwe.RotationKeySet,
 			eval.permute(ct0, galElL, swk, ctOut)
 
 		} else {
-			panic(fmt.Errorf("evaluator has no rotation key for rotation by %d", k))
+			return fmt.Errorf("cannot RotateColumns: %w: evaluator has no rotation key for rotation by %d", ErrMissingRotationKey, k)
 		}
 	}
+
+	return
 }
 
 // RotateColumnsNew applies RotateColumns and returns the result in a new Ciphertext.
-func (eval *Evaluator) RotateColumnsNew(ct0 *Ciphertext, rtks *rlwe.RotationKeySet, k int) (ctOut *Ciphertext) {
+func (eval *Evaluator) RotateColumnsNew(ct0 *Ciphertext, rtks *rlwe.RotationKeySet, k int) (ctOut *Ciphertext, err error) {
 	ctOut = NewCiphertext(eval.params, 1)
-	eval.RotateColumns(ct0, rtks, k, ctOut)
+	if err = eval.RotateColumns(ct0, rtks, k, ctOut); err != nil {
+		return nil, err
+	}
 	return
 }
 
@@ -321,7 +351,7 @@ func (eval *Evaluator) MulAndRelinNew(op0, op1 *Ciphertext, rlk *rlwe.Relineariz
 }
 
 // MulAndRelinHoisted multiplies op0 by op1 and returns the result in ctOut.
-// op0 should be created with ExtendQMulLeft and op1 with ExtendQMulRight.
+// op0 should be created with RescaleQMul, so that it can be reused across several multiplications.
 func (eval *Evaluator) MulAndRelinHoisted(op0 []ringqp.Poly, op1 *Ciphertext, rlk *rlwe.RelinearizationKey, ctOut *Ciphertext) {
 	eval.tensorAndRescaleHoisted(op0, op1.Ciphertext, eval.poolCtMul.Ciphertext)
 	eval.relinearize(eval.poolCtMul, rlk, ctOut)
@@ -9,11 +9,17 @@ import (
 			"github.com/stretchr/testify/require"
 	*/
 
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
 	"testing"
 
 	"hp-bfv/ring"
 	"hp-bfv/rlwe"
+	"hp-bfv/rlwe/ringqp"
 	"hp-bfv/utils"
 
 	"github.com/stretchr/testify/assert"
@@ -29,6 +35,7 @@ type testContext struct {
 	pk        *rlwe.PublicKey
 	rlk       *rlwe.RelinearizationKey
 	rtks      *rlwe.RotationKeySet
+	zt        *ZT
 	encoder   *Encoder
 	decoder   *Decoder
 	encryptor *Encryptor
@@ -60,6 +67,7 @@ func genTestParams(params Parameters) (testctx *testContext, err error) {
 	testctx.rlk = testctx.kgen.GenRelinearizationKey(testctx.sk, 1)
 	testctx.rtks = testctx.kgen.GenDefaultRotationKeysForRotation(testctx.sk)
 
+	testctx.zt = NewZT(testctx.params)
 	testctx.encoder = NewEncoder(testctx.params)
 	testctx.decoder = NewDecoder(testctx.params)
 
@@ -75,28 +83,85 @@ func genTestParams(params Parameters) (testctx *testContext, err error) {
 func genTestVectors(testctx *testContext) (msg *Message) {
 	params := testctx.params
 	coeffs := testctx.uSampler.ReadNew()
-	msg = NewMessage(params)
-	testctx.ringQ.PolyToBigint(coeffs, params.N()/params.Slots(), msg.Value)
+	values := make([]*big.Int, params.Slots())
+	testctx.ringQ.PolyToBigint(coeffs, params.N()/params.Slots(), values)
 
-	for i := 0; i < params.Slots(); i++ {
-		msg.Value[i].Mod(msg.Value[i], params.T())
-	}
-
-	return
+	return NewMessageFromBigInts(params, values)
 }
 
 func TestHPBFV(t *testing.T) {
-	params := NewParametersFromLiteral(HPN13D10T128)
+	params, err := NewParametersFromLiteral(HPN13D10T128)
+	if err != nil {
+		panic(err)
+	}
+
 	testctx, err := genTestParams(params)
 	if err != nil {
 		panic(err)
 	}
 
 	// testParameters(testctx, t)
+	testMarshaller(testctx, t)
 	testEncrypt(testctx, t)
 	testEvaluator(testctx, t)
 }
 
+func testMarshaller(testctx *testContext, t *testing.T) {
+
+	params := testctx.params
+
+	t.Run("Marshaller/Parameters/Binary", func(t *testing.T) {
+		data, err := params.MarshalBinary()
+		assert.Nil(t, err)
+		assert.Equal(t, len(data), params.MarshalBinarySize())
+
+		var paramsRec Parameters
+		assert.Nil(t, paramsRec.UnmarshalBinary(data))
+		assert.True(t, params.Equals(paramsRec))
+		assert.Equal(t, params.T(), paramsRec.T())
+		assertEqualFingerprints(t, params, paramsRec)
+
+		assert.NotNil(t, paramsRec.UnmarshalBinary(data[:len(data)-1]))
+	})
+
+	t.Run("Marshaller/Parameters/Invalid", func(t *testing.T) {
+		pl := HPN13D10T128
+		pl.D = 3
+		_, err := NewParametersFromLiteral(pl)
+		assert.True(t, errors.Is(err, ErrInvalidParameters))
+	})
+
+	t.Run("Marshaller/Parameters/JSON", func(t *testing.T) {
+		data, err := json.Marshal(params)
+		assert.Nil(t, err)
+
+		var paramsRec Parameters
+		assert.Nil(t, json.Unmarshal(data, &paramsRec))
+		assert.True(t, params.Equals(paramsRec))
+		assertEqualFingerprints(t, params, paramsRec)
+	})
+
+	t.Run("Marshaller/Parameters/Fingerprint", func(t *testing.T) {
+		other, err := NewParametersFromLiteral(HPN13D9T256)
+		assert.Nil(t, err)
+		assert.False(t, params.Equals(other))
+		fingerprint, err := params.Fingerprint()
+		assert.Nil(t, err)
+		fingerprintOther, err := other.Fingerprint()
+		assert.Nil(t, err)
+		assert.NotEqual(t, fingerprint, fingerprintOther)
+	})
+}
+
+// assertEqualFingerprints checks that params and other have the same fingerprint.
+func assertEqualFingerprints(t *testing.T, params, other Parameters) {
+	fingerprint, err := params.Fingerprint()
+	assert.Nil(t, err)
+	fingerprintOther, err := other.Fingerprint()
+	assert.Nil(t, err)
+	assert.Equal(t, fingerprint, fingerprintOther)
+}
+
 // func testParameters(testctx *testContext, t *testing.T) {
 
 // 	params := testctx.params
@@ -153,10 +218,7 @@ func testEvaluator(testctx *testContext, t *testing.T) {
 		msg2 := genTestVectors(testctx)
 		msg3 := NewMessage(params)
 
-		for i := 0; i < params.Slots(); i++ {
-			msg3.Value[i].Add(msg1.Value[i], msg2.Value[i])
-			msg3.Value[i].Mod(msg3.Value[i], params.T())
-		}
+		testctx.zt.Add(msg1.Value, msg2.Value, msg3.Value)
 
 		ct1 := enc.EncryptMsgNew(msg1)
 		ct2 := enc.EncryptMsgNew(msg2)
@@ -174,10 +236,7 @@ func testEvaluator(testctx *testContext, t *testing.T) {
 		msg2 := genTestVectors(testctx)
 		msg3 := NewMessage(params)
 
-		for i := 0; i < params.Slots(); i++ {
-			msg3.Value[i].Sub(msg1.Value[i], msg2.Value[i])
-			msg3.Value[i].Mod(msg3.Value[i], params.T())
-		}
+		testctx.zt.Sub(msg1.Value, msg2.Value, msg3.Value)
 
 		ct1 := enc.EncryptMsgNew(msg1)
 		ct2 := enc.EncryptMsgNew(msg2)
@@ -195,10 +254,7 @@ func testEvaluator(testctx *testContext, t *testing.T) {
 		msg2 := genTestVectors(testctx)
 		msg3 := NewMessage(params)
 
-		for i := 0; i < params.Slots(); i++ {
-			msg3.Value[i].Mul(msg1.Value[i], msg2.Value[i])
-			msg3.Value[i].Mod(msg3.Value[i], params.T())
-		}
+		testctx.zt.Mul(msg1.Value, msg2.Value, msg3.Value)
 
 		ct1 := enc.EncryptMsgNew(msg1)
 		ct2 := enc.EncryptMsgNew(msg2)
@@ -211,6 +267,60 @@ func testEvaluator(testctx *testContext, t *testing.T) {
 
 	})
 
+	t.Run(testString("Evaluator/MulHoisted/op1=Ciphertext/op2=Ciphertext", testctx.params), func(t *testing.T) {
+		msg1 := genTestVectors(testctx)
+		msg2 := genTestVectors(testctx)
+		msg3 := NewMessage(params)
+
+		testctx.zt.Mul(msg1.Value, msg2.Value, msg3.Value)
+
+		ct1Hoisted := []ringqp.Poly{*NewQQMulPoly(params), *NewQQMulPoly(params)}
+		eval.RescaleQMul(enc.EncryptMsgNew(msg1), ct1Hoisted)
+
+		ct2 := enc.EncryptMsgNew(msg2)
+		ct3 := NewCiphertext(params, 1)
+		eval.MulAndRelinHoisted(ct1Hoisted, ct2, testctx.rlk, ct3)
+		msgOut := dec.DecryptToMsgNew(ct3)
+
+		for i := 0; i < slots; i++ {
+			assert.Equal(t, msgOut.Value[i].Text(10), msg3.Value[i].Text(10))
+		}
+
+	})
+
+	t.Run(testString("Evaluator/RescaleQMul", testctx.params), func(t *testing.T) {
+		ct1 := enc.EncryptMsgNew(genTestVectors(testctx))
+		ct2 := enc.EncryptMsgNew(genTestVectors(testctx))
+
+		ct1Hoisted := []ringqp.Poly{*NewQQMulPoly(params), *NewQQMulPoly(params)}
+		eval.RescaleQMul(ct1, ct1Hoisted)
+
+		// tensorAndRescale leaves ct1 extended to (Q, QMul) in the first two buffers.
+		eval.tensorAndRescale(ct1.Ciphertext, ct2.Ciphertext, NewCiphertext(params, 2).Ciphertext)
+		for i := range ct1Hoisted {
+			assert.True(t, ct1Hoisted[i].Q.Equals(eval.poolQ[i]))
+			assert.True(t, ct1Hoisted[i].P.Equals(eval.poolQMul[i]))
+		}
+	})
+
+	t.Run(testString("Evaluator/TensorAndRescaleHoisted", testctx.params), func(t *testing.T) {
+		ct1 := enc.EncryptMsgNew(genTestVectors(testctx))
+		ct2 := enc.EncryptMsgNew(genTestVectors(testctx))
+
+		ct1Hoisted := []ringqp.Poly{*NewQQMulPoly(params), *NewQQMulPoly(params)}
+		eval.RescaleQMul(ct1, ct1Hoisted)
+
+		want := NewCiphertext(params, 2)
+		eval.tensorAndRescale(ct1.Ciphertext, ct2.Ciphertext, want.Ciphertext)
+
+		have := NewCiphertext(params, 2)
+		eval.tensorAndRescaleHoisted(ct1Hoisted, ct2.Ciphertext, have.Ciphertext)
+
+		for i := range want.Value {
+			assert.True(t, want.Value[i].Equals(have.Value[i]))
+		}
+	})
+
 	t.Run(testString("Evaluator/Rotate", testctx.params), func(t *testing.T) {
 		msg1 := genTestVectors(testctx)
 		msg2 := NewMessage(params)
@@ -226,7 +336,8 @@ func testEvaluator(testctx *testContext, t *testing.T) {
 			}
 
 			ct1 := enc.EncryptMsgNew(msg1)
-			ct2 := eval.RotateColumnsNew(ct1, testctx.rtks, rotidx)
+			ct2, err := eval.RotateColumnsNew(ct1, testctx.rtks, rotidx)
+			assert.Nil(t, err)
 			msgOut := dec.DecryptToMsgNew(ct2)
 
 			for i := 0; i < slots; i++ {
@@ -235,16 +346,16 @@ func testEvaluator(testctx *testContext, t *testing.T) {
 
 		}
 
+		_, err := eval.RotateColumnsNew(enc.EncryptMsgNew(msg1), testctx.rtks, 3)
+		assert.True(t, errors.Is(err, ErrMissingRotationKey))
+
 	})
 
 	t.Run(testString("Evaluator/Neg", testctx.params), func(t *testing.T) {
 		msg1 := genTestVectors(testctx)
 		msg2 := NewMessage(params)
 
-		for i := 0; i < slots; i++ {
-			msg2.Value[i].Neg(msg1.Value[i])
-			msg2.Value[i].Mod(msg2.Value[i], params.T())
-		}
+		testctx.zt.Neg(msg1.Value, msg2.Value)
 
 		ct1 := enc.EncryptMsgNew(msg1)
 		ct2 := eval.NegNew(ct1)
@@ -257,3 +368,389 @@ func testEvaluator(testctx *testContext, t *testing.T) {
 	})
 
 }
+
+// genEncoderTestParams is genTestParams without the keys, the encryptor, the decryptor and the evaluator.
+func genEncoderTestParams(params Parameters) (testctx *testContext, err error) {
+
+	testctx = new(testContext)
+	testctx.params = params
+
+	if testctx.prng, err = utils.NewPRNG(); err != nil {
+		return nil, err
+	}
+
+	testctx.ringQ = params.RingQ()
+	testctx.uSampler = ring.NewUniformSampler(testctx.prng, testctx.ringQ)
+
+	testctx.zt = NewZT(testctx.params)
+	testctx.encoder = NewEncoder(testctx.params)
+	testctx.decoder = NewDecoder(testctx.params)
+
+	return
+}
+
+var encParamSet = []ParametersLiteral{
+	HPN14D13T128, HPN14D12T256, HPN14D11T512, HPN14D10T1024, HPN14D9T2048, HPN14D8T4096,
+	HPN13D10T128, HPN13D9T256, HPN13D8T512, HPN13D7T1024, HPN13D6T2048, HPN13D5T4096,
+	HPN14D13T128P, HPN14D12T256P, HPN14D11T512P, HPN14D10T1024P, HPN14D9T2048P, HPN14D8T4096P,
+	HPN13D10T128P, HPN13D9T256P, HPN13D8T512P, HPN13D7T1024P, HPN13D6T2048P, HPN13D5T4096P,
+}
+
+// refNtt is the big.Int NTT over Z_T of values in place, whose butterflies of size i multiply by roots[k]^(slots/i).
+func refNtt(params Parameters, roots []*big.Int, values []*big.Int) {
+	slots := params.Slots()
+	T := params.T()
+
+	j := 0
+	for i := 1; i < slots; i++ {
+		bit := (slots >> 1)
+		for j >= bit {
+			j -= bit
+			bit >>= 1
+		}
+		j += bit
+		if i < j {
+			values[i], values[j] = values[j], values[i]
+		}
+	}
+
+	for i := 2; i <= slots; i <<= 1 {
+		step := slots / i
+		for j := 0; j < slots; j += i {
+			for k := 0; k < i/2; k++ {
+				u := new(big.Int).Set(values[j+k])
+				v := new(big.Int).Exp(roots[k], big.NewInt(int64(step)), T)
+				v.Mul(values[j+k+i/2], v)
+				values[j+k].Mod(new(big.Int).Add(u, v), T)
+				values[j+k+i/2].Mod(new(big.Int).Sub(u, v), T)
+			}
+		}
+	}
+}
+
+func TestEncoderNTT(t *testing.T) {
+	for _, pl := range encParamSet[:12] {
+		params, err := NewParametersFromLiteral(pl)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testctx, err := genEncoderTestParams(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		slots := params.Slots()
+		T := params.T()
+		N := params.N()
+		k := N / slots
+		root := params.Root()
+
+		t.Run(testString(fmt.Sprintf("Encoder/invNtt/T=%d", T.BitLen()), params), func(t *testing.T) {
+			msg := genTestVectors(testctx)
+
+			dInv := new(big.Int).ModInverse(big.NewInt(int64(slots)), T)
+			roots := make([]*big.Int, slots)
+			want := make([]*big.Int, slots)
+			for i := 0; i < slots; i++ {
+				roots[i] = new(big.Int).Exp(root, big.NewInt(int64(2*N-2*k*i)), T)
+				want[i] = msg.Value[testctx.encoder.indexMap[i]].BigInt()
+			}
+			refNtt(params, roots, want)
+			for i := 0; i < slots; i++ {
+				want[i].Mul(want[i], new(big.Int).Exp(root, big.NewInt(int64(2*N-i)), T))
+				want[i].Mul(want[i], dInv)
+				want[i].Mod(want[i], T)
+			}
+
+			slotsOut := make([]*big.Int, slots)
+			for i := range slotsOut {
+				slotsOut[i] = new(big.Int)
+			}
+			testctx.encoder.invNtt(msg, slotsOut)
+			for i := 0; i < slots; i++ {
+				assert.Equal(t, want[i].Text(16), slotsOut[i].Text(16))
+			}
+		})
+
+		t.Run(testString(fmt.Sprintf("Decoder/ntt/T=%d", T.BitLen()), params), func(t *testing.T) {
+			slotsIn := genTestVectors(testctx).BigInts()
+			slotsIn[0].Add(slotsIn[0], T) // not reduced
+			slotsIn[1].Neg(slotsIn[1])
+
+			roots := make([]*big.Int, slots)
+			want := make([]*big.Int, slots)
+			for i := 0; i < slots; i++ {
+				e := ring.ModExp(5, uint64((k/2)*i), uint64(2*N))
+				roots[i] = new(big.Int).Exp(root, big.NewInt(int64(e)), T)
+				want[i] = new(big.Int).Mod(slotsIn[i], T)
+			}
+			refNtt(params, roots, want)
+
+			msgOut := NewMessage(params)
+			testctx.decoder.ntt(slotsIn, msgOut)
+			for i := 0; i < slots; i++ {
+				assert.Equal(t, want[i].Text(16), msgOut.Value[i].Text(16))
+			}
+		})
+	}
+}
+
+func TestZT(t *testing.T) {
+	for _, pl := range encParamSet[:12] {
+		params, err := NewParametersFromLiteral(pl)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testctx, err := genEncoderTestParams(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		zt := testctx.zt
+		T := params.T()
+		slots := params.Slots()
+
+		check := func(t *testing.T, want []*big.Int, have []Element) {
+			for i := range want {
+				assert.Equal(t, new(big.Int).Mod(want[i], T).Text(16), have[i].Text(16))
+			}
+		}
+
+		a := genTestVectors(testctx).Value
+		b := genTestVectors(testctx).Value
+		aBig, bBig := BigInts(a), BigInts(b)
+		c := zt.NewVector(slots)
+		want := make([]*big.Int, slots)
+
+		t.Run(testString(fmt.Sprintf("ZT/Conversions/T=%d", T.BitLen()), params), func(t *testing.T) {
+			x := []*big.Int{big.NewInt(-1), new(big.Int).Set(T), new(big.Int).Lsh(T, 3), new(big.Int).Sub(T, big.NewInt(1))}
+			zt.SetBigInts(x, c[:len(x)])
+			check(t, x, c[:len(x)])
+
+			assert.Equal(t, zt.Words(), len(a[0]))
+			assert.Equal(t, T.Text(16), zt.Modulus().Text(16))
+		})
+
+		t.Run(testString(fmt.Sprintf("ZT/Int64/T=%d", T.BitLen()), params), func(t *testing.T) {
+			for _, x := range []int64{math.MinInt64, -1, 0, 1, math.MaxInt64} {
+				zt.SetInt64(x, c[0])
+				check(t, []*big.Int{big.NewInt(x)}, c[:1])
+
+				y, err := zt.Int64(c[0])
+				assert.Nil(t, err)
+				assert.Equal(t, x, y)
+			}
+
+			zt.SetUint64(math.MaxUint64, c[0])
+			_, err := zt.Int64(c[0])
+			assert.True(t, errors.Is(err, ErrOverflow))
+			y, err := zt.Uint64(c[0])
+			assert.Nil(t, err)
+			assert.Equal(t, uint64(math.MaxUint64), y)
+
+			assert.Nil(t, zt.SetFloat64(-2.5, c[0]))
+			assert.Equal(t, float64(-3), zt.Float64(c[0]))
+			_, err = zt.Uint64(c[0])
+			assert.True(t, errors.Is(err, ErrOverflow))
+		})
+
+		t.Run(testString(fmt.Sprintf("ZT/Add/T=%d", T.BitLen()), params), func(t *testing.T) {
+			for i := range want {
+				want[i] = new(big.Int).Add(aBig[i], bBig[i])
+			}
+			zt.Add(a, b, c)
+			check(t, want, c)
+		})
+
+		t.Run(testString(fmt.Sprintf("ZT/Sub/T=%d", T.BitLen()), params), func(t *testing.T) {
+			for i := range want {
+				want[i] = new(big.Int).Sub(aBig[i], bBig[i])
+			}
+			zt.Sub(a, b, c)
+			check(t, want, c)
+		})
+
+		t.Run(testString(fmt.Sprintf("ZT/Neg/T=%d", T.BitLen()), params), func(t *testing.T) {
+			for i := range want {
+				want[i] = new(big.Int).Neg(aBig[i])
+			}
+			zt.Neg(a, c)
+			check(t, want, c)
+		})
+
+		t.Run(testString(fmt.Sprintf("ZT/Mul/T=%d", T.BitLen()), params), func(t *testing.T) {
+			for i := range want {
+				want[i] = new(big.Int).Mul(aBig[i], bBig[i])
+			}
+			zt.Mul(a, b, c)
+			check(t, want, c)
+
+			for i := range want {
+				want[i] = new(big.Int).Mul(aBig[i], bBig[0])
+			}
+			zt.MulScalar(a, b[0], c)
+			check(t, want, c)
+
+			// The largest product, (T - 1)^2 = 1 mod T.
+			zt.SetInt64(-1, c[0])
+			zt.Mul(c[:1], c[:1], c[:1])
+			check(t, []*big.Int{big.NewInt(1)}, c[:1])
+		})
+
+		t.Run(testString(fmt.Sprintf("ZT/Inverse/T=%d", T.BitLen()), params), func(t *testing.T) {
+			for i := range want {
+				want[i] = new(big.Int).ModInverse(aBig[i], T)
+			}
+			assert.Nil(t, zt.Inverse(a, c))
+			check(t, want, c)
+
+			// in place
+			copy(c[0], a[0])
+			assert.Nil(t, zt.Inverse(c[:1], c[:1]))
+			check(t, want[:1], c[:1])
+
+			zero := zt.NewVector(2)
+			zero[0].Set(a[0])
+			assert.ErrorIs(t, zt.Inverse(zero, c[:2]), ErrNotInvertible)
+		})
+
+		t.Run(testString(fmt.Sprintf("ZT/SampleUniform/T=%d", T.BitLen()), params), func(t *testing.T) {
+			assert.Nil(t, zt.SampleUniform(testctx.prng, c))
+			for i := range c {
+				assert.True(t, c[i].BigInt().Cmp(T) < 0)
+			}
+		})
+
+		t.Run(testString(fmt.Sprintf("ZT/Message/Marshaller/T=%d", T.BitLen()), params), func(t *testing.T) {
+			msg := &Message{Value: a}
+			data, err := msg.MarshalBinary()
+			assert.Nil(t, err)
+			assert.Equal(t, len(data), msg.MarshalBinarySize())
+
+			msgRec := new(Message)
+			assert.Nil(t, msgRec.UnmarshalBinary(data))
+			assert.Nil(t, msgRec.Check(params))
+			check(t, aBig, msgRec.Value)
+
+			assert.NotNil(t, msgRec.UnmarshalBinary(data[:len(data)-1]))
+
+			// 8 * slots * words overflows to the length of the data.
+			assert.NotNil(t, new(Message).UnmarshalBinary([]byte{0x80, 0, 0, 0, 0x40, 0, 0, 0}))
+
+			// The same data with the slots and words swapped, which does not match
+			// the shape of an allocated Message nor the parameters.
+			swapped := append([]byte{}, data...)
+			binary.BigEndian.PutUint32(swapped, uint32(zt.Words()))
+			binary.BigEndian.PutUint32(swapped[4:], uint32(slots))
+			assert.NotNil(t, NewMessage(params).UnmarshalBinary(swapped))
+			msgSwapped := new(Message)
+			assert.Nil(t, msgSwapped.UnmarshalBinary(swapped))
+			assert.ErrorIs(t, msgSwapped.Check(params), ErrEncodingMismatch)
+
+			// A slot equal to T.
+			unreduced := append([]byte{}, data...)
+			for j, w := range zt.mont.m {
+				binary.BigEndian.PutUint64(unreduced[8+8*j:], w)
+			}
+			assert.Nil(t, msgRec.UnmarshalBinary(unreduced))
+			assert.ErrorIs(t, msgRec.Check(params), ErrOverflow)
+		})
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	for _, pl := range encParamSet {
+		params, err := NewParametersFromLiteral(pl)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		testctx, err := genEncoderTestParams(params)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		msg := genTestVectors(testctx)
+		pt := NewPlaintext(params)
+		ptMul := NewPlaintextMul(params)
+
+		b.Run(testString(fmt.Sprintf("Encode/T=%d/D=%d", params.T().BitLen(), params.Slots()), params), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				testctx.encoder.Encode(msg, pt)
+			}
+		})
+
+		b.Run(testString(fmt.Sprintf("EncodeMul/T=%d/D=%d", params.T().BitLen(), params.Slots()), params), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				testctx.encoder.EncodeMul(msg, ptMul)
+			}
+		})
+
+		b.Run(testString(fmt.Sprintf("Decode/T=%d/D=%d", params.T().BitLen(), params.Slots()), params), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				testctx.decoder.Decode(pt, msg)
+			}
+		})
+	}
+}
+
+// BenchmarkZT compares the fixed-width multiplication of ZT with the special-form reduction modulo T = b^k + 1,
+// which splits the product x at b^k and subtracts the high part since b^k = -1 mod T, and with the generic
+// reduction of big.Int. As b is not a power of two, the split is a division by b^k.
+func BenchmarkZT(b *testing.B) {
+	for _, pl := range encParamSet[:12] {
+		params, err := NewParametersFromLiteral(pl)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		testctx, err := genEncoderTestParams(params)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		zt := testctx.zt
+		T := params.T()
+		bk := new(big.Int).Sub(T, big.NewInt(1))
+
+		x, y, z := zt.NewVector(params.Slots()), zt.NewVector(params.Slots()), zt.NewVector(params.Slots())
+		if err = zt.SampleUniform(testctx.prng, x); err != nil {
+			b.Fatal(err)
+		}
+		if err = zt.SampleUniform(testctx.prng, y); err != nil {
+			b.Fatal(err)
+		}
+		xBig, yBig := BigInts(x), BigInts(y)
+
+		b.Run(testString(fmt.Sprintf("ZT/Mul/FixedWidth/T=%d", T.BitLen()), params), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				zt.Mul(x, y, z)
+			}
+		})
+
+		prod, hi, lo := new(big.Int), new(big.Int), new(big.Int)
+
+		b.Run(testString(fmt.Sprintf("ZT/Mul/SpecialForm/T=%d", T.BitLen()), params), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for j := range xBig {
+					prod.Mul(xBig[j], yBig[j])
+					hi.QuoRem(prod, bk, lo)
+					if lo.Sub(lo, hi).Sign() < 0 {
+						lo.Add(lo, T)
+					}
+				}
+			}
+		})
+
+		b.Run(testString(fmt.Sprintf("ZT/Mul/BigInt/T=%d", T.BitLen()), params), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for j := range xBig {
+					prod.Mul(xBig[j], yBig[j])
+					prod.Mod(prod, T)
+				}
+			}
+		})
+	}
+}
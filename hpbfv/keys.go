@@ -1,13 +1,17 @@
 package hpbfv
 
 import (
+	"fmt"
+
 	"hp-bfv/rlwe"
 )
 
 type KeyGenerator interface {
 	rlwe.KeyGenerator
 	GenDefaultRotationKeysForRotation(sk *rlwe.SecretKey) (rks *rlwe.RotationKeySet)
-	GenRotationKeysForMatMul(sk *rlwe.SecretKey, dim int) (rks *rlwe.RotationKeySet)
+	GenRotationKeysForMatMul(sk *rlwe.SecretKey, dim int) (rks *rlwe.RotationKeySet, err error)
+	GenRotationKeysForTranspose(sk *rlwe.SecretKey, dim int) (rks *rlwe.RotationKeySet, err error)
+	GenRotationKeysForMatMulBSGS(sk *rlwe.SecretKey, dim, babySteps int) (rks *rlwe.RotationKeySet, err error)
 }
 
 type keyGenerator struct {
@@ -35,19 +39,47 @@ func (keygen *keyGenerator) GenDefaultRotationKeysForRotation(sk *rlwe.SecretKey
 }
 
 // GenRotationKeysForMatMul generates a RotationKeySet supporting rotations for the matrix multiplication.
-func (keygen *keyGenerator) GenRotationKeysForMatMul(sk *rlwe.SecretKey, dim int) (rks *rlwe.RotationKeySet) {
-	pack := keygen.params.Slots() / dim
-	if dim*pack != keygen.params.Slots() {
-		panic("dim must divide the number of slots")
+func (keygen *keyGenerator) GenRotationKeysForMatMul(sk *rlwe.SecretKey, dim int) (rks *rlwe.RotationKeySet, err error) {
+	if _, err = packFor(keygen.params, dim); err != nil {
+		return nil, fmt.Errorf("cannot GenRotationKeysForMatMul: %w", err)
+	}
+
+	return keygen.genMatrixRotationKeys(sk, keygen.params.GaloisElementsForMatMul(dim)), nil
+}
+
+// GenRotationKeysForTranspose generates a RotationKeySet supporting rotations for the matrix transposition.
+// The keys are a subset of the ones generated by GenRotationKeysForMatMul.
+func (keygen *keyGenerator) GenRotationKeysForTranspose(sk *rlwe.SecretKey, dim int) (rks *rlwe.RotationKeySet, err error) {
+	if _, err = packFor(keygen.params, dim); err != nil {
+		return nil, fmt.Errorf("cannot GenRotationKeysForTranspose: %w", err)
+	}
+
+	return keygen.genMatrixRotationKeys(sk, keygen.params.GaloisElementsForTranspose(dim)), nil
+}
+
+// GenRotationKeysForMatMulBSGS generates a RotationKeySet supporting rotations for the baby-step giant-step
+// matrix multiplication with babySteps baby steps, see MatrixEvaluator.MulBSGS. The set contains
+// babySteps + ceil(dim / babySteps) - 1 keys, which is about 2 * sqrt(dim) for babySteps = DefaultBabySteps(dim).
+func (keygen *keyGenerator) GenRotationKeysForMatMulBSGS(sk *rlwe.SecretKey, dim, babySteps int) (rks *rlwe.RotationKeySet, err error) {
+	if _, err = packFor(keygen.params, dim); err != nil {
+		return nil, fmt.Errorf("cannot GenRotationKeysForMatMulBSGS: %w", err)
+	}
+
+	if err = checkBabySteps(dim, babySteps); err != nil {
+		return nil, fmt.Errorf("cannot GenRotationKeysForMatMulBSGS: %w", err)
 	}
 
-	rks = &rlwe.RotationKeySet{Keys: make(map[uint64]*rlwe.SwitchingKey, dim)}
+	return keygen.genMatrixRotationKeys(sk, keygen.params.GaloisElementsForMatMulBSGS(dim, babySteps)), nil
+}
+
+// genMatrixRotationKeys generates the switching keys from the rotations of sk by galEls to sk,
+// as used by the MatrixEvaluator, which rotates the ciphertexts before switching their keys.
+func (keygen *keyGenerator) genMatrixRotationKeys(sk *rlwe.SecretKey, galEls []uint64) (rks *rlwe.RotationKeySet) {
+	rks = &rlwe.RotationKeySet{Keys: make(map[uint64]*rlwe.SwitchingKey, len(galEls))}
 	ringQ := keygen.params.RingQ()
 	ringP := keygen.params.RingP()
 	skOut := rlwe.NewSecretKey(keygen.params.Parameters)
-	for k := 0; k < keygen.params.Slots(); k += pack {
-		galEl := keygen.params.GaloisElementForColumnRotationBy(uint64(k))
-
+	for _, galEl := range galEls {
 		ringQ.PermuteNTT(sk.Value.Q, galEl, skOut.Value.Q)
 		if ringP != nil {
 			ringP.PermuteNTT(sk.Value.P, galEl, skOut.Value.P)
@@ -56,7 +88,7 @@ func (keygen *keyGenerator) GenRotationKeysForMatMul(sk *rlwe.SecretKey, dim int
 		rks.Keys[galEl] = keygen.GenSwitchingKey(skOut, sk)
 	}
 
-	return rks
+	return
 }
 
 // NewKeyGenerator creates a rlwe.KeyGenerator instance from the HP-BFV parameters.
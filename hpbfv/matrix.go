@@ -1,5 +1,7 @@
 package hpbfv
 
+import "fmt"
+
 type MatrixMessage struct {
 	// MatrixMessage may pack several matrices into one.
 	Value []*Message
@@ -9,16 +11,19 @@ type MatrixMessage struct {
 	// If true, matrices are packed diagonally.
 	// If false, matrices are packed shifted diagonally.
 	IsDiagonal bool
+
+	// Layout, if not nil, places matrices of possibly different dimensions in the slots,
+	// see MatrixLayout. If nil, Pack matrices of dimension len(Value) fill the slots.
+	Layout *MatrixLayout
 }
 
 // NewMatrixMessage creates a new MatrixMessage.
-func NewMatrixMessage(params Parameters, dim int, isDiagonal bool) (em *MatrixMessage) {
-	if params.Slots()%dim != 0 {
-		panic("dim must divide d")
+func NewMatrixMessage(params Parameters, dim int, isDiagonal bool) (em *MatrixMessage, err error) {
+	pack, err := packFor(params, dim)
+	if err != nil {
+		return nil, fmt.Errorf("cannot NewMatrixMessage: %w", err)
 	}
 
-	pack := params.Slots() / dim
-
 	em = new(MatrixMessage)
 	em.Pack = pack
 	em.IsDiagonal = isDiagonal
@@ -35,16 +40,16 @@ type MatrixPlaintext struct {
 
 	Pack       int
 	IsDiagonal bool
+	Layout     *MatrixLayout
 }
 
 // NewMatrixPlaintext creates a new PlainMatrix.
-func NewMatrixPlaintext(params Parameters, dim int, isDiagonal bool) (pm *MatrixPlaintext) {
-	if params.Slots()%dim != 0 {
-		panic("dim must divide d")
+func NewMatrixPlaintext(params Parameters, dim int, isDiagonal bool) (pm *MatrixPlaintext, err error) {
+	pack, err := packFor(params, dim)
+	if err != nil {
+		return nil, fmt.Errorf("cannot NewMatrixPlaintext: %w", err)
 	}
 
-	pack := params.Slots() / dim
-
 	pm = new(MatrixPlaintext)
 	pm.Pack = pack
 	pm.IsDiagonal = isDiagonal
@@ -56,21 +61,48 @@ func NewMatrixPlaintext(params Parameters, dim int, isDiagonal bool) (pm *Matrix
 	return
 }
 
+// MatrixPlaintextMul is a MatrixPlaintext whose diagonals are encoded as PlaintextMul,
+// for plaintext x ciphertext matrix multiplication.
+type MatrixPlaintextMul struct {
+	Value []*PlaintextMul
+
+	Pack       int
+	IsDiagonal bool
+}
+
+// NewMatrixPlaintextMul creates a new MatrixPlaintextMul.
+func NewMatrixPlaintextMul(params Parameters, dim int, isDiagonal bool) (pm *MatrixPlaintextMul, err error) {
+	pack, err := packFor(params, dim)
+	if err != nil {
+		return nil, fmt.Errorf("cannot NewMatrixPlaintextMul: %w", err)
+	}
+
+	pm = new(MatrixPlaintextMul)
+	pm.Pack = pack
+	pm.IsDiagonal = isDiagonal
+	pm.Value = make([]*PlaintextMul, dim)
+	for i := range pm.Value {
+		pm.Value[i] = NewPlaintextMul(params)
+	}
+
+	return
+}
+
 type MatrixCiphertext struct {
 	Value []*Ciphertext
 
 	Pack       int
 	IsDiagonal bool
+	Layout     *MatrixLayout
 }
 
 // NewMatrixCiphertext creates a new EncryptedMatrix.
-func NewMatrixCiphertext(params Parameters, dim int, isDiagonal bool) (cm *MatrixCiphertext) {
-	if int(params.Slots())%dim != 0 {
-		panic("dim must divide d")
+func NewMatrixCiphertext(params Parameters, dim int, isDiagonal bool) (cm *MatrixCiphertext, err error) {
+	pack, err := packFor(params, dim)
+	if err != nil {
+		return nil, fmt.Errorf("cannot NewMatrixCiphertext: %w", err)
 	}
 
-	pack := params.Slots() / dim
-
 	cm = new(MatrixCiphertext)
 	cm.Pack = pack
 	cm.IsDiagonal = isDiagonal
@@ -81,3 +113,11 @@ func NewMatrixCiphertext(params Parameters, dim int, isDiagonal bool) (cm *Matri
 
 	return
 }
+
+// packFor returns the number of dim x dim matrices packed in one message.
+func packFor(params Parameters, dim int) (pack int, err error) {
+	if dim <= 0 || params.Slots()%dim != 0 {
+		return 0, ErrDimNotDivisor
+	}
+	return params.Slots() / dim, nil
+}
@@ -0,0 +1,290 @@
+package hpbfv
+
+import (
+	"fmt"
+	"math/big"
+
+	"hp-bfv/utils"
+)
+
+// BlockMatrixPlaintext is a Rows x Cols matrix split into tiles of dimension TileDim.
+// Blocks[i][j] stores the tile of the entries (i*TileDim + r, j*TileDim + c), and the
+// tiles on the last row and column are padded with zeros.
+// As with MatrixPlaintext, each tile packs Slots() / TileDim matrices.
+type BlockMatrixPlaintext struct {
+	Blocks [][]*MatrixPlaintext
+
+	Rows    int
+	Cols    int
+	TileDim int
+}
+
+// BlockMatrixCiphertext is the encryption of a BlockMatrixPlaintext.
+type BlockMatrixCiphertext struct {
+	Blocks [][]*MatrixCiphertext
+
+	Rows    int
+	Cols    int
+	TileDim int
+}
+
+// TileSource returns the tile (i, j) of a block matrix.
+// It is used to stream the operands of MatrixEvaluator.MulBlockStream, for instance from disk.
+type TileSource func(i, j int) (ct *MatrixCiphertext, err error)
+
+// TileSink receives the tile (i, j) of a block matrix.
+// ct is reused by the caller once TileSink returns and must be copied if it is kept.
+type TileSink func(i, j int, ct *MatrixCiphertext) (err error)
+
+// numTiles returns the number of tiles of dimension tileDim needed to cover n entries.
+func numTiles(n, tileDim int) int {
+	return (n + tileDim - 1) / tileDim
+}
+
+// EncodeBlockMatrixNew splits the rows x cols matrices into tiles of dimension tileDim,
+// which must divide the number of slots, and encodes each tile in the diagonal or
+// shifted-diagonal encoding. Up to Slots() / tileDim matrices can be given.
+func (ecd *MatrixEncoder) EncodeBlockMatrixNew(matrices [][][]*big.Int, tileDim int, isDiagonal bool) (pt *BlockMatrixPlaintext, err error) {
+	if len(matrices) == 0 || len(matrices[0]) == 0 || len(matrices[0][0]) == 0 {
+		return nil, fmt.Errorf("cannot EncodeBlockMatrixNew: %w: matrices must be non-empty", ErrEncodingMismatch)
+	}
+
+	rows, cols := len(matrices[0]), len(matrices[0][0])
+	for l := range matrices {
+		if len(matrices[l]) != rows {
+			return nil, fmt.Errorf("cannot EncodeBlockMatrixNew: %w: matrices must have the same size", ErrEncodingMismatch)
+		}
+		for i := range matrices[l] {
+			if len(matrices[l][i]) != cols {
+				return nil, fmt.Errorf("cannot EncodeBlockMatrixNew: %w: matrices must have the same size", ErrEncodingMismatch)
+			}
+		}
+	}
+
+	pt = &BlockMatrixPlaintext{Rows: rows, Cols: cols, TileDim: tileDim}
+	pt.Blocks = make([][]*MatrixPlaintext, numTiles(rows, tileDim))
+
+	tile := make([][][]*big.Int, len(matrices))
+	for bi := range pt.Blocks {
+		pt.Blocks[bi] = make([]*MatrixPlaintext, numTiles(cols, tileDim))
+		for bj := range pt.Blocks[bi] {
+			r0, c0 := bi*tileDim, bj*tileDim
+			r1, c1 := utils.MinInt(r0+tileDim, rows), utils.MinInt(c0+tileDim, cols)
+
+			for l := range matrices {
+				tile[l] = make([][]*big.Int, r1-r0)
+				for i := range tile[l] {
+					tile[l][i] = matrices[l][r0+i][c0:c1]
+				}
+			}
+
+			if pt.Blocks[bi][bj], err = ecd.EncodeRectMatrixNew(tile, tileDim, isDiagonal); err != nil {
+				return nil, fmt.Errorf("cannot EncodeBlockMatrixNew: %w", err)
+			}
+		}
+	}
+
+	return
+}
+
+// DecodeBlockMatrixNew decodes a BlockMatrixPlaintext into its Slots() / TileDim matrices of size Rows x Cols.
+func (ecd *MatrixEncoder) DecodeBlockMatrixNew(pt *BlockMatrixPlaintext) (matrices [][][]*big.Int, err error) {
+	if err = checkBlocks(pt.Rows, pt.Cols, pt.TileDim, len(pt.Blocks), func(i int) int { return len(pt.Blocks[i]) }); err != nil {
+		return nil, fmt.Errorf("cannot DecodeBlockMatrixNew: %w", err)
+	}
+
+	pack, err := packFor(ecd.ecd.params, pt.TileDim)
+	if err != nil {
+		return nil, fmt.Errorf("cannot DecodeBlockMatrixNew: %w", err)
+	}
+
+	matrices = make([][][]*big.Int, pack)
+	for l := range matrices {
+		matrices[l] = make([][]*big.Int, pt.Rows)
+		for i := range matrices[l] {
+			matrices[l][i] = make([]*big.Int, pt.Cols)
+		}
+	}
+
+	for bi := range pt.Blocks {
+		for bj := range pt.Blocks[bi] {
+			r0, c0 := bi*pt.TileDim, bj*pt.TileDim
+			r1, c1 := utils.MinInt(r0+pt.TileDim, pt.Rows), utils.MinInt(c0+pt.TileDim, pt.Cols)
+
+			tile, err := ecd.DecodeRectMatrixNew(pt.Blocks[bi][bj], r1-r0, c1-c0)
+			if err != nil {
+				return nil, fmt.Errorf("cannot DecodeBlockMatrixNew: %w", err)
+			}
+
+			if len(tile) != pack {
+				return nil, fmt.Errorf("cannot DecodeBlockMatrixNew: %w: tiles must have the same pack", ErrEncodingMismatch)
+			}
+
+			for l := range tile {
+				for i := range tile[l] {
+					copy(matrices[l][r0+i][c0:c1], tile[l][i])
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// EncryptBlockNew encrypts every tile of the input block matrix and returns the result.
+func (enc *MatrixEncryptor) EncryptBlockNew(pt *BlockMatrixPlaintext) (ct *BlockMatrixCiphertext, err error) {
+	ct = &BlockMatrixCiphertext{Rows: pt.Rows, Cols: pt.Cols, TileDim: pt.TileDim}
+	ct.Blocks = make([][]*MatrixCiphertext, len(pt.Blocks))
+	for i := range pt.Blocks {
+		ct.Blocks[i] = make([]*MatrixCiphertext, len(pt.Blocks[i]))
+		for j := range pt.Blocks[i] {
+			if ct.Blocks[i][j], err = enc.EncryptNew(pt.Blocks[i][j]); err != nil {
+				return nil, fmt.Errorf("cannot EncryptBlockNew: %w", err)
+			}
+		}
+	}
+	return
+}
+
+// DecryptBlockNew decrypts every tile of the input block matrix and returns the result.
+func (enc *MatrixEncryptor) DecryptBlockNew(ct *BlockMatrixCiphertext) (pt *BlockMatrixPlaintext, err error) {
+	pt = &BlockMatrixPlaintext{Rows: ct.Rows, Cols: ct.Cols, TileDim: ct.TileDim}
+	pt.Blocks = make([][]*MatrixPlaintext, len(ct.Blocks))
+	for i := range ct.Blocks {
+		pt.Blocks[i] = make([]*MatrixPlaintext, len(ct.Blocks[i]))
+		for j := range ct.Blocks[i] {
+			if pt.Blocks[i][j], err = enc.DecryptNew(ct.Blocks[i][j]); err != nil {
+				return nil, fmt.Errorf("cannot DecryptBlockNew: %w", err)
+			}
+		}
+	}
+	return
+}
+
+// MulBlockNew multiplies two block matrices and returns the result.
+// The tiles of ctA must be packed diagonally and the tiles of ctB shifted diagonally,
+// and the tiles of the result are packed diagonally. The rotation keys must be generated
+// with GenRotationKeysForMatMul for the TileDim of the operands.
+func (eval *MatrixEvaluator) MulBlockNew(ctA, ctB *BlockMatrixCiphertext) (ctC *BlockMatrixCiphertext, err error) {
+	if err = checkBlocks(ctA.Rows, ctA.Cols, ctA.TileDim, len(ctA.Blocks), func(i int) int { return len(ctA.Blocks[i]) }); err != nil {
+		return nil, fmt.Errorf("cannot MulBlockNew: %w", err)
+	}
+
+	if err = checkBlocks(ctB.Rows, ctB.Cols, ctB.TileDim, len(ctB.Blocks), func(i int) int { return len(ctB.Blocks[i]) }); err != nil {
+		return nil, fmt.Errorf("cannot MulBlockNew: %w", err)
+	}
+
+	if ctA.Cols != ctB.Rows || ctA.TileDim != ctB.TileDim {
+		return nil, fmt.Errorf("cannot MulBlockNew: %w: %d x %d and %d x %d block matrices cannot be multiplied", ErrEncodingMismatch, ctA.Rows, ctA.Cols, ctB.Rows, ctB.Cols)
+	}
+
+	ctC = &BlockMatrixCiphertext{Rows: ctA.Rows, Cols: ctB.Cols, TileDim: ctA.TileDim}
+	ctC.Blocks = make([][]*MatrixCiphertext, len(ctA.Blocks))
+	for i := range ctC.Blocks {
+		ctC.Blocks[i] = make([]*MatrixCiphertext, len(ctB.Blocks[0]))
+	}
+
+	a := func(i, k int) (*MatrixCiphertext, error) { return ctA.Blocks[i][k], nil }
+	b := func(k, j int) (*MatrixCiphertext, error) { return ctB.Blocks[k][j], nil }
+	out := func(i, j int, ct *MatrixCiphertext) (err error) {
+		ctC.Blocks[i][j] = eval.copyMatrixNew(ct)
+		return
+	}
+
+	if err = eval.MulBlockStream(len(ctA.Blocks), len(ctB.Blocks), len(ctB.Blocks[0]), ctA.TileDim, a, b, out); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// MulBlockStream multiplies a rowTiles x innerTiles block matrix A by an innerTiles x colTiles block
+// matrix B, whose tiles of dimension tileDim are given by a and b, and passes the tiles of the result
+// to out in row-major order.
+//
+// The output tiles are computed one at a time by accumulating the products A[i][k] * B[k][j], so that
+// on top of the tiles returned by a and b, only two tiles are allocated regardless of the dimensions
+// of the matrices. Each tile of A is requested colTiles times and each tile of B rowTiles times, which
+// allows a and b to read the tiles from disk with MatrixCiphertext.ReadFrom and out to write them back
+// with MatrixCiphertext.WriteTo.
+func (eval *MatrixEvaluator) MulBlockStream(rowTiles, innerTiles, colTiles, tileDim int, a, b TileSource, out TileSink) (err error) {
+	if rowTiles < 1 || innerTiles < 1 || colTiles < 1 {
+		return fmt.Errorf("cannot MulBlockStream: %w: the number of tiles must be positive", ErrEncodingMismatch)
+	}
+
+	acc, err := NewMatrixCiphertext(eval.eval.params, tileDim, true)
+	if err != nil {
+		return fmt.Errorf("cannot MulBlockStream: %w", err)
+	}
+
+	prod, err := NewMatrixCiphertext(eval.eval.params, tileDim, true)
+	if err != nil {
+		return fmt.Errorf("cannot MulBlockStream: %w", err)
+	}
+
+	var ctA, ctB *MatrixCiphertext
+	for i := 0; i < rowTiles; i++ {
+		for j := 0; j < colTiles; j++ {
+			for k := 0; k < innerTiles; k++ {
+				if ctA, err = a(i, k); err != nil {
+					return fmt.Errorf("cannot MulBlockStream: tile (%d, %d) of A: %w", i, k, err)
+				}
+
+				if ctB, err = b(k, j); err != nil {
+					return fmt.Errorf("cannot MulBlockStream: tile (%d, %d) of B: %w", k, j, err)
+				}
+
+				dst := prod
+				if k == 0 {
+					dst = acc
+				}
+
+				if err = eval.Mul(ctA, ctB, dst); err != nil {
+					return fmt.Errorf("cannot MulBlockStream: tile (%d, %d): %w", i, j, err)
+				}
+
+				if k != 0 {
+					if err = eval.Add(acc, prod, acc); err != nil {
+						return fmt.Errorf("cannot MulBlockStream: tile (%d, %d): %w", i, j, err)
+					}
+				}
+			}
+
+			if err = out(i, j, acc); err != nil {
+				return fmt.Errorf("cannot MulBlockStream: tile (%d, %d): %w", i, j, err)
+			}
+		}
+	}
+
+	return
+}
+
+// copyMatrixNew returns a deep copy of ct.
+func (eval *MatrixEvaluator) copyMatrixNew(ct *MatrixCiphertext) (ctOut *MatrixCiphertext) {
+	ctOut = &MatrixCiphertext{Pack: ct.Pack, IsDiagonal: ct.IsDiagonal, Layout: ct.Layout}
+	ctOut.Value = make([]*Ciphertext, len(ct.Value))
+	for i := range ct.Value {
+		ctOut.Value[i] = ct.Value[i].CopyNew()
+	}
+	return
+}
+
+// checkBlocks checks that a rows x cols block matrix with tiles of dimension tileDim
+// has blockRows rows of blockCols(i) tiles.
+func checkBlocks(rows, cols, tileDim, blockRows int, blockCols func(i int) int) (err error) {
+	if rows < 1 || cols < 1 || tileDim < 1 {
+		return fmt.Errorf("%w: dimensions must be positive", ErrEncodingMismatch)
+	}
+
+	if blockRows != numTiles(rows, tileDim) {
+		return fmt.Errorf("%w: expected %d rows of tiles, got %d", ErrEncodingMismatch, numTiles(rows, tileDim), blockRows)
+	}
+
+	for i := 0; i < blockRows; i++ {
+		if blockCols(i) != numTiles(cols, tileDim) {
+			return fmt.Errorf("%w: expected %d columns of tiles, got %d", ErrEncodingMismatch, numTiles(cols, tileDim), blockCols(i))
+		}
+	}
+
+	return
+}
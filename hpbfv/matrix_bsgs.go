@@ -0,0 +1,114 @@
+package hpbfv
+
+import "fmt"
+
+// DefaultBabySteps returns ceil(sqrt(dim)), the number of baby steps minimizing the number of
+// rotation keys of the baby-step giant-step matrix multiplication.
+func DefaultBabySteps(dim int) (babySteps int) {
+	babySteps = 1
+	for babySteps*babySteps < dim {
+		babySteps++
+	}
+	return
+}
+
+// checkBabySteps checks that 1 <= babySteps <= dim.
+func checkBabySteps(dim, babySteps int) (err error) {
+	if babySteps < 1 || babySteps > dim {
+		return fmt.Errorf("%w: the number of baby steps must be between 1 and dim", ErrInvalidParameters)
+	}
+	return
+}
+
+// MulBSGSNew multiplies two matrices with the baby-step giant-step method and returns the result.
+// See MulBSGS.
+func (eval *MatrixEvaluator) MulBSGSNew(ctA, ctB *MatrixCiphertext, babySteps int) (ctC *MatrixCiphertext, err error) {
+	if ctC, err = NewMatrixCiphertext(eval.eval.params, len(ctA.Value), true); err != nil {
+		return nil, fmt.Errorf("cannot MulBSGSNew: %w", err)
+	}
+
+	if err = eval.MulBSGS(ctA, ctB, ctC, babySteps); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// MulBSGS multiplies two matrices as Mul, but only needs the babySteps + ceil(dim / babySteps) - 1
+// rotation keys generated by GenRotationKeysForMatMulBSGS instead of the dim keys of GenRotationKeysForMatMul.
+// ctA must be packed diagonally, ctB shifted diagonally, and ctC is packed diagonally. ctC cannot be ctB.
+//
+// Each rotation by i = g * babySteps + b of Mul is split into a baby step b, applied with a key switch to
+// every diagonal of ctB, and a giant step g * babySteps, applied to the products as in Mul. Hence babySteps
+// trades the size of the keys against the dim * (babySteps - 1) additional key switches of the baby steps:
+// babySteps = 1 is Mul, and DefaultBabySteps(dim) minimizes the number of keys.
+func (eval *MatrixEvaluator) MulBSGS(ctA, ctB, ctC *MatrixCiphertext, babySteps int) (err error) {
+	if err = eval.checkMatrixCiphertexts(ctA, ctB, ctC); err != nil {
+		return fmt.Errorf("cannot MulBSGS: %w", err)
+	}
+
+	if err = checkUniform(ctA, ctB); err != nil {
+		return fmt.Errorf("cannot MulBSGS: %w", err)
+	}
+
+	if !(ctA.IsDiagonal && !ctB.IsDiagonal && ctC.IsDiagonal) {
+		return fmt.Errorf("cannot MulBSGS: %w: ctA and ctC must be diagonal and ctB shifted diagonal", ErrEncodingMismatch)
+	}
+
+	pack := ctA.Pack
+	dim := len(ctA.Value)
+	if len(ctB.Value) != dim || len(ctC.Value) != dim {
+		return fmt.Errorf("cannot MulBSGS: %w: dimensions do not match", ErrEncodingMismatch)
+	}
+	if ctB.Pack != pack || ctC.Pack != pack {
+		return fmt.Errorf("cannot MulBSGS: %w: packs do not match", ErrEncodingMismatch)
+	}
+
+	if ctC == ctB {
+		return fmt.Errorf("cannot MulBSGS: %w: ctC cannot be ctB", ErrEncodingMismatch)
+	}
+
+	if err = checkBabySteps(dim, babySteps); err != nil {
+		return fmt.Errorf("cannot MulBSGS: %w", err)
+	}
+
+	if eval.rlk == nil || len(eval.rlk.Keys) == 0 {
+		return fmt.Errorf("cannot MulBSGS: %w", ErrMissingRelinearizationKey)
+	}
+
+	for _, galEl := range eval.eval.params.GaloisElementsForMatMulBSGS(dim, babySteps) {
+		if eval.rks == nil || eval.rks.Keys[galEl] == nil {
+			return fmt.Errorf("cannot MulBSGS: %w: Galois element %d", ErrMissingRotationKey, galEl)
+		}
+	}
+
+	ctC.Pack = pack
+	ctC.IsDiagonal = true
+	ctC.Layout = nil
+
+	aMul, bMul := eval.mulPools(dim)
+	for k := 0; k < dim; k++ {
+		eval.fillAMul(ctA.Value[k], aMul[k])
+	}
+
+	for b := 0; b < babySteps; b++ {
+		// Baby step: bMul[k] = rot_b(ctB[k])
+		for k := 0; k < dim; k++ {
+			if b == 0 {
+				eval.fillBMul(ctB.Value[k], bMul[k])
+				continue
+			}
+
+			eval.rotate(ctB.Value[k], eval.eval.params.GaloisElementForColumnRotationBy(uint64(pack*b)), eval.poolCt)
+			eval.fillBMul(eval.poolCt, bMul[k])
+		}
+
+		// Giant steps: ctC[i] = sum_j ctA[j] * rot_{g * babySteps}(rot_b(ctB[i - j])) for i = g * babySteps + b
+		for i := b; i < dim; i += babySteps {
+			galEl := eval.eval.params.GaloisElementForColumnRotationBy(uint64(pack * (i - b)))
+			eval.mulDiagonal(dim, i, galEl, aMul, bMul, ctC.Value[i])
+		}
+	}
+
+	return
+}
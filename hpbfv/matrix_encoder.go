@@ -1,12 +1,16 @@
 package hpbfv
 
 import (
+	"fmt"
 	"math/big"
 )
 
+// MatrixEncoder encodes matrices into MatrixPlaintexts and decodes them.
+// A MatrixEncoder is not safe for concurrent use: use ShallowCopy to obtain one MatrixEncoder per goroutine.
 type MatrixEncoder struct {
 	ecd *Encoder
 	dcd *Decoder
+	zt  *ZT
 }
 
 // NewMatrixEncoder creates a new MatrixEncoder.
@@ -14,66 +18,218 @@ func NewMatrixEncoder(params Parameters) (ecd *MatrixEncoder) {
 	ecd = new(MatrixEncoder)
 	ecd.ecd = NewEncoder(params)
 	ecd.dcd = NewDecoder(params)
+	ecd.zt = NewZT(params)
 	return
 }
 
-// EncodeMatrixMessageNew encodes a Matrix into a MatrixMessage.
-func (ecd *MatrixEncoder) EncodeMatrixMessageNew(matrices [][][]*big.Int, isDiagonal bool) (em *MatrixMessage) {
-	em = NewMatrixMessage(ecd.ecd.params, len(matrices[0]), isDiagonal)
-	ecd.EncodeMatrixMessage(matrices, isDiagonal, em)
-	return em
+// ShallowCopy creates a shallow copy of MatrixEncoder in which all the read-only data-structures are
+// shared with the receiver and the temporary buffers are reallocated. The receiver and the returned
+// MatrixEncoder can be used concurrently.
+func (ecd *MatrixEncoder) ShallowCopy() *MatrixEncoder {
+	return &MatrixEncoder{ecd: ecd.ecd.ShallowCopy(), dcd: ecd.dcd.ShallowCopy(), zt: ecd.zt.ShallowCopy()}
 }
 
-// EncodeMatrixMessage encodes a Matrix into a MatrixMessage.
-func (ecd *MatrixEncoder) EncodeMatrixMessage(matrices [][][]*big.Int, isDiagonal bool, em *MatrixMessage) {
-	pack := len(matrices)
-	dim := len(matrices[0])
+// checkMatrices checks that matrices are Slots() / dim matrices of size dim x dim and returns dim.
+func (ecd *MatrixEncoder) checkMatrices(matrices [][][]*big.Int) (dim int, err error) {
+	shape := make([][]int, len(matrices))
+	for l := range matrices {
+		shape[l] = make([]int, len(matrices[l]))
+		for i := range matrices[l] {
+			shape[l][i] = len(matrices[l][i])
+		}
+	}
+	return ecd.checkShape(shape)
+}
+
+// checkElementMatrices is checkMatrices for matrices of Elements.
+func (ecd *MatrixEncoder) checkElementMatrices(matrices [][][]Element) (dim int, err error) {
+	shape := make([][]int, len(matrices))
+	for l := range matrices {
+		shape[l] = make([]int, len(matrices[l]))
+		for i := range matrices[l] {
+			shape[l][i] = len(matrices[l][i])
+		}
+	}
+	return ecd.checkShape(shape)
+}
+
+// checkShape checks that shape[l][i], the number of columns of the row i of the matrix l,
+// describes Slots() / dim matrices of size dim x dim and returns dim.
+func (ecd *MatrixEncoder) checkShape(shape [][]int) (dim int, err error) {
+	if len(shape) == 0 {
+		return 0, ErrDimNotDivisor
+	}
+
+	pack := len(shape)
+	dim = len(shape[0])
 	if pack*dim != ecd.ecd.params.Slots() {
-		panic("pack * dim must be equal to the number of slots")
+		return 0, fmt.Errorf("%w: pack * dim must be equal to the number of slots", ErrDimNotDivisor)
 	}
 
-	em.Pack = pack
-	em.IsDiagonal = isDiagonal
+	for l := range shape {
+		if len(shape[l]) != dim {
+			return 0, fmt.Errorf("%w: matrices must be square of the same dimension", ErrEncodingMismatch)
+		}
+		for i := range shape[l] {
+			if shape[l][i] != dim {
+				return 0, fmt.Errorf("%w: matrices must be square of the same dimension", ErrEncodingMismatch)
+			}
+		}
+	}
 
-	if isDiagonal {
-		for l := 0; l < pack; l++ {
-			for i := 0; i < dim; i++ {
-				for j := 0; j < dim; j++ {
-					em.Value[i].Value[j*pack+l] = matrices[l][j][(j+i)%dim]
+	return
+}
+
+// forEachSlot calls f(l, row, col, i, slot) for every entry (row, col) of the l-th of pack dim x dim matrices,
+// which the diagonal or shifted diagonal encoding stores in the slot of the i-th Message.
+func forEachSlot(pack, dim int, isDiagonal bool, f func(l, row, col, i, slot int)) {
+	for l := 0; l < pack; l++ {
+		for i := 0; i < dim; i++ {
+			for j := 0; j < dim; j++ {
+				if isDiagonal {
+					f(l, j, (j+i)%dim, i, j*pack+l)
+				} else {
+					f(l, (dim+j-i)%dim, j, i, j*pack+l)
 				}
 			}
 		}
-	} else {
-		for l := 0; l < pack; l++ {
-			for i := 0; i < dim; i++ {
-				for j := 0; j < dim; j++ {
-					em.Value[i].Value[j*pack+l] = matrices[l][(dim+j-i)%dim][j]
-				}
+	}
+}
+
+// checkMatrixMessage checks that em packs Pack matrices of size len(em.Value) in the slots.
+func (ecd *MatrixEncoder) checkMatrixMessage(em *MatrixMessage) (err error) {
+	if len(em.Value) == 0 || em.Pack*len(em.Value) != ecd.ecd.params.Slots() {
+		return ErrDimNotDivisor
+	}
+
+	if em.Layout != nil {
+		return fmt.Errorf("%w: the MatrixMessage has a layout, see DecodeLayoutMatrixMessageNew", ErrEncodingMismatch)
+	}
+
+	for i := range em.Value {
+		if len(em.Value[i].Value) != ecd.ecd.params.Slots() {
+			return fmt.Errorf("%w: messages must have Slots() values", ErrEncodingMismatch)
+		}
+		for _, el := range em.Value[i].Value {
+			if len(el) != ecd.zt.Words() {
+				return fmt.Errorf("%w: values must have ZT.Words() words", ErrEncodingMismatch)
 			}
 		}
 	}
+
+	return
+}
+
+// EncodeMatrixMessageNew encodes a Matrix into a MatrixMessage.
+func (ecd *MatrixEncoder) EncodeMatrixMessageNew(matrices [][][]*big.Int, isDiagonal bool) (em *MatrixMessage, err error) {
+	dim, err := ecd.checkMatrices(matrices)
+	if err != nil {
+		return nil, fmt.Errorf("cannot EncodeMatrixMessageNew: %w", err)
+	}
+
+	if em, err = NewMatrixMessage(ecd.ecd.params, dim, isDiagonal); err != nil {
+		return nil, err
+	}
+
+	return em, ecd.EncodeMatrixMessage(matrices, isDiagonal, em)
+}
+
+// EncodeMatrixMessage encodes a Matrix into a MatrixMessage.
+func (ecd *MatrixEncoder) EncodeMatrixMessage(matrices [][][]*big.Int, isDiagonal bool, em *MatrixMessage) (err error) {
+	dim, err := ecd.checkMatrices(matrices)
+	if err != nil {
+		return fmt.Errorf("cannot EncodeMatrixMessage: %w", err)
+	}
+
+	if len(em.Value) != dim {
+		return fmt.Errorf("cannot EncodeMatrixMessage: %w", ErrEncodingMismatch)
+	}
+
+	pack := len(matrices)
+	em.Pack = pack
+	em.IsDiagonal = isDiagonal
+
+	forEachSlot(pack, dim, isDiagonal, func(l, row, col, i, slot int) {
+		ecd.zt.SetBigInt(matrices[l][row][col], em.Value[i].Value[slot])
+	})
+
+	return
 }
 
 // EncodeMatrixNew encodes a Matrix into a Plaintext.
-func (ecd *MatrixEncoder) EncodeMatrixNew(matrices [][][]*big.Int, isDiagonal bool) (pt *MatrixPlaintext) {
-	pt = NewMatrixPlaintext(ecd.ecd.params, len(matrices[0]), isDiagonal)
-	ecd.EncodeMatrix(matrices, isDiagonal, pt)
-	return pt
+func (ecd *MatrixEncoder) EncodeMatrixNew(matrices [][][]*big.Int, isDiagonal bool) (pt *MatrixPlaintext, err error) {
+	dim, err := ecd.checkMatrices(matrices)
+	if err != nil {
+		return nil, fmt.Errorf("cannot EncodeMatrixNew: %w", err)
+	}
+
+	if pt, err = NewMatrixPlaintext(ecd.ecd.params, dim, isDiagonal); err != nil {
+		return nil, err
+	}
+
+	return pt, ecd.EncodeMatrix(matrices, isDiagonal, pt)
 }
 
 // EncodeMatrix encodes a Matrix into a Plaintext.
-func (ecd *MatrixEncoder) EncodeMatrix(matrices [][][]*big.Int, isDiagonal bool, pt *MatrixPlaintext) {
-	em := ecd.EncodeMatrixMessageNew(matrices, isDiagonal)
+func (ecd *MatrixEncoder) EncodeMatrix(matrices [][][]*big.Int, isDiagonal bool, pt *MatrixPlaintext) (err error) {
+	em, err := ecd.EncodeMatrixMessageNew(matrices, isDiagonal)
+	if err != nil {
+		return
+	}
+
+	if len(pt.Value) != len(em.Value) {
+		return fmt.Errorf("cannot EncodeMatrix: %w", ErrEncodingMismatch)
+	}
 
 	pt.Pack = em.Pack
 	pt.IsDiagonal = em.IsDiagonal
 	for i := range em.Value {
 		ecd.ecd.Encode(em.Value[i], pt.Value[i])
 	}
+
+	return
+}
+
+// EncodeMatrixMulNew encodes a Matrix into a MatrixPlaintextMul.
+func (ecd *MatrixEncoder) EncodeMatrixMulNew(matrices [][][]*big.Int, isDiagonal bool) (pt *MatrixPlaintextMul, err error) {
+	dim, err := ecd.checkMatrices(matrices)
+	if err != nil {
+		return nil, fmt.Errorf("cannot EncodeMatrixMulNew: %w", err)
+	}
+
+	if pt, err = NewMatrixPlaintextMul(ecd.ecd.params, dim, isDiagonal); err != nil {
+		return nil, err
+	}
+
+	return pt, ecd.EncodeMatrixMul(matrices, isDiagonal, pt)
+}
+
+// EncodeMatrixMul encodes a Matrix into a MatrixPlaintextMul.
+func (ecd *MatrixEncoder) EncodeMatrixMul(matrices [][][]*big.Int, isDiagonal bool, pt *MatrixPlaintextMul) (err error) {
+	em, err := ecd.EncodeMatrixMessageNew(matrices, isDiagonal)
+	if err != nil {
+		return
+	}
+
+	if len(pt.Value) != len(em.Value) {
+		return fmt.Errorf("cannot EncodeMatrixMul: %w", ErrEncodingMismatch)
+	}
+
+	pt.Pack = em.Pack
+	pt.IsDiagonal = em.IsDiagonal
+	for i := range em.Value {
+		ecd.ecd.EncodeMul(em.Value[i], pt.Value[i])
+	}
+
+	return
 }
 
 // DecodeMatrixMessageNew decodes a MatrixMessage into a Matrix.
-func (ecd *MatrixEncoder) DecodeMatrixMessageNew(em *MatrixMessage) (matrices [][][]*big.Int) {
+func (ecd *MatrixEncoder) DecodeMatrixMessageNew(em *MatrixMessage) (matrices [][][]*big.Int, err error) {
+	if err = ecd.checkMatrixMessage(em); err != nil {
+		return nil, fmt.Errorf("cannot DecodeMatrixMessageNew: %w", err)
+	}
+
 	pack := em.Pack
 	dim := len(em.Value)
 
@@ -88,52 +244,244 @@ func (ecd *MatrixEncoder) DecodeMatrixMessageNew(em *MatrixMessage) (matrices []
 		}
 	}
 
-	ecd.DecodeMatrixMessage(em, matrices)
+	return matrices, ecd.DecodeMatrixMessage(em, matrices)
+}
+
+// DecodeMatrixMessage decodes a MatrixMessage into a Matrix.
+func (ecd *MatrixEncoder) DecodeMatrixMessage(em *MatrixMessage, matrices [][][]*big.Int) (err error) {
+	if err = ecd.checkMatrixMessage(em); err != nil {
+		return fmt.Errorf("cannot DecodeMatrixMessage: %w", err)
+	}
+
+	if dim, err := ecd.checkMatrices(matrices); err != nil || dim != len(em.Value) {
+		return fmt.Errorf("cannot DecodeMatrixMessage: %w", ErrEncodingMismatch)
+	}
+
+	forEachSlot(em.Pack, len(em.Value), em.IsDiagonal, func(l, row, col, i, slot int) {
+		matrices[l][row][col] = em.Value[i].Value[slot].BigInt()
+	})
 
 	return
 }
 
-// DecodeMatrixMessage decodes a MatrixMessage into a Matrix.
-func (ecd *MatrixEncoder) DecodeMatrixMessage(em *MatrixMessage, matrices [][][]*big.Int) {
-	pack := em.Pack
+// EncodeMatrixElementsNew encodes Pack matrices of Elements of Z_T into a MatrixPlaintext.
+func (ecd *MatrixEncoder) EncodeMatrixElementsNew(matrices [][][]Element, isDiagonal bool) (pt *MatrixPlaintext, err error) {
+	if pt, err = ecd.encodeElements(matrices, isDiagonal); err != nil {
+		return nil, fmt.Errorf("cannot EncodeMatrixElementsNew: %w", err)
+	}
+	return
+}
+
+// DecodeMatrixMessageElementsNew decodes a MatrixMessage into Pack matrices of Elements of Z_T.
+func (ecd *MatrixEncoder) DecodeMatrixMessageElementsNew(em *MatrixMessage) (matrices [][][]Element, err error) {
+	if matrices, err = ecd.decodeElements(em); err != nil {
+		return nil, fmt.Errorf("cannot DecodeMatrixMessageElementsNew: %w", err)
+	}
+	return
+}
+
+// encodeElements encodes matrices of Elements into a new MatrixPlaintext.
+func (ecd *MatrixEncoder) encodeElements(matrices [][][]Element, isDiagonal bool) (pt *MatrixPlaintext, err error) {
+	dim, err := ecd.checkElementMatrices(matrices)
+	if err != nil {
+		return nil, err
+	}
+
+	em, err := NewMatrixMessage(ecd.ecd.params, dim, isDiagonal)
+	if err != nil {
+		return nil, err
+	}
+
+	forEachSlot(em.Pack, dim, isDiagonal, func(l, row, col, i, slot int) {
+		em.Value[i].Value[slot].Set(matrices[l][row][col])
+	})
+
+	if pt, err = NewMatrixPlaintext(ecd.ecd.params, dim, isDiagonal); err != nil {
+		return nil, err
+	}
+
+	for i := range em.Value {
+		ecd.ecd.Encode(em.Value[i], pt.Value[i])
+	}
+
+	return
+}
+
+// decodeElements decodes em into new matrices of Elements.
+func (ecd *MatrixEncoder) decodeElements(em *MatrixMessage) (matrices [][][]Element, err error) {
+	if err = ecd.checkMatrixMessage(em); err != nil {
+		return nil, err
+	}
+
 	dim := len(em.Value)
 
-	if em.IsDiagonal {
-		for l := 0; l < pack; l++ {
-			for i := 0; i < dim; i++ {
-				for j := 0; j < dim; j++ {
-					matrices[l][j][(j+i)%dim] = em.Value[i].Value[j*pack+l]
-				}
-			}
-		}
-	} else {
-		for l := 0; l < pack; l++ {
-			for i := 0; i < dim; i++ {
-				for j := 0; j < dim; j++ {
-					matrices[l][(dim+j-i)%dim][j] = em.Value[i].Value[j*pack+l]
-				}
-			}
+	matrices = make([][][]Element, em.Pack)
+	for l := range matrices {
+		matrices[l] = make([][]Element, dim)
+		for i := range matrices[l] {
+			matrices[l][i] = ecd.zt.NewVector(dim)
 		}
 	}
+
+	forEachSlot(em.Pack, dim, em.IsDiagonal, func(l, row, col, i, slot int) {
+		matrices[l][row][col].Set(em.Value[i].Value[slot])
+	})
+
+	return
 }
 
 // DecodeMatrixNew decodes a Plaintext into a Matrix.
-func (ecd *MatrixEncoder) DecodeMatrixNew(pt *MatrixPlaintext) (matrices [][][]*big.Int) {
-	em := NewMatrixMessage(ecd.ecd.params, len(pt.Value), pt.IsDiagonal)
-	for i := range pt.Value {
-		ecd.dcd.Decode(pt.Value[i], em.Value[i])
+func (ecd *MatrixEncoder) DecodeMatrixNew(pt *MatrixPlaintext) (matrices [][][]*big.Int, err error) {
+	em, err := ecd.decodeMessages(pt)
+	if err != nil {
+		return nil, fmt.Errorf("cannot DecodeMatrixNew: %w", err)
 	}
 
-	matrices = ecd.DecodeMatrixMessageNew(em)
-	return
+	return ecd.DecodeMatrixMessageNew(em)
 }
 
 // DecodeMatrix decodes a Plaintext into a Matrix.
-func (ecd *MatrixEncoder) DecodeMatrix(pt *MatrixPlaintext, matrices [][][]*big.Int) {
-	em := NewMatrixMessage(ecd.ecd.params, len(pt.Value), pt.IsDiagonal)
+func (ecd *MatrixEncoder) DecodeMatrix(pt *MatrixPlaintext, matrices [][][]*big.Int) (err error) {
+	em, err := ecd.decodeMessages(pt)
+	if err != nil {
+		return fmt.Errorf("cannot DecodeMatrix: %w", err)
+	}
+
+	return ecd.DecodeMatrixMessage(em, matrices)
+}
+
+// decodeMessages decodes every diagonal of pt into a new MatrixMessage.
+func (ecd *MatrixEncoder) decodeMessages(pt *MatrixPlaintext) (em *MatrixMessage, err error) {
+	if em, err = NewMatrixMessage(ecd.ecd.params, len(pt.Value), pt.IsDiagonal); err != nil {
+		return
+	}
+
+	if em.Pack != pt.Pack {
+		return nil, ErrEncodingMismatch
+	}
+	em.Layout = pt.Layout
+
 	for i := range pt.Value {
 		ecd.dcd.Decode(pt.Value[i], em.Value[i])
 	}
 
-	ecd.DecodeMatrixMessage(em, matrices)
+	return
+}
+
+// MatMulDim returns the smallest dim dividing the number of slots such that the product of
+// rows x inner matrices by inner x cols matrices can be computed on dim x dim matrices padded
+// with zeros. The number of products computed at once is then Slots() / dim.
+func (ecd *MatrixEncoder) MatMulDim(rows, inner, cols int) (dim int, err error) {
+	if rows < 1 || inner < 1 || cols < 1 {
+		return 0, fmt.Errorf("cannot MatMulDim: %w: dimensions must be positive", ErrEncodingMismatch)
+	}
+
+	minDim := rows
+	if inner > minDim {
+		minDim = inner
+	}
+	if cols > minDim {
+		minDim = cols
+	}
+
+	slots := ecd.ecd.params.Slots()
+	for dim = minDim; dim <= slots; dim++ {
+		if slots%dim == 0 {
+			return dim, nil
+		}
+	}
+
+	return 0, fmt.Errorf("cannot MatMulDim: %w: %d x %d x %d product does not fit in the slots", ErrDimNotDivisor, rows, inner, cols)
+}
+
+// EncodeRectMatrixNew encodes rows x cols matrices into a MatrixPlaintext of dim x dim matrices.
+// Each matrix is padded with zeros on the right and at the bottom, and up to Slots() / dim
+// matrices can be given, the missing ones being set to zero.
+// dim should be obtained with MatMulDim so that the padded matrices can be multiplied.
+func (ecd *MatrixEncoder) EncodeRectMatrixNew(matrices [][][]*big.Int, dim int, isDiagonal bool) (pt *MatrixPlaintext, err error) {
+	padded, err := PadMatrices(ecd.ecd.params, matrices, dim)
+	if err != nil {
+		return nil, fmt.Errorf("cannot EncodeRectMatrixNew: %w", err)
+	}
+
+	return ecd.EncodeMatrixNew(padded, isDiagonal)
+}
+
+// DecodeRectMatrixNew decodes a MatrixPlaintext and returns the top-left rows x cols block
+// of each of its Pack matrices.
+func (ecd *MatrixEncoder) DecodeRectMatrixNew(pt *MatrixPlaintext, rows, cols int) (matrices [][][]*big.Int, err error) {
+	if matrices, err = ecd.DecodeMatrixNew(pt); err != nil {
+		return
+	}
+
+	return CropMatrices(matrices, rows, cols)
+}
+
+// PadMatrices pads rows x cols matrices with zeros into Slots() / dim matrices of size dim x dim.
+// All the matrices must have the same size, which must not exceed dim.
+func PadMatrices(params Parameters, matrices [][][]*big.Int, dim int) (padded [][][]*big.Int, err error) {
+	pack, err := packFor(params, dim)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matrices) == 0 || len(matrices) > pack {
+		return nil, fmt.Errorf("%w: between 1 and %d matrices can be padded to dim %d", ErrEncodingMismatch, pack, dim)
+	}
+
+	rows := len(matrices[0])
+	if rows == 0 || rows > dim {
+		return nil, fmt.Errorf("%w: matrices must have between 1 and %d rows", ErrEncodingMismatch, dim)
+	}
+
+	cols := len(matrices[0][0])
+	if cols == 0 || cols > dim {
+		return nil, fmt.Errorf("%w: matrices must have between 1 and %d columns", ErrEncodingMismatch, dim)
+	}
+
+	padded = make([][][]*big.Int, pack)
+	for l := range padded {
+		if l < len(matrices) && len(matrices[l]) != rows {
+			return nil, fmt.Errorf("%w: matrices must have the same size", ErrEncodingMismatch)
+		}
+
+		padded[l] = make([][]*big.Int, dim)
+		for i := range padded[l] {
+			if l < len(matrices) && i < rows && len(matrices[l][i]) != cols {
+				return nil, fmt.Errorf("%w: matrices must have the same size", ErrEncodingMismatch)
+			}
+
+			padded[l][i] = make([]*big.Int, dim)
+			for j := range padded[l][i] {
+				if l < len(matrices) && i < rows && j < cols {
+					padded[l][i][j] = matrices[l][i][j]
+				} else {
+					padded[l][i][j] = big.NewInt(0)
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// CropMatrices returns the top-left rows x cols block of each matrix.
+func CropMatrices(matrices [][][]*big.Int, rows, cols int) (cropped [][][]*big.Int, err error) {
+	cropped = make([][][]*big.Int, len(matrices))
+	for l := range matrices {
+		if rows < 1 || rows > len(matrices[l]) {
+			return nil, fmt.Errorf("%w: cannot crop %d rows out of %d", ErrEncodingMismatch, rows, len(matrices[l]))
+		}
+
+		cropped[l] = make([][]*big.Int, rows)
+		for i := range cropped[l] {
+			if cols < 1 || cols > len(matrices[l][i]) {
+				return nil, fmt.Errorf("%w: cannot crop %d columns out of %d", ErrEncodingMismatch, cols, len(matrices[l][i]))
+			}
+
+			cropped[l][i] = matrices[l][i][:cols]
+		}
+	}
+
+	return
 }
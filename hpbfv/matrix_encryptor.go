@@ -1,9 +1,13 @@
 package hpbfv
 
 import (
+	"fmt"
+
 	"hp-bfv/rlwe"
 )
 
+// MatrixEncryptor encrypts MatrixPlaintexts and, given the secret key, decrypts MatrixCiphertexts.
+// A MatrixEncryptor is not safe for concurrent use: use ShallowCopy to obtain one MatrixEncryptor per goroutine.
 type MatrixEncryptor struct {
 	ecd *MatrixEncoder
 	enc *Encryptor
@@ -11,44 +15,84 @@ type MatrixEncryptor struct {
 }
 
 // NewMatrixEncryptor creates a new MatrixEncryptor.
+// sk can be nil, in which case the MatrixEncryptor can only encrypt.
 func NewMatrixEncryptor(params Parameters, pk *rlwe.PublicKey, sk *rlwe.SecretKey) (enc *MatrixEncryptor) {
 	enc = new(MatrixEncryptor)
 	enc.ecd = NewMatrixEncoder(params)
 	enc.enc = NewEncryptor(params, pk)
-	enc.dec = NewDecryptor(params, sk)
+	if sk != nil {
+		enc.dec = NewDecryptor(params, sk)
+	}
 	return
 }
 
+// ShallowCopy creates a shallow copy of MatrixEncryptor in which all the read-only data-structures are
+// shared with the receiver and the temporary buffers and samplers are reallocated. The receiver and
+// the returned MatrixEncryptor can be used concurrently.
+func (enc *MatrixEncryptor) ShallowCopy() *MatrixEncryptor {
+	encCopy := &MatrixEncryptor{ecd: enc.ecd.ShallowCopy(), enc: enc.enc.ShallowCopy()}
+	if enc.dec != nil {
+		encCopy.dec = enc.dec.ShallowCopy()
+	}
+	return encCopy
+}
+
 // EncryptNew encrypts the input matrix and returns the ciphertext.
-func (enc *MatrixEncryptor) EncryptNew(pm *MatrixPlaintext) (cm *MatrixCiphertext) {
-	cm = NewMatrixCiphertext(enc.enc.params, len(pm.Value), pm.IsDiagonal)
-	enc.Encrypt(pm, cm)
-	return
+func (enc *MatrixEncryptor) EncryptNew(pm *MatrixPlaintext) (cm *MatrixCiphertext, err error) {
+	if cm, err = NewMatrixCiphertext(enc.enc.params, len(pm.Value), pm.IsDiagonal); err != nil {
+		return nil, err
+	}
+	return cm, enc.Encrypt(pm, cm)
 }
 
 // Encrypt encrypts the input matrix and returns the ciphertext.
-func (enc *MatrixEncryptor) Encrypt(pm *MatrixPlaintext, cm *MatrixCiphertext) {
+func (enc *MatrixEncryptor) Encrypt(pm *MatrixPlaintext, cm *MatrixCiphertext) (err error) {
+	if len(pm.Value) != len(cm.Value) {
+		return fmt.Errorf("cannot Encrypt: %w", ErrEncodingMismatch)
+	}
+
 	cm.Pack = pm.Pack
 	cm.IsDiagonal = pm.IsDiagonal
+	cm.Layout = pm.Layout
 
 	for i := range pm.Value {
 		enc.enc.Encrypt(pm.Value[i], cm.Value[i])
 	}
+
+	return
 }
 
 // DecryptNew decrypts the input ciphertext and returns the plaintext.
-func (enc *MatrixEncryptor) DecryptNew(cm *MatrixCiphertext) (pm *MatrixPlaintext) {
-	pm = NewMatrixPlaintext(enc.dec.params, len(cm.Value), cm.IsDiagonal)
-	enc.Decrypt(cm, pm)
-	return
+func (enc *MatrixEncryptor) DecryptNew(cm *MatrixCiphertext) (pm *MatrixPlaintext, err error) {
+	if pm, err = NewMatrixPlaintext(enc.enc.params, len(cm.Value), cm.IsDiagonal); err != nil {
+		return nil, err
+	}
+	return pm, enc.Decrypt(cm, pm)
 }
 
 // Decrypt decrypts the input ciphertext and returns the plaintext.
-func (enc *MatrixEncryptor) Decrypt(cm *MatrixCiphertext, pm *MatrixPlaintext) {
+func (enc *MatrixEncryptor) Decrypt(cm *MatrixCiphertext, pm *MatrixPlaintext) (err error) {
+	if enc.dec == nil {
+		return fmt.Errorf("cannot Decrypt: %w", ErrMissingSecretKey)
+	}
+
+	if len(pm.Value) != len(cm.Value) {
+		return fmt.Errorf("cannot Decrypt: %w", ErrEncodingMismatch)
+	}
+
+	for i := range cm.Value {
+		if cm.Value[i].Degree() != 1 {
+			return fmt.Errorf("cannot Decrypt: %w", ErrInvalidDegree)
+		}
+	}
+
 	pm.Pack = cm.Pack
 	pm.IsDiagonal = cm.IsDiagonal
+	pm.Layout = cm.Layout
 
 	for i := range cm.Value {
 		enc.dec.Decrypt(cm.Value[i], pm.Value[i])
 	}
+
+	return
 }
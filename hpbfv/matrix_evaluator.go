@@ -1,6 +1,7 @@
 package hpbfv
 
 import (
+	"fmt"
 	"math"
 
 	"hp-bfv/ring"
@@ -9,9 +10,16 @@ import (
 	"hp-bfv/utils"
 )
 
+// MatrixEvaluator evaluates homomorphic operations on MatrixCiphertexts.
+// A MatrixEvaluator is not safe for concurrent use: use ShallowCopy to obtain one MatrixEvaluator per goroutine,
+// or SetWorkers to parallelize its multiplications.
 type MatrixEvaluator struct {
 	eval *Evaluator
 
+	// dim is the dimension of the matrices the evaluator is restricted to, or 0 if it supports every dimension.
+	dim int
+
+	// poolAMul and poolBMul hold the lifted operands of Mul and are grown to the dimension of the operands on demand.
 	poolAMul [][2]*ringqp.Poly
 	poolBMul [][2]*ringqp.Poly
 
@@ -20,31 +28,123 @@ type MatrixEvaluator struct {
 	poolC    [4]*ring.Poly
 
 	poolKeySwitch [3]*rlwe.Ciphertext
+	poolCt        *Ciphertext
+	poolTensor    [2]*Ciphertext
+
+	poolAlpha []ringqp.Poly
 
 	permuteQIdx    map[uint64][]uint64
 	permuteQMulIdx map[uint64][]uint64
 
 	rlk *rlwe.RelinearizationKey
 	rks *rlwe.RotationKeySet
+
+	// ecd encodes the masks of the products of matrices of mixed dimensions, see MatrixLayout,
+	// which are cached in masks by layout and dimension. Both are allocated on first use.
+	ecd   *Encoder
+	masks map[string]*PlaintextMul
+
+	// poolLayout holds the accumulated and the per-dimension products of mulLayout and is grown on demand.
+	poolLayout [2][]*Ciphertext
+
+	// workers compute the output diagonals of Mul in parallel with eval, see SetWorkers.
+	workers []*MatrixEvaluator
 }
 
 func NewQQMulPoly(params Parameters) *ringqp.Poly {
 	return &ringqp.Poly{Q: params.RingQ().NewPoly(), P: params.RingQMul().NewPoly()}
 }
 
-// NewMatrixEvaluator creates a new MatrixEvaluator.
+// NewMatrixEvaluator creates a new MatrixEvaluator supporting matrices of every dimension.
+// The buffers for the operands of the multiplications are allocated on demand, for the dimension of the operands.
 func NewMatrixEvaluator(params Parameters, rlk *rlwe.RelinearizationKey, matRks *rlwe.RotationKeySet) *MatrixEvaluator {
+	return newMatrixEvaluator(params, 0, 1, rlk, matRks)
+}
+
+// NewMatrixEvaluatorForDim creates a new MatrixEvaluator restricted to matrices of dimension dim, whose
+// buffers and permutation indexes are sized to dim instead of to the number of slots.
+// It returns an error if dim does not divide the number of slots.
+func NewMatrixEvaluatorForDim(params Parameters, dim int, rlk *rlwe.RelinearizationKey, matRks *rlwe.RotationKeySet) (eval *MatrixEvaluator, err error) {
+	pack, err := packFor(params, dim)
+	if err != nil {
+		return nil, fmt.Errorf("cannot NewMatrixEvaluatorForDim: %w", err)
+	}
+
+	return newMatrixEvaluator(params, dim, pack, rlk, matRks), nil
+}
+
+// newMatrixEvaluator creates a new MatrixEvaluator for matrices of dimension dim, or of every dimension
+// if dim is 0, with the permutation indexes of the rotations by the multiples of pack.
+func newMatrixEvaluator(params Parameters, dim, pack int, rlk *rlwe.RelinearizationKey, matRks *rlwe.RotationKeySet) *MatrixEvaluator {
 	eval := new(MatrixEvaluator)
 
 	eval.eval = NewEvaluator(params)
+	eval.dim = dim
 
-	dim := params.Slots()
-	eval.poolAMul = make([][2]*ringqp.Poly, dim)
-	eval.poolBMul = make([][2]*ringqp.Poly, dim)
-	for i := 0; i < dim; i++ {
-		eval.poolAMul[i] = [2]*ringqp.Poly{NewQQMulPoly(params), NewQQMulPoly(params)}
-		eval.poolBMul[i] = [2]*ringqp.Poly{NewQQMulPoly(params), NewQQMulPoly(params)}
+	eval.allocatePools()
+
+	rotations := params.Slots() / pack
+	eval.permuteQIdx = make(map[uint64][]uint64, rotations)
+	eval.permuteQMulIdx = make(map[uint64][]uint64, rotations)
+	for i := 0; i < rotations; i++ {
+		galEl := params.GaloisElementForColumnRotationBy(uint64(pack * i))
+		eval.permuteQIdx[galEl] = params.RingQ().PermuteNTTIndex(galEl)
+		eval.permuteQMulIdx[galEl] = params.RingQMul().PermuteNTTIndex(galEl)
+	}
+
+	eval.rlk = rlk
+	eval.rks = matRks
+
+	return eval
+}
+
+// ShallowCopy creates a shallow copy of MatrixEvaluator in which the read-only data-structures, i.e. the
+// permutation indexes and the keys, are shared with the receiver and the temporary buffers, including those
+// of its workers, are reallocated. The receiver and the returned MatrixEvaluator can be used concurrently.
+func (eval *MatrixEvaluator) ShallowCopy() *MatrixEvaluator {
+	evalCopy := &MatrixEvaluator{
+		eval:           eval.eval.ShallowCopy(),
+		dim:            eval.dim,
+		permuteQIdx:    eval.permuteQIdx,
+		permuteQMulIdx: eval.permuteQMulIdx,
+		rlk:            eval.rlk,
+		rks:            eval.rks,
+	}
+
+	evalCopy.allocatePools()
+
+	for len(evalCopy.workers) < len(eval.workers) {
+		evalCopy.workers = append(evalCopy.workers, evalCopy.newWorker())
+	}
+
+	return evalCopy
+}
+
+// allocatePools allocates the temporary buffers of eval, except the ones of mulPools.
+func (eval *MatrixEvaluator) allocatePools() {
+	params := eval.eval.params
+
+	eval.allocateDiagonalPools()
+
+	eval.poolCt = NewCiphertext(params, 1)
+	eval.poolTensor = [2]*Ciphertext{NewCiphertext(params, 2), NewCiphertext(params, 2)}
+
+	eval.poolAlpha = []ringqp.Poly{*NewQQMulPoly(params), *NewQQMulPoly(params)}
+}
+
+// mulPools returns the buffers for the lifted operands of a product of matrices of dimension dim,
+// growing them if they are too small.
+func (eval *MatrixEvaluator) mulPools(dim int) (aMul, bMul [][2]*ringqp.Poly) {
+	if n := dim - len(eval.poolAMul); n > 0 {
+		eval.poolAMul = append(eval.poolAMul, newPreparedValue(eval.eval.params, n)...)
+		eval.poolBMul = append(eval.poolBMul, newPreparedValue(eval.eval.params, n)...)
 	}
+	return eval.poolAMul[:dim], eval.poolBMul[:dim]
+}
+
+// allocateDiagonalPools allocates the temporary buffers of mulDiagonal.
+func (eval *MatrixEvaluator) allocateDiagonalPools() {
+	params := eval.eval.params
 
 	eval.poolRot = [2]*ringqp.Poly{NewQQMulPoly(params), NewQQMulPoly(params)}
 
@@ -62,172 +162,545 @@ func NewMatrixEvaluator(params Parameters, rlk *rlwe.RelinearizationKey, matRks
 		rlwe.NewCiphertext(params.Parameters, 1, params.MaxLevel()),
 		rlwe.NewCiphertext(params.Parameters, 1, params.MaxLevel()),
 	}
+}
 
-	eval.permuteQIdx = make(map[uint64][]uint64, dim)
-	eval.permuteQMulIdx = make(map[uint64][]uint64, dim)
-	for i := 0; i < dim; i++ {
-		galEl := params.GaloisElementForColumnRotationBy(uint64(i))
-		eval.permuteQIdx[galEl] = params.RingQ().PermuteNTTIndex(galEl)
-		eval.permuteQMulIdx[galEl] = params.RingQMul().PermuteNTTIndex(galEl)
+// AuthenticateNew multiplies the matrices ctIn by the encrypted MAC key ctAlpha and returns the results.
+// See Authenticate.
+func (eval *MatrixEvaluator) AuthenticateNew(ctAlpha *Ciphertext, ctIn ...*MatrixCiphertext) (ctOut []*MatrixCiphertext, err error) {
+	ctOut = make([]*MatrixCiphertext, len(ctIn))
+	for i := range ctIn {
+		if ctOut[i], err = NewMatrixCiphertext(eval.eval.params, len(ctIn[i].Value), ctIn[i].IsDiagonal); err != nil {
+			return nil, fmt.Errorf("cannot AuthenticateNew: %w", err)
+		}
 	}
 
-	eval.rlk = rlk
-	eval.rks = matRks
+	if err = eval.Authenticate(ctAlpha, ctIn, ctOut); err != nil {
+		return nil, err
+	}
 
-	return eval
+	return
+}
+
+// Authenticate multiplies every diagonal of the matrices ctIn by the encrypted MAC key ctAlpha
+// and writes the results on ctOut. ctAlpha is expected to encrypt the MAC key in every slot,
+// so that the result encrypts the MACs of the matrices in the same encoding as ctIn.
+// ctAlpha is extended to (Q, QMul) once and reused for all the diagonals of all the matrices.
+func (eval *MatrixEvaluator) Authenticate(ctAlpha *Ciphertext, ctIn, ctOut []*MatrixCiphertext) (err error) {
+	if len(ctIn) != len(ctOut) {
+		return fmt.Errorf("cannot Authenticate: %w: ctIn and ctOut must have the same length", ErrEncodingMismatch)
+	}
+
+	if eval.rlk == nil {
+		return fmt.Errorf("cannot Authenticate: %w", ErrMissingRelinearizationKey)
+	}
+
+	if ctAlpha.Degree() != 1 {
+		return fmt.Errorf("cannot Authenticate: %w", ErrInvalidDegree)
+	}
+
+	for i := range ctIn {
+		if err = eval.checkMatrixCiphertexts(ctIn[i], ctOut[i]); err != nil {
+			return fmt.Errorf("cannot Authenticate: %w", err)
+		}
+
+		if len(ctIn[i].Value) != len(ctOut[i].Value) {
+			return fmt.Errorf("cannot Authenticate: %w", ErrEncodingMismatch)
+		}
+	}
+
+	eval.eval.RescaleQMul(ctAlpha, eval.poolAlpha)
+
+	for i := range ctIn {
+		ctOut[i].Pack = ctIn[i].Pack
+		ctOut[i].IsDiagonal = ctIn[i].IsDiagonal
+		ctOut[i].Layout = ctIn[i].Layout
+
+		for j := range ctIn[i].Value {
+			eval.eval.MulAndRelinHoisted(eval.poolAlpha, ctIn[i].Value[j], eval.rlk, ctOut[i].Value[j])
+		}
+	}
+
+	return
 }
 
 // MulNew multiplies two matrices.
-func (eval *MatrixEvaluator) MulNew(ctA, ctB *MatrixCiphertext) *MatrixCiphertext {
-	ctC := NewMatrixCiphertext(eval.eval.params, len(ctA.Value), true)
-	eval.Mul(ctA, ctB, ctC)
-	return ctC
+func (eval *MatrixEvaluator) MulNew(ctA, ctB *MatrixCiphertext) (ctC *MatrixCiphertext, err error) {
+	if ctC, err = NewMatrixCiphertext(eval.eval.params, len(ctA.Value), true); err != nil {
+		return nil, fmt.Errorf("cannot MulNew: %w", err)
+	}
+
+	if err = eval.Mul(ctA, ctB, ctC); err != nil {
+		return nil, err
+	}
+
+	return
 }
 
 // Mul multiplies two matrices.
-func (eval *MatrixEvaluator) Mul(ctA, ctB, ctC *MatrixCiphertext) {
+// ctA must be packed diagonally, ctB shifted diagonally, and ctC is packed diagonally.
+// Rectangular matrices are multiplied by padding them with zeros to a common dim,
+// see MatrixEncoder.MatMulDim and MatrixEncoder.EncodeRectMatrixNew.
+// The diagonals of ctC are computed by Workers() goroutines, see SetWorkers.
+// If ctA and ctB have a MatrixLayout, which must be the same, each of their matrices is multiplied
+// by the matrix at the same place and ctC has their layout. The rotation keys generated by
+// GenRotationKeysForMatMul for the largest dimension of the layout cover all its matrices.
+func (eval *MatrixEvaluator) Mul(ctA, ctB, ctC *MatrixCiphertext) (err error) {
+	if err = eval.checkMatrixCiphertexts(ctA, ctB, ctC); err != nil {
+		return fmt.Errorf("cannot Mul: %w", err)
+	}
+
 	if !(ctA.IsDiagonal && !ctB.IsDiagonal && ctC.IsDiagonal) {
-		panic("wrong encoding")
+		return fmt.Errorf("cannot Mul: %w: ctA and ctC must be diagonal and ctB shifted diagonal", ErrEncodingMismatch)
+	}
+
+	if ctA.Layout != nil || ctB.Layout != nil {
+		if ctA.Layout == nil {
+			return fmt.Errorf("cannot Mul: %w: ctA and ctB must have the same layout", ErrEncodingMismatch)
+		}
+		if err = eval.mulLayout(ctA, ctB, ctC); err != nil {
+			return fmt.Errorf("cannot Mul: %w", err)
+		}
+		return
 	}
 
 	pack := ctA.Pack
 	dim := len(ctA.Value)
 	if len(ctB.Value) != dim || len(ctC.Value) != dim {
-		panic("wrong encoding")
+		return fmt.Errorf("cannot Mul: %w: dimensions do not match", ErrEncodingMismatch)
 	}
 	if ctB.Pack != pack || ctC.Pack != pack {
-		panic("wrong encoding")
+		return fmt.Errorf("cannot Mul: %w: packs do not match", ErrEncodingMismatch)
 	}
-	if pack*dim != eval.eval.params.Slots() {
-		panic("wrong encoding")
+
+	if err = eval.checkMulKeys(pack, dim); err != nil {
+		return fmt.Errorf("cannot Mul: %w", err)
 	}
 
-	params := eval.eval.params
-	ringQ := params.RingQ()
-	ringQMul := params.RingQMul()
+	aMul, bMul := eval.mulPools(dim)
+	for i := 0; i < dim; i++ {
+		eval.fillAMul(ctA.Value[i], aMul[i])
+		eval.fillBMul(ctB.Value[i], bMul[i])
+	}
+	eval.mulDiagonals(pack, dim, aMul, bMul, ctC)
+
+	return
+}
+
+// MulPlainNew multiplies the plaintext matrix ptA by the encrypted matrix ctB and returns the result.
+// See MulPlain.
+func (eval *MatrixEvaluator) MulPlainNew(ptA *MatrixPlaintextMul, ctB *MatrixCiphertext) (ctC *MatrixCiphertext, err error) {
+	if ctC, err = NewMatrixCiphertext(eval.eval.params, len(ctB.Value), true); err != nil {
+		return nil, fmt.Errorf("cannot MulPlainNew: %w", err)
+	}
+
+	if err = eval.MulPlain(ptA, ctB, ctC); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// MulPlain multiplies the plaintext matrix ptA by the encrypted matrix ctB and writes the result on ctC.
+// ptA must be packed diagonally, ctB shifted diagonally, and ctC is packed diagonally.
+// The rotations of ctB are accumulated under the rotated secret key, so that only one key switch
+// is performed per diagonal of ctC, and no relinearization key is needed.
+func (eval *MatrixEvaluator) MulPlain(ptA *MatrixPlaintextMul, ctB, ctC *MatrixCiphertext) (err error) {
+	if err = eval.checkMatrixPlaintextMul(ptA); err != nil {
+		return fmt.Errorf("cannot MulPlain: %w", err)
+	}
+
+	if err = eval.checkMatrixCiphertexts(ctB, ctC); err != nil {
+		return fmt.Errorf("cannot MulPlain: %w", err)
+	}
+
+	if err = checkUniform(ctB); err != nil {
+		return fmt.Errorf("cannot MulPlain: %w", err)
+	}
+
+	if !(ptA.IsDiagonal && !ctB.IsDiagonal && ctC.IsDiagonal) {
+		return fmt.Errorf("cannot MulPlain: %w: ptA and ctC must be diagonal and ctB shifted diagonal", ErrEncodingMismatch)
+	}
+
+	pack := ptA.Pack
+	dim := len(ptA.Value)
+	if len(ctB.Value) != dim || len(ctC.Value) != dim {
+		return fmt.Errorf("cannot MulPlain: %w: dimensions do not match", ErrEncodingMismatch)
+	}
+	if ctB.Pack != pack || ctC.Pack != pack {
+		return fmt.Errorf("cannot MulPlain: %w: packs do not match", ErrEncodingMismatch)
+	}
+
+	if err = eval.checkMatMulRotationKeys(pack, dim); err != nil {
+		return fmt.Errorf("cannot MulPlain: %w", err)
+	}
+
+	ringQ := eval.eval.params.RingQ()
 	levelQ := len(ringQ.Modulus) - 1
-	levelQMul := len(ringQMul.Modulus) - 1
 
-	// Fill poolAMul
+	// Fill bMul
+	_, bMul := eval.mulPools(dim)
 	for i := 0; i < dim; i++ {
 		for j := 0; j < 2; j++ {
-			ringQ.MulScalarBigint(ctA.Value[i].Value[j], ringQMul.ModulusAtLevel[len(ringQMul.Modulus)-1], eval.poolAMul[i][j].Q)
-			eval.poolAMul[i][j].P.Zero()
+			ringQ.NTT(ctB.Value[i].Value[j], bMul[i][j].Q)
+		}
+	}
+
+	ctC.Pack = pack
+	ctC.IsDiagonal = true
+	ctC.Layout = nil
 
-			eval.eval.conv.ModDownQPtoP(levelQ, levelQMul, eval.poolAMul[i][j].Q, eval.poolAMul[i][j].P, eval.poolAMul[i][j].P)
-			eval.eval.conv.ModUpPtoQ(levelQMul, levelQ, eval.poolAMul[i][j].P, eval.poolAMul[i][j].Q)
+	for i := 0; i < dim; i++ {
+		galEl := eval.eval.params.GaloisElementForColumnRotationBy(uint64(pack * i))
 
-			ringQ.NTT(eval.poolAMul[i][j].Q, eval.poolAMul[i][j].Q)
-			ringQMul.NTT(eval.poolAMul[i][j].P, eval.poolAMul[i][j].P)
+		eval.poolCMul[0].Q.Zero()
+		eval.poolCMul[1].Q.Zero()
 
-			ringQ.MForm(eval.poolAMul[i][j].Q, eval.poolAMul[i][j].Q)
-			ringQMul.MForm(eval.poolAMul[i][j].P, eval.poolAMul[i][j].P)
+		for j := 0; j < dim; j++ {
+			bIdx := (dim + i - j) % dim
+			ringQ.PermuteNTTWithIndexLvl(levelQ, bMul[bIdx][0].Q, eval.permuteQIdx[galEl], eval.poolRot[0].Q)
+			ringQ.PermuteNTTWithIndexLvl(levelQ, bMul[bIdx][1].Q, eval.permuteQIdx[galEl], eval.poolRot[1].Q)
+
+			ringQ.MulCoeffsMontgomeryAndAdd(ptA.Value[j].Value, eval.poolRot[0].Q, eval.poolCMul[0].Q) // 1
+			ringQ.MulCoeffsMontgomeryAndAdd(ptA.Value[j].Value, eval.poolRot[1].Q, eval.poolCMul[1].Q) // rot(s)
+		}
+
+		ringQ.InvNTT(eval.poolCMul[0].Q, ctC.Value[i].Value[0])
+
+		if i == 0 {
+			ringQ.InvNTT(eval.poolCMul[1].Q, ctC.Value[i].Value[1])
+			continue
 		}
+
+		// KeySwitch rot(s) -> (1, s)
+		ringQ.InvNTT(eval.poolCMul[1].Q, eval.poolC[0])
+		eval.keySwitch(eval.poolC[0], eval.rks.Keys[galEl].GadgetCiphertext, eval.poolKeySwitch[0])
+
+		ringQ.Add(ctC.Value[i].Value[0], eval.poolKeySwitch[0].Value[0], ctC.Value[i].Value[0])
+		ctC.Value[i].Value[1].Copy(eval.poolKeySwitch[0].Value[1])
 	}
 
-	// Fill poolBMul
+	return
+}
+
+// MulPlainRightNew multiplies the encrypted matrix ctA by the plaintext matrix ptB and returns the result.
+// See MulPlainRight.
+func (eval *MatrixEvaluator) MulPlainRightNew(ctA *MatrixCiphertext, ptB *MatrixPlaintextMul) (ctC *MatrixCiphertext, err error) {
+	if ctC, err = NewMatrixCiphertext(eval.eval.params, len(ctA.Value), true); err != nil {
+		return nil, fmt.Errorf("cannot MulPlainRightNew: %w", err)
+	}
+
+	if err = eval.MulPlainRight(ctA, ptB, ctC); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// MulPlainRight multiplies the encrypted matrix ctA by the plaintext matrix ptB and writes the result on ctC.
+// ctA must be packed diagonally, ptB shifted diagonally, and ctC is packed diagonally.
+// Since only the diagonals of ptB are rotated, neither rotation keys nor a relinearization key are needed.
+func (eval *MatrixEvaluator) MulPlainRight(ctA *MatrixCiphertext, ptB *MatrixPlaintextMul, ctC *MatrixCiphertext) (err error) {
+	if err = eval.checkMatrixPlaintextMul(ptB); err != nil {
+		return fmt.Errorf("cannot MulPlainRight: %w", err)
+	}
+
+	if err = eval.checkMatrixCiphertexts(ctA, ctC); err != nil {
+		return fmt.Errorf("cannot MulPlainRight: %w", err)
+	}
+
+	if err = checkUniform(ctA); err != nil {
+		return fmt.Errorf("cannot MulPlainRight: %w", err)
+	}
+
+	if !(ctA.IsDiagonal && !ptB.IsDiagonal && ctC.IsDiagonal) {
+		return fmt.Errorf("cannot MulPlainRight: %w: ctA and ctC must be diagonal and ptB shifted diagonal", ErrEncodingMismatch)
+	}
+
+	pack := ctA.Pack
+	dim := len(ctA.Value)
+	if len(ptB.Value) != dim || len(ctC.Value) != dim {
+		return fmt.Errorf("cannot MulPlainRight: %w: dimensions do not match", ErrEncodingMismatch)
+	}
+	if ptB.Pack != pack || ctC.Pack != pack {
+		return fmt.Errorf("cannot MulPlainRight: %w: packs do not match", ErrEncodingMismatch)
+	}
+
+	ringQ := eval.eval.params.RingQ()
+	levelQ := len(ringQ.Modulus) - 1
+
+	// Fill aMul
+	aMul, _ := eval.mulPools(dim)
 	for i := 0; i < dim; i++ {
 		for j := 0; j < 2; j++ {
-			eval.poolBMul[i][j].Q.Copy(ctB.Value[i].Value[j])
-			eval.eval.conv.ModUpQtoP(levelQ, levelQMul, eval.poolBMul[i][j].Q, eval.poolBMul[i][j].P)
-
-			ringQ.NTT(eval.poolBMul[i][j].Q, eval.poolBMul[i][j].Q)
-			ringQMul.NTT(eval.poolBMul[i][j].P, eval.poolBMul[i][j].P)
+			ringQ.NTT(ctA.Value[i].Value[j], aMul[i][j].Q)
 		}
 	}
 
-	QMargin := int(math.Exp2(64)/float64(utils.MaxSliceUint64(ringQ.Modulus))) >> 1
-	QMulMargin := int(math.Exp2(64)/float64(utils.MaxSliceUint64(ringQMul.Modulus))) >> 1
+	ctC.Pack = pack
+	ctC.IsDiagonal = true
+	ctC.Layout = nil
 
-	// Compute the multiplication
 	for i := 0; i < dim; i++ {
 		galEl := eval.eval.params.GaloisElementForColumnRotationBy(uint64(pack * i))
 
-		for j := 0; j < 4; j++ {
-			eval.poolCMul[j].Q.Zero()
-			eval.poolCMul[j].P.Zero()
-			eval.poolC[j].Zero()
-		}
+		eval.poolCMul[0].Q.Zero()
+		eval.poolCMul[1].Q.Zero()
 
-		var reduce int
 		for j := 0; j < dim; j++ {
 			bIdx := (dim + i - j) % dim
-			ringQ.PermuteNTTWithIndexLvl(levelQ, eval.poolBMul[bIdx][0].Q, eval.permuteQIdx[galEl], eval.poolRot[0].Q)
-			ringQ.PermuteNTTWithIndexLvl(levelQ, eval.poolBMul[bIdx][1].Q, eval.permuteQIdx[galEl], eval.poolRot[1].Q)
-			ringQMul.PermuteNTTWithIndexLvl(levelQMul, eval.poolBMul[bIdx][0].P, eval.permuteQMulIdx[galEl], eval.poolRot[0].P)
-			ringQMul.PermuteNTTWithIndexLvl(levelQMul, eval.poolBMul[bIdx][1].P, eval.permuteQMulIdx[galEl], eval.poolRot[1].P)
+			ringQ.PermuteNTTWithIndexLvl(levelQ, ptB.Value[bIdx].Value, eval.permuteQIdx[galEl], eval.poolRot[0].Q)
 
-			ringQ.MulCoeffsMontgomeryConstantAndAddNoMod(eval.poolAMul[j][0].Q, eval.poolRot[0].Q, eval.poolCMul[0].Q)
-			ringQMul.MulCoeffsMontgomeryConstantAndAddNoMod(eval.poolAMul[j][0].P, eval.poolRot[0].P, eval.poolCMul[0].P) // 1
+			ringQ.MulCoeffsMontgomeryAndAdd(aMul[j][0].Q, eval.poolRot[0].Q, eval.poolCMul[0].Q)
+			ringQ.MulCoeffsMontgomeryAndAdd(aMul[j][1].Q, eval.poolRot[0].Q, eval.poolCMul[1].Q)
+		}
 
-			ringQ.MulCoeffsMontgomeryConstantAndAddNoMod(eval.poolAMul[j][1].Q, eval.poolRot[0].Q, eval.poolCMul[1].Q)
-			ringQMul.MulCoeffsMontgomeryConstantAndAddNoMod(eval.poolAMul[j][1].P, eval.poolRot[0].P, eval.poolCMul[1].P) // s
+		ringQ.InvNTT(eval.poolCMul[0].Q, ctC.Value[i].Value[0])
+		ringQ.InvNTT(eval.poolCMul[1].Q, ctC.Value[i].Value[1])
+	}
 
-			ringQ.MulCoeffsMontgomeryConstantAndAddNoMod(eval.poolAMul[j][0].Q, eval.poolRot[1].Q, eval.poolCMul[2].Q)
-			ringQMul.MulCoeffsMontgomeryConstantAndAddNoMod(eval.poolAMul[j][0].P, eval.poolRot[1].P, eval.poolCMul[2].P) // rot(s)
+	return
+}
 
-			ringQ.MulCoeffsMontgomeryConstantAndAddNoMod(eval.poolAMul[j][1].Q, eval.poolRot[1].Q, eval.poolCMul[3].Q)
-			ringQMul.MulCoeffsMontgomeryConstantAndAddNoMod(eval.poolAMul[j][1].P, eval.poolRot[1].P, eval.poolCMul[3].P) // s*rot(s)
+// checkMulKeys checks that the relinearization key and the rotation keys for the rotations by pack * i,
+// needed by the multiplication of matrices of dimension dim, are available.
+func (eval *MatrixEvaluator) checkMulKeys(pack, dim int) (err error) {
+	if eval.rlk == nil || len(eval.rlk.Keys) == 0 {
+		return ErrMissingRelinearizationKey
+	}
 
-			if reduce%QMargin == QMargin-1 {
-				ringQ.Reduce(eval.poolCMul[0].Q, eval.poolCMul[0].Q)
-				ringQ.Reduce(eval.poolCMul[1].Q, eval.poolCMul[1].Q)
-				ringQ.Reduce(eval.poolCMul[2].Q, eval.poolCMul[2].Q)
-				ringQ.Reduce(eval.poolCMul[3].Q, eval.poolCMul[3].Q)
-			}
-			if reduce%QMulMargin == QMulMargin-1 {
-				ringQMul.Reduce(eval.poolCMul[0].P, eval.poolCMul[0].P)
-				ringQMul.Reduce(eval.poolCMul[1].P, eval.poolCMul[1].P)
-				ringQMul.Reduce(eval.poolCMul[2].P, eval.poolCMul[2].P)
-				ringQMul.Reduce(eval.poolCMul[3].P, eval.poolCMul[3].P)
-			}
-			reduce++
+	for i := 0; i < dim; i++ {
+		galEl := eval.eval.params.GaloisElementForColumnRotationBy(uint64(pack * i))
+		if eval.rks == nil || eval.rks.Keys[galEl] == nil {
+			return fmt.Errorf("%w: rotation by %d", ErrMissingRotationKey, pack*i)
 		}
+	}
+	return
+}
+
+// fillAMul extends ct to (Q, QMul), scaled by QMul, in the NTT and Montgomery domain into aMul.
+func (eval *MatrixEvaluator) fillAMul(ct *Ciphertext, aMul [2]*ringqp.Poly) {
+	params := eval.eval.params
+	ringQ := params.RingQ()
+	ringQMul := params.RingQMul()
+	levelQ := len(ringQ.Modulus) - 1
+	levelQMul := len(ringQMul.Modulus) - 1
+
+	for j := 0; j < 2; j++ {
+		ringQ.MulScalarBigint(ct.Value[j], ringQMul.ModulusAtLevel[len(ringQMul.Modulus)-1], aMul[j].Q)
+		aMul[j].P.Zero()
+
+		eval.eval.conv.ModDownQPtoP(levelQ, levelQMul, aMul[j].Q, aMul[j].P, aMul[j].P)
+		eval.eval.conv.ModUpPtoQ(levelQMul, levelQ, aMul[j].P, aMul[j].Q)
+
+		ringQ.NTT(aMul[j].Q, aMul[j].Q)
+		ringQMul.NTT(aMul[j].P, aMul[j].P)
+
+		ringQ.MForm(aMul[j].Q, aMul[j].Q)
+		ringQMul.MForm(aMul[j].P, aMul[j].P)
+	}
+}
+
+// fillBMul extends ct to (Q, QMul) in the NTT domain into bMul.
+func (eval *MatrixEvaluator) fillBMul(ct *Ciphertext, bMul [2]*ringqp.Poly) {
+	params := eval.eval.params
+	ringQ := params.RingQ()
+	ringQMul := params.RingQMul()
+	levelQ := len(ringQ.Modulus) - 1
+	levelQMul := len(ringQMul.Modulus) - 1
+
+	for j := 0; j < 2; j++ {
+		bMul[j].Q.Copy(ct.Value[j])
+		eval.eval.conv.ModUpQtoP(levelQ, levelQMul, bMul[j].Q, bMul[j].P)
+
+		ringQ.NTT(bMul[j].Q, bMul[j].Q)
+		ringQMul.NTT(bMul[j].P, bMul[j].P)
+	}
+}
+
+// mulDiagonals computes the diagonals of the product of the matrices extended in aMul and bMul
+// by fillAMul and fillBMul, and writes them on ctC.
+func (eval *MatrixEvaluator) mulDiagonals(pack, dim int, aMul, bMul [][2]*ringqp.Poly, ctC *MatrixCiphertext) {
+	ctC.Pack = pack
+	ctC.IsDiagonal = true
+	ctC.Layout = nil
+
+	if len(eval.workers) > 0 {
+		eval.mulDiagonalsParallel(pack, dim, aMul, bMul, ctC)
+		return
+	}
+
+	for i := 0; i < dim; i++ {
+		eval.mulDiagonal(dim, i, eval.eval.params.GaloisElementForColumnRotationBy(uint64(pack*i)), aMul, bMul, ctC.Value[i])
+	}
+}
+
+// mulDiagonal computes sum_j aMul[j] * rot(bMul[i - j]), where rot is the automorphism galEl,
+// rescales it by T / Q and switches its keys back to (1, s), and writes the result on ctOut.
+func (eval *MatrixEvaluator) mulDiagonal(dim, i int, galEl uint64, aMul, bMul [][2]*ringqp.Poly, ctOut *Ciphertext) {
+	acc := eval.poolCMul
+	zeroAccumulator(acc)
 
-		if reduce%QMargin != 0 {
-			ringQ.Reduce(eval.poolCMul[0].Q, eval.poolCMul[0].Q)
-			ringQ.Reduce(eval.poolCMul[1].Q, eval.poolCMul[1].Q)
-			ringQ.Reduce(eval.poolCMul[2].Q, eval.poolCMul[2].Q)
-			ringQ.Reduce(eval.poolCMul[3].Q, eval.poolCMul[3].Q)
+	var reduce int
+	for j := 0; j < dim; j++ {
+		eval.accumulateProduct(aMul[j], bMul[(dim+i-j)%dim], galEl, acc, &reduce)
+	}
+
+	eval.finalizeDiagonal(acc, reduce, galEl, ctOut)
+}
+
+// zeroAccumulator sets the accumulator of the terms in 1, s, rot(s) and s*rot(s) of a product to zero.
+func zeroAccumulator(acc [4]*ringqp.Poly) {
+	for j := 0; j < 4; j++ {
+		acc[j].Q.Zero()
+		acc[j].P.Zero()
+	}
+}
+
+// accumulateProduct adds a * rot(b), where rot is the automorphism galEl, to acc without modular reduction,
+// and reduces acc every time the counter reduce reaches the overflow margin of the moduli.
+func (eval *MatrixEvaluator) accumulateProduct(a, b [2]*ringqp.Poly, galEl uint64, acc [4]*ringqp.Poly, reduce *int) {
+	params := eval.eval.params
+	ringQ := params.RingQ()
+	ringQMul := params.RingQMul()
+	levelQ := len(ringQ.Modulus) - 1
+	levelQMul := len(ringQMul.Modulus) - 1
+
+	QMargin := int(math.Exp2(64)/float64(utils.MaxSliceUint64(ringQ.Modulus))) >> 1
+	QMulMargin := int(math.Exp2(64)/float64(utils.MaxSliceUint64(ringQMul.Modulus))) >> 1
+
+	ringQ.PermuteNTTWithIndexLvl(levelQ, b[0].Q, eval.permuteQIdx[galEl], eval.poolRot[0].Q)
+	ringQ.PermuteNTTWithIndexLvl(levelQ, b[1].Q, eval.permuteQIdx[galEl], eval.poolRot[1].Q)
+	ringQMul.PermuteNTTWithIndexLvl(levelQMul, b[0].P, eval.permuteQMulIdx[galEl], eval.poolRot[0].P)
+	ringQMul.PermuteNTTWithIndexLvl(levelQMul, b[1].P, eval.permuteQMulIdx[galEl], eval.poolRot[1].P)
+
+	ringQ.MulCoeffsMontgomeryConstantAndAddNoMod(a[0].Q, eval.poolRot[0].Q, acc[0].Q)
+	ringQMul.MulCoeffsMontgomeryConstantAndAddNoMod(a[0].P, eval.poolRot[0].P, acc[0].P) // 1
+
+	ringQ.MulCoeffsMontgomeryConstantAndAddNoMod(a[1].Q, eval.poolRot[0].Q, acc[1].Q)
+	ringQMul.MulCoeffsMontgomeryConstantAndAddNoMod(a[1].P, eval.poolRot[0].P, acc[1].P) // s
+
+	ringQ.MulCoeffsMontgomeryConstantAndAddNoMod(a[0].Q, eval.poolRot[1].Q, acc[2].Q)
+	ringQMul.MulCoeffsMontgomeryConstantAndAddNoMod(a[0].P, eval.poolRot[1].P, acc[2].P) // rot(s)
+
+	ringQ.MulCoeffsMontgomeryConstantAndAddNoMod(a[1].Q, eval.poolRot[1].Q, acc[3].Q)
+	ringQMul.MulCoeffsMontgomeryConstantAndAddNoMod(a[1].P, eval.poolRot[1].P, acc[3].P) // s*rot(s)
+
+	if *reduce%QMargin == QMargin-1 {
+		for j := 0; j < 4; j++ {
+			ringQ.Reduce(acc[j].Q, acc[j].Q)
 		}
-		if reduce%QMulMargin != 0 {
-			ringQMul.Reduce(eval.poolCMul[0].P, eval.poolCMul[0].P)
-			ringQMul.Reduce(eval.poolCMul[1].P, eval.poolCMul[1].P)
-			ringQMul.Reduce(eval.poolCMul[2].P, eval.poolCMul[2].P)
-			ringQMul.Reduce(eval.poolCMul[3].P, eval.poolCMul[3].P)
+	}
+	if *reduce%QMulMargin == QMulMargin-1 {
+		for j := 0; j < 4; j++ {
+			ringQMul.Reduce(acc[j].P, acc[j].P)
 		}
+	}
+	*reduce++
+}
+
+// finalizeDiagonal reduces acc, accumulated by reduce calls to accumulateProduct with the automorphism galEl,
+// rescales it by T / Q and switches its keys back to (1, s), and writes the result on ctOut.
+func (eval *MatrixEvaluator) finalizeDiagonal(acc [4]*ringqp.Poly, reduce int, galEl uint64, ctOut *Ciphertext) {
+	params := eval.eval.params
+	ringQ := params.RingQ()
+	ringQMul := params.RingQMul()
+	levelQ := len(ringQ.Modulus) - 1
+	levelQMul := len(ringQMul.Modulus) - 1
 
+	QMargin := int(math.Exp2(64)/float64(utils.MaxSliceUint64(ringQ.Modulus))) >> 1
+	QMulMargin := int(math.Exp2(64)/float64(utils.MaxSliceUint64(ringQMul.Modulus))) >> 1
+
+	if reduce%QMargin != 0 {
+		for j := 0; j < 4; j++ {
+			ringQ.Reduce(acc[j].Q, acc[j].Q)
+		}
+	}
+	if reduce%QMulMargin != 0 {
 		for j := 0; j < 4; j++ {
-			ringQ.InvNTT(eval.poolCMul[j].Q, eval.poolCMul[j].Q)
-			ringQMul.InvNTT(eval.poolCMul[j].P, eval.poolCMul[j].P)
+			ringQMul.Reduce(acc[j].P, acc[j].P)
+		}
+	}
 
-			eval.eval.conv.ModDownQPtoQ(levelQ, levelQMul, eval.poolCMul[j].Q, eval.poolCMul[j].P, eval.poolCMul[j].Q)
+	for j := 0; j < 4; j++ {
+		ringQ.InvNTT(acc[j].Q, acc[j].Q)
+		ringQMul.InvNTT(acc[j].P, acc[j].P)
 
-			ringQ.MultByMonomial(eval.poolCMul[j].Q, params.Slots(), eval.poolC[j])
-			ringQ.MulScalarBigint(eval.poolCMul[j].Q, params.b, eval.poolCMul[j].Q)
-			ringQ.Sub(eval.poolC[j], eval.poolCMul[j].Q, eval.poolC[j])
+		eval.eval.conv.ModDownQPtoQ(levelQ, levelQMul, acc[j].Q, acc[j].P, acc[j].Q)
+
+		ringQ.MultByMonomial(acc[j].Q, params.Slots(), eval.poolC[j])
+		ringQ.MulScalarBigint(acc[j].Q, params.b, acc[j].Q)
+		ringQ.Sub(eval.poolC[j], acc[j].Q, eval.poolC[j])
+	}
+
+	ctOut.Value[0].Copy(eval.poolC[0])
+	ctOut.Value[1].Copy(eval.poolC[1])
+
+	// KeySwitch rot(s) -> (1, s)
+	eval.keySwitch(eval.poolC[2], eval.rks.Keys[galEl].GadgetCiphertext, eval.poolKeySwitch[0])
+
+	// KeySwitch s*rot(s) -> (s, s^2)
+	eval.keySwitch(eval.poolC[3], eval.rks.Keys[galEl].GadgetCiphertext, eval.poolKeySwitch[1])
+	ringQ.Add(eval.poolKeySwitch[1].Value[0], eval.poolKeySwitch[0].Value[1], eval.poolKeySwitch[0].Value[1])
+
+	// KeySwitch s^2 -> (1, s)
+	eval.keySwitch(eval.poolKeySwitch[1].Value[1], eval.rlk.Keys[0].GadgetCiphertext, eval.poolKeySwitch[2])
+	ringQ.Add(eval.poolKeySwitch[2].Value[0], eval.poolKeySwitch[0].Value[0], eval.poolKeySwitch[0].Value[0])
+	ringQ.Add(eval.poolKeySwitch[2].Value[1], eval.poolKeySwitch[0].Value[1], eval.poolKeySwitch[0].Value[1])
+
+	ringQ.Add(ctOut.Value[0], eval.poolKeySwitch[0].Value[0], ctOut.Value[0])
+	ringQ.Add(ctOut.Value[1], eval.poolKeySwitch[0].Value[1], ctOut.Value[1])
+}
+
+// keySwitch computes the gadget product of cx, out of the NTT domain, with gadgetCt and writes the result,
+// out of the NTT domain, on ctOut. The key switch is hybrid, i.e. performed modulo QP and divided by P,
+// when the parameters define a special modulus P, so that its noise does not grow with the moduli of Q.
+func (eval *MatrixEvaluator) keySwitch(cx *ring.Poly, gadgetCt rlwe.GadgetCiphertext, ctOut *rlwe.Ciphertext) {
+	eval.eval.ksw.GadgetProduct(eval.eval.params.MaxLevel(), cx, gadgetCt, ctOut)
+}
+
+// checkMatrixCiphertexts checks that each MatrixCiphertext packs Pack matrices of dimension
+// len(Value) in the slots and that all its ciphertexts are of degree 1.
+func (eval *MatrixEvaluator) checkMatrixCiphertexts(cts ...*MatrixCiphertext) (err error) {
+	for _, ct := range cts {
+		if len(ct.Value) == 0 || ct.Pack*len(ct.Value) != eval.eval.params.Slots() {
+			return fmt.Errorf("%w: pack * dim must be equal to the number of slots", ErrEncodingMismatch)
 		}
 
-		ctC.Value[i].Value[0].Copy(eval.poolC[0])
-		ctC.Value[i].Value[1].Copy(eval.poolC[1])
+		if err = eval.checkDim(len(ct.Value)); err != nil {
+			return
+		}
 
-		// KeySwitch rot(s) -> (1, s)
-		eval.eval.ksw.GadgetProductNoPNoModDown(levelQ, eval.poolC[2], eval.rks.Keys[galEl].GadgetCiphertext, eval.poolKeySwitch[0])
+		for i := range ct.Value {
+			if ct.Value[i] == nil || ct.Value[i].Degree() != 1 {
+				return ErrInvalidDegree
+			}
+		}
+	}
+	return
+}
 
-		// KeySwitch s*rot(s) -> (s, s^2)
-		eval.eval.ksw.GadgetProductNoPNoModDown(levelQ, eval.poolC[3], eval.rks.Keys[galEl].GadgetCiphertext, eval.poolKeySwitch[1])
-		ringQ.Add(eval.poolKeySwitch[1].Value[0], eval.poolKeySwitch[0].Value[1], eval.poolKeySwitch[0].Value[1])
+// checkMatrixPlaintextMul checks that pt packs Pack matrices of dimension len(Value) in the slots.
+func (eval *MatrixEvaluator) checkMatrixPlaintextMul(pt *MatrixPlaintextMul) (err error) {
+	if len(pt.Value) == 0 || pt.Pack*len(pt.Value) != eval.eval.params.Slots() {
+		return fmt.Errorf("%w: pack * dim must be equal to the number of slots", ErrEncodingMismatch)
+	}
 
-		// KeySwitch s^2 -> (1, s)
-		ringQ.InvNTT(eval.poolKeySwitch[1].Value[1], eval.poolKeySwitch[1].Value[1])
-		eval.eval.ksw.GadgetProductNoPNoModDown(levelQ, eval.poolKeySwitch[1].Value[1], eval.rlk.Keys[0].GadgetCiphertext, eval.poolKeySwitch[2])
-		ringQ.Add(eval.poolKeySwitch[2].Value[0], eval.poolKeySwitch[0].Value[0], eval.poolKeySwitch[0].Value[0])
-		ringQ.Add(eval.poolKeySwitch[2].Value[1], eval.poolKeySwitch[0].Value[1], eval.poolKeySwitch[0].Value[1])
+	if err = eval.checkDim(len(pt.Value)); err != nil {
+		return
+	}
 
-		ringQ.InvNTT(eval.poolKeySwitch[0].Value[0], eval.poolKeySwitch[0].Value[0])
-		ringQ.InvNTT(eval.poolKeySwitch[0].Value[1], eval.poolKeySwitch[0].Value[1])
+	for i := range pt.Value {
+		if pt.Value[i] == nil {
+			return fmt.Errorf("%w: missing diagonal %d", ErrEncodingMismatch, i)
+		}
+	}
+	return
+}
 
-		ringQ.Add(ctC.Value[i].Value[0], eval.poolKeySwitch[0].Value[0], ctC.Value[i].Value[0])
-		ringQ.Add(ctC.Value[i].Value[1], eval.poolKeySwitch[0].Value[1], ctC.Value[i].Value[1])
+// checkDim checks that eval supports the matrices of dimension dim, see NewMatrixEvaluatorForDim.
+func (eval *MatrixEvaluator) checkDim(dim int) (err error) {
+	if eval.dim != 0 && dim != eval.dim {
+		return fmt.Errorf("%w: the MatrixEvaluator is restricted to dim %d, got %d", ErrEncodingMismatch, eval.dim, dim)
 	}
+	return
 }
@@ -0,0 +1,434 @@
+package hpbfv
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// MatrixPlacement is the placement of one matrix of a MatrixLayout.
+// The matrix, of dimension Dim, occupies the slots j * Slots() / Dim + Offset, for 0 <= j < Dim,
+// of the first Dim diagonals, where 0 <= Offset < Slots() / Dim.
+type MatrixPlacement struct {
+	Dim    int
+	Offset int
+}
+
+// MatrixLayout describes the placement in the slots of matrices of possibly different dimensions,
+// which do not need to fill the slots.
+// Since the slots of a matrix of dimension dim are Slots() / dim apart, the rotations of its diagonals
+// by multiples of Slots() / dim rotate its rows, as in the uniform packing of Pack matrices of the same
+// dimension, which is the layout {(dim, 0), (dim, 1), ..., (dim, Pack-1)}.
+// A matrix container with a MatrixLayout has len(Value) = Dim() diagonals, the diagonals beyond the
+// dimension of a matrix being zero on its slots, and Pack = Slots() / Dim().
+type MatrixLayout struct {
+	Matrices []MatrixPlacement
+}
+
+// NewMatrixLayout returns a MatrixLayout placing matrices of dimension dims[l], in the order of dims.
+// The matrices are placed by decreasing dimension, each one on the free slots of smallest offset,
+// which packs them without gaps. It returns an error if a dimension does not divide the number of slots
+// or if the matrices do not fit in the slots, i.e. if the sum of the dims[l] exceeds Slots().
+func NewMatrixLayout(params Parameters, dims ...int) (layout *MatrixLayout, err error) {
+	if len(dims) == 0 {
+		return nil, fmt.Errorf("cannot NewMatrixLayout: %w: no matrices", ErrInvalidLayout)
+	}
+
+	for _, dim := range dims {
+		if _, err = packFor(params, dim); err != nil {
+			return nil, fmt.Errorf("cannot NewMatrixLayout: %w", err)
+		}
+	}
+
+	order := make([]int, len(dims))
+	for l := range order {
+		order[l] = l
+	}
+	sort.SliceStable(order, func(a, b int) bool { return dims[order[a]] > dims[order[b]] })
+
+	slots := params.Slots()
+	used := make([]bool, slots)
+
+	layout = &MatrixLayout{Matrices: make([]MatrixPlacement, len(dims))}
+	for _, l := range order {
+		dim := dims[l]
+		stride := slots / dim
+
+		offset := -1
+		for r := 0; r < stride && offset < 0; r++ {
+			if !used[r] {
+				offset = r
+			}
+		}
+
+		if offset < 0 {
+			return nil, fmt.Errorf("cannot NewMatrixLayout: %w: the matrices do not fit in the slots", ErrInvalidLayout)
+		}
+
+		for j := 0; j < dim; j++ {
+			used[j*stride+offset] = true
+		}
+
+		layout.Matrices[l] = MatrixPlacement{Dim: dim, Offset: offset}
+	}
+
+	return
+}
+
+// Dim returns the largest dimension of the matrices of the layout.
+func (layout *MatrixLayout) Dim() (dim int) {
+	for _, m := range layout.Matrices {
+		if m.Dim > dim {
+			dim = m.Dim
+		}
+	}
+	return
+}
+
+// Equal returns true if the two layouts place the same matrices on the same slots.
+// The nil layout is only equal to itself.
+func (layout *MatrixLayout) Equal(other *MatrixLayout) bool {
+	if layout == nil || other == nil {
+		return layout == other
+	}
+
+	if len(layout.Matrices) != len(other.Matrices) {
+		return false
+	}
+
+	for l := range layout.Matrices {
+		if layout.Matrices[l] != other.Matrices[l] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// check checks that the matrices of the layout have dimensions dividing the number of slots
+// and valid offsets, and that they do not overlap.
+func (layout *MatrixLayout) check(params Parameters) (err error) {
+	return layout.checkSlots(params.Slots())
+}
+
+// checkSlots is check for a given number of slots.
+func (layout *MatrixLayout) checkSlots(slots int) (err error) {
+	if len(layout.Matrices) == 0 {
+		return fmt.Errorf("%w: no matrices", ErrInvalidLayout)
+	}
+
+	used := make([]bool, slots)
+	for l, m := range layout.Matrices {
+		if m.Dim <= 0 || slots%m.Dim != 0 {
+			return ErrDimNotDivisor
+		}
+		stride := slots / m.Dim
+
+		if m.Offset < 0 || m.Offset >= stride {
+			return fmt.Errorf("%w: offset of matrix %d must be in [0, Slots() / Dim)", ErrInvalidLayout, l)
+		}
+
+		for j := 0; j < m.Dim; j++ {
+			if used[j*stride+m.Offset] {
+				return fmt.Errorf("%w: matrix %d overlaps another matrix", ErrInvalidLayout, l)
+			}
+			used[j*stride+m.Offset] = true
+		}
+	}
+
+	return
+}
+
+// dims returns the distinct dimensions of the matrices of the layout, by decreasing order.
+func (layout *MatrixLayout) dims() (dims []int) {
+	seen := map[int]bool{}
+	for _, m := range layout.Matrices {
+		if !seen[m.Dim] {
+			seen[m.Dim] = true
+			dims = append(dims, m.Dim)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(dims)))
+	return
+}
+
+// forEachLayoutSlot calls f(l, row, col, i, slot) for every entry (row, col) of the l-th matrix of layout,
+// which the diagonal or shifted diagonal encoding stores in the slot of the i-th Message.
+func forEachLayoutSlot(slots int, layout *MatrixLayout, isDiagonal bool, f func(l, row, col, i, slot int)) {
+	for l, m := range layout.Matrices {
+		dim := m.Dim
+		stride := slots / dim
+		for i := 0; i < dim; i++ {
+			for j := 0; j < dim; j++ {
+				if isDiagonal {
+					f(l, j, (j+i)%dim, i, j*stride+m.Offset)
+				} else {
+					f(l, (dim+j-i)%dim, j, i, j*stride+m.Offset)
+				}
+			}
+		}
+	}
+}
+
+// NewMatrixMessageWithLayout creates a new MatrixMessage of Dim() diagonals for the matrices of layout.
+func NewMatrixMessageWithLayout(params Parameters, layout *MatrixLayout, isDiagonal bool) (em *MatrixMessage, err error) {
+	if err = layout.check(params); err != nil {
+		return nil, fmt.Errorf("cannot NewMatrixMessageWithLayout: %w", err)
+	}
+
+	if em, err = NewMatrixMessage(params, layout.Dim(), isDiagonal); err != nil {
+		return nil, err
+	}
+	em.Layout = layout
+
+	return
+}
+
+// NewMatrixCiphertextWithLayout creates a new MatrixCiphertext of Dim() diagonals for the matrices of layout.
+func NewMatrixCiphertextWithLayout(params Parameters, layout *MatrixLayout, isDiagonal bool) (cm *MatrixCiphertext, err error) {
+	if err = layout.check(params); err != nil {
+		return nil, fmt.Errorf("cannot NewMatrixCiphertextWithLayout: %w", err)
+	}
+
+	if cm, err = NewMatrixCiphertext(params, layout.Dim(), isDiagonal); err != nil {
+		return nil, err
+	}
+	cm.Layout = layout
+
+	return
+}
+
+// EncodeLayoutMatrixMessageNew encodes the matrices, the l-th of which must be of dimension
+// layout.Matrices[l].Dim, into a MatrixMessage following layout. The slots outside of the matrices are zero.
+func (ecd *MatrixEncoder) EncodeLayoutMatrixMessageNew(matrices [][][]*big.Int, layout *MatrixLayout, isDiagonal bool) (em *MatrixMessage, err error) {
+	params := ecd.ecd.params
+
+	if em, err = NewMatrixMessageWithLayout(params, layout, isDiagonal); err != nil {
+		return nil, fmt.Errorf("cannot EncodeLayoutMatrixMessageNew: %w", err)
+	}
+
+	if len(matrices) != len(layout.Matrices) {
+		return nil, fmt.Errorf("cannot EncodeLayoutMatrixMessageNew: %w: expected %d matrices", ErrEncodingMismatch, len(layout.Matrices))
+	}
+
+	for l, m := range layout.Matrices {
+		if len(matrices[l]) != m.Dim {
+			return nil, fmt.Errorf("cannot EncodeLayoutMatrixMessageNew: %w: matrix %d must be of dimension %d", ErrEncodingMismatch, l, m.Dim)
+		}
+		for i := range matrices[l] {
+			if len(matrices[l][i]) != m.Dim {
+				return nil, fmt.Errorf("cannot EncodeLayoutMatrixMessageNew: %w: matrix %d must be of dimension %d", ErrEncodingMismatch, l, m.Dim)
+			}
+		}
+	}
+
+	forEachLayoutSlot(params.Slots(), layout, isDiagonal, func(l, row, col, i, slot int) {
+		ecd.zt.SetBigInt(matrices[l][row][col], em.Value[i].Value[slot])
+	})
+
+	return
+}
+
+// EncodeLayoutMatrixNew encodes the matrices into a MatrixPlaintext following layout,
+// see EncodeLayoutMatrixMessageNew.
+func (ecd *MatrixEncoder) EncodeLayoutMatrixNew(matrices [][][]*big.Int, layout *MatrixLayout, isDiagonal bool) (pt *MatrixPlaintext, err error) {
+	em, err := ecd.EncodeLayoutMatrixMessageNew(matrices, layout, isDiagonal)
+	if err != nil {
+		return nil, err
+	}
+
+	if pt, err = NewMatrixPlaintext(ecd.ecd.params, len(em.Value), isDiagonal); err != nil {
+		return nil, fmt.Errorf("cannot EncodeLayoutMatrixNew: %w", err)
+	}
+	pt.Layout = layout
+
+	for i := range em.Value {
+		ecd.ecd.Encode(em.Value[i], pt.Value[i])
+	}
+
+	return
+}
+
+// DecodeLayoutMatrixMessageNew decodes a MatrixMessage with a MatrixLayout into its matrices,
+// the l-th of which is of dimension em.Layout.Matrices[l].Dim.
+func (ecd *MatrixEncoder) DecodeLayoutMatrixMessageNew(em *MatrixMessage) (matrices [][][]*big.Int, err error) {
+	params := ecd.ecd.params
+
+	if em.Layout == nil {
+		return nil, fmt.Errorf("cannot DecodeLayoutMatrixMessageNew: %w: the MatrixMessage has no layout", ErrEncodingMismatch)
+	}
+
+	if err = em.Layout.check(params); err != nil {
+		return nil, fmt.Errorf("cannot DecodeLayoutMatrixMessageNew: %w", err)
+	}
+
+	if len(em.Value) != em.Layout.Dim() {
+		return nil, fmt.Errorf("cannot DecodeLayoutMatrixMessageNew: %w: expected %d diagonals", ErrEncodingMismatch, em.Layout.Dim())
+	}
+
+	for i := range em.Value {
+		if len(em.Value[i].Value) != params.Slots() {
+			return nil, fmt.Errorf("cannot DecodeLayoutMatrixMessageNew: %w: messages must have Slots() values", ErrEncodingMismatch)
+		}
+	}
+
+	matrices = make([][][]*big.Int, len(em.Layout.Matrices))
+	for l, m := range em.Layout.Matrices {
+		matrices[l] = make([][]*big.Int, m.Dim)
+		for i := range matrices[l] {
+			matrices[l][i] = make([]*big.Int, m.Dim)
+		}
+	}
+
+	forEachLayoutSlot(params.Slots(), em.Layout, em.IsDiagonal, func(l, row, col, i, slot int) {
+		matrices[l][row][col] = em.Value[i].Value[slot].BigInt()
+	})
+
+	return
+}
+
+// DecodeLayoutMatrixNew decodes a MatrixPlaintext with a MatrixLayout into its matrices,
+// see DecodeLayoutMatrixMessageNew.
+func (ecd *MatrixEncoder) DecodeLayoutMatrixNew(pt *MatrixPlaintext) (matrices [][][]*big.Int, err error) {
+	em, err := ecd.decodeMessages(pt)
+	if err != nil {
+		return nil, fmt.Errorf("cannot DecodeLayoutMatrixNew: %w", err)
+	}
+
+	return ecd.DecodeLayoutMatrixMessageNew(em)
+}
+
+// mulLayout is Mul for the operands with the MatrixLayout ctA.Layout.
+//
+// The diagonals i of the matrices of dimension dim are sum_j A_j * rot(B_{i-j mod dim}), for 0 <= i, j < dim,
+// where rot rotates the slots by (Slots() / dim) * i. When the layout mixes several dimensions, the terms
+// computed for one dimension are garbage on the slots of the matrices of the other dimensions, so the
+// product is computed once per dimension on the diagonals of ctA masked to the matrices of that dimension,
+// and the results are added. Each mask is a plaintext multiplication, whose noise growth is the one of
+// MulPlain; a layout with a single dimension, such as a partial pack, needs no mask.
+func (eval *MatrixEvaluator) mulLayout(ctA, ctB, ctC *MatrixCiphertext) (err error) {
+	params := eval.eval.params
+	layout := ctA.Layout
+
+	if err = layout.check(params); err != nil {
+		return
+	}
+
+	if !layout.Equal(ctB.Layout) {
+		return fmt.Errorf("%w: ctA and ctB must have the same layout", ErrEncodingMismatch)
+	}
+
+	maxDim := layout.Dim()
+	if len(ctA.Value) != maxDim || len(ctB.Value) != maxDim || len(ctC.Value) != maxDim {
+		return fmt.Errorf("%w: dimensions do not match", ErrEncodingMismatch)
+	}
+
+	dims := layout.dims()
+	for _, dim := range dims {
+		if err = eval.checkMulKeys(params.Slots()/dim, dim); err != nil {
+			return
+		}
+	}
+
+	aMul, bMul := eval.mulPools(maxDim)
+	for i := 0; i < maxDim; i++ {
+		eval.fillBMul(ctB.Value[i], bMul[i])
+	}
+
+	if len(dims) == 1 {
+		for i := 0; i < maxDim; i++ {
+			eval.fillAMul(ctA.Value[i], aMul[i])
+		}
+		eval.mulDiagonals(params.Slots()/maxDim, maxDim, aMul, bMul, ctC)
+		ctC.Layout = layout
+		return
+	}
+
+	// ctC may be ctA, whose diagonals are masked once per dimension, so the products are accumulated apart.
+	// The dimensions are by decreasing order, so the product of the first one initializes all the diagonals.
+	acc, ctDim := eval.layoutPools(maxDim)
+
+	ringQ := params.RingQ()
+	for k, dim := range dims {
+		mask := eval.layoutMask(layout, dim)
+		for i := 0; i < dim; i++ {
+			for j := 0; j < 2; j++ {
+				ringQ.NTT(ctA.Value[i].Value[j], eval.poolCt.Value[j])
+				ringQ.MulCoeffsMontgomery(eval.poolCt.Value[j], mask.Value, eval.poolCt.Value[j])
+				ringQ.InvNTT(eval.poolCt.Value[j], eval.poolCt.Value[j])
+			}
+			eval.fillAMul(eval.poolCt, aMul[i])
+		}
+
+		if k == 0 {
+			eval.mulDiagonals(params.Slots()/dim, dim, aMul, bMul, &MatrixCiphertext{Value: acc[:dim]})
+			continue
+		}
+
+		eval.mulDiagonals(params.Slots()/dim, dim, aMul, bMul, &MatrixCiphertext{Value: ctDim[:dim]})
+		for i := 0; i < dim; i++ {
+			eval.eval.Add(acc[i], ctDim[i], acc[i])
+		}
+	}
+
+	for i := 0; i < maxDim; i++ {
+		ctC.Value[i].Copy(acc[i].El())
+	}
+	ctC.Pack = params.Slots() / maxDim
+	ctC.IsDiagonal = true
+	ctC.Layout = layout
+
+	return
+}
+
+// layoutPools returns the buffers for the accumulated and the per-dimension products of mulLayout,
+// growing them if they are too small.
+func (eval *MatrixEvaluator) layoutPools(dim int) (acc, ctDim []*Ciphertext) {
+	for len(eval.poolLayout[0]) < dim {
+		eval.poolLayout[0] = append(eval.poolLayout[0], NewCiphertext(eval.eval.params, 1))
+		eval.poolLayout[1] = append(eval.poolLayout[1], NewCiphertext(eval.eval.params, 1))
+	}
+	return eval.poolLayout[0][:dim], eval.poolLayout[1][:dim]
+}
+
+// layoutMask returns the plaintext whose slots are one on the matrices of dimension dim of layout
+// and zero elsewhere. The masks are encoded on first use and cached.
+func (eval *MatrixEvaluator) layoutMask(layout *MatrixLayout, dim int) (mask *PlaintextMul) {
+	params := eval.eval.params
+
+	key := fmt.Sprint(dim, layout.Matrices)
+	if mask, ok := eval.masks[key]; ok {
+		return mask
+	}
+
+	if eval.ecd == nil {
+		eval.ecd = NewEncoder(params)
+		eval.masks = map[string]*PlaintextMul{}
+	}
+
+	msg := NewMessage(params)
+	stride := params.Slots() / dim
+	for _, m := range layout.Matrices {
+		if m.Dim == dim {
+			for j := 0; j < dim; j++ {
+				msg.Value[j*stride+m.Offset][0] = 1
+			}
+		}
+	}
+
+	mask = eval.ecd.EncodeMulNew(msg)
+	eval.masks[key] = mask
+
+	return
+}
+
+// checkUniform checks that the MatrixCiphertexts have no MatrixLayout, for the operations
+// that only support the uniform packing of matrices of the same dimension.
+func checkUniform(cts ...*MatrixCiphertext) (err error) {
+	for _, ct := range cts {
+		if ct.Layout != nil {
+			return fmt.Errorf("%w: the operation does not support matrix layouts", ErrEncodingMismatch)
+		}
+	}
+	return
+}
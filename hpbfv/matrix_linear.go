@@ -0,0 +1,259 @@
+package hpbfv
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// AddNew adds ctA to ctB and returns the result. See Add.
+func (eval *MatrixEvaluator) AddNew(ctA, ctB *MatrixCiphertext) (ctC *MatrixCiphertext, err error) {
+	if ctC, err = NewMatrixCiphertext(eval.eval.params, len(ctA.Value), ctA.IsDiagonal); err != nil {
+		return nil, fmt.Errorf("cannot AddNew: %w", err)
+	}
+
+	if err = eval.Add(ctA, ctB, ctC); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Add adds ctA to ctB and writes the result on ctC.
+// ctA and ctB must have the same dimension, Pack, encoding and layout.
+func (eval *MatrixEvaluator) Add(ctA, ctB, ctC *MatrixCiphertext) (err error) {
+	if err = eval.checkCompatible(ctA, ctB, ctC); err != nil {
+		return fmt.Errorf("cannot Add: %w", err)
+	}
+
+	for i := range ctA.Value {
+		eval.eval.Add(ctA.Value[i], ctB.Value[i], ctC.Value[i])
+	}
+
+	ctC.Pack = ctA.Pack
+	ctC.IsDiagonal = ctA.IsDiagonal
+	ctC.Layout = ctA.Layout
+
+	return
+}
+
+// SubNew subtracts ctB from ctA and returns the result. See Sub.
+func (eval *MatrixEvaluator) SubNew(ctA, ctB *MatrixCiphertext) (ctC *MatrixCiphertext, err error) {
+	if ctC, err = NewMatrixCiphertext(eval.eval.params, len(ctA.Value), ctA.IsDiagonal); err != nil {
+		return nil, fmt.Errorf("cannot SubNew: %w", err)
+	}
+
+	if err = eval.Sub(ctA, ctB, ctC); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Sub subtracts ctB from ctA and writes the result on ctC.
+// ctA and ctB must have the same dimension, Pack, encoding and layout.
+func (eval *MatrixEvaluator) Sub(ctA, ctB, ctC *MatrixCiphertext) (err error) {
+	if err = eval.checkCompatible(ctA, ctB, ctC); err != nil {
+		return fmt.Errorf("cannot Sub: %w", err)
+	}
+
+	for i := range ctA.Value {
+		eval.eval.Sub(ctA.Value[i], ctB.Value[i], ctC.Value[i])
+	}
+
+	ctC.Pack = ctA.Pack
+	ctC.IsDiagonal = ctA.IsDiagonal
+	ctC.Layout = ctA.Layout
+
+	return
+}
+
+// NegNew negates ctIn and returns the result. See Neg.
+func (eval *MatrixEvaluator) NegNew(ctIn *MatrixCiphertext) (ctOut *MatrixCiphertext, err error) {
+	if ctOut, err = NewMatrixCiphertext(eval.eval.params, len(ctIn.Value), ctIn.IsDiagonal); err != nil {
+		return nil, fmt.Errorf("cannot NegNew: %w", err)
+	}
+
+	if err = eval.Neg(ctIn, ctOut); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Neg negates ctIn and writes the result on ctOut.
+func (eval *MatrixEvaluator) Neg(ctIn, ctOut *MatrixCiphertext) (err error) {
+	if err = eval.checkCompatible(ctIn, ctOut); err != nil {
+		return fmt.Errorf("cannot Neg: %w", err)
+	}
+
+	for i := range ctIn.Value {
+		eval.eval.Neg(ctIn.Value[i], ctOut.Value[i])
+	}
+
+	ctOut.Pack = ctIn.Pack
+	ctOut.IsDiagonal = ctIn.IsDiagonal
+	ctOut.Layout = ctIn.Layout
+
+	return
+}
+
+// MulScalarNew multiplies ctIn by the scalar c and returns the result. See MulScalar.
+func (eval *MatrixEvaluator) MulScalarNew(ctIn *MatrixCiphertext, c *big.Int) (ctOut *MatrixCiphertext, err error) {
+	if ctOut, err = NewMatrixCiphertext(eval.eval.params, len(ctIn.Value), ctIn.IsDiagonal); err != nil {
+		return nil, fmt.Errorf("cannot MulScalarNew: %w", err)
+	}
+
+	if err = eval.MulScalar(ctIn, c, ctOut); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// MulScalar multiplies ctIn by the scalar c mod T and writes the result on ctOut.
+// Rather than by c itself, ctIn is multiplied by the representative of c modulo X^d - b whose
+// coefficients are the balanced base b digits of c, so that the noise grows with b and not with T.
+func (eval *MatrixEvaluator) MulScalar(ctIn *MatrixCiphertext, c *big.Int, ctOut *MatrixCiphertext) (err error) {
+	if err = eval.checkCompatible(ctIn, ctOut); err != nil {
+		return fmt.Errorf("cannot MulScalar: %w", err)
+	}
+
+	params := eval.eval.params
+	ringQ := params.RingQ()
+
+	coeffs := make([]*big.Int, params.N())
+	for i := range coeffs {
+		coeffs[i] = new(big.Int)
+	}
+	balancedDigits(params, new(big.Int).Set(c), coeffs, 0)
+
+	scalar := eval.poolC[2]
+	ringQ.SetCoefficientsBigint(coeffs, scalar)
+	ringQ.NTT(scalar, scalar)
+	ringQ.MForm(scalar, scalar)
+
+	for i := range ctIn.Value {
+		for j := 0; j < 2; j++ {
+			ringQ.NTT(ctIn.Value[i].Value[j], eval.poolC[0])
+			ringQ.MulCoeffsMontgomery(eval.poolC[0], scalar, eval.poolC[0])
+			ringQ.InvNTT(eval.poolC[0], ctOut.Value[i].Value[j])
+		}
+	}
+
+	ctOut.Pack = ctIn.Pack
+	ctOut.IsDiagonal = ctIn.IsDiagonal
+	ctOut.Layout = ctIn.Layout
+
+	return
+}
+
+// AddPlainNew adds the plaintext matrix ptB to ctA and returns the result. See AddPlain.
+func (eval *MatrixEvaluator) AddPlainNew(ctA *MatrixCiphertext, ptB *MatrixPlaintext) (ctC *MatrixCiphertext, err error) {
+	if ctC, err = NewMatrixCiphertext(eval.eval.params, len(ctA.Value), ctA.IsDiagonal); err != nil {
+		return nil, fmt.Errorf("cannot AddPlainNew: %w", err)
+	}
+
+	if err = eval.AddPlain(ctA, ptB, ctC); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// AddPlain adds the plaintext matrix ptB to ctA and writes the result on ctC.
+// ptB must have the same dimension, Pack, encoding and layout as ctA.
+func (eval *MatrixEvaluator) AddPlain(ctA *MatrixCiphertext, ptB *MatrixPlaintext, ctC *MatrixCiphertext) (err error) {
+	if err = eval.checkCompatiblePlain(ctA, ptB, ctC); err != nil {
+		return fmt.Errorf("cannot AddPlain: %w", err)
+	}
+
+	ringQ := eval.eval.params.RingQ()
+	for i := range ctA.Value {
+		ringQ.Add(ctA.Value[i].Value[0], ptB.Value[i].Value, ctC.Value[i].Value[0])
+		if ctA != ctC {
+			ctC.Value[i].Value[1].Copy(ctA.Value[i].Value[1])
+		}
+	}
+
+	ctC.Pack = ctA.Pack
+	ctC.IsDiagonal = ctA.IsDiagonal
+	ctC.Layout = ctA.Layout
+
+	return
+}
+
+// SubPlainNew subtracts the plaintext matrix ptB from ctA and returns the result. See SubPlain.
+func (eval *MatrixEvaluator) SubPlainNew(ctA *MatrixCiphertext, ptB *MatrixPlaintext) (ctC *MatrixCiphertext, err error) {
+	if ctC, err = NewMatrixCiphertext(eval.eval.params, len(ctA.Value), ctA.IsDiagonal); err != nil {
+		return nil, fmt.Errorf("cannot SubPlainNew: %w", err)
+	}
+
+	if err = eval.SubPlain(ctA, ptB, ctC); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// SubPlain subtracts the plaintext matrix ptB from ctA and writes the result on ctC.
+// ptB must have the same dimension, Pack, encoding and layout as ctA.
+func (eval *MatrixEvaluator) SubPlain(ctA *MatrixCiphertext, ptB *MatrixPlaintext, ctC *MatrixCiphertext) (err error) {
+	if err = eval.checkCompatiblePlain(ctA, ptB, ctC); err != nil {
+		return fmt.Errorf("cannot SubPlain: %w", err)
+	}
+
+	ringQ := eval.eval.params.RingQ()
+	for i := range ctA.Value {
+		ringQ.Sub(ctA.Value[i].Value[0], ptB.Value[i].Value, ctC.Value[i].Value[0])
+		if ctA != ctC {
+			ctC.Value[i].Value[1].Copy(ctA.Value[i].Value[1])
+		}
+	}
+
+	ctC.Pack = ctA.Pack
+	ctC.IsDiagonal = ctA.IsDiagonal
+	ctC.Layout = ctA.Layout
+
+	return
+}
+
+// checkCompatible checks that the operands cts[:len(cts)-1] have the same dimension, Pack, encoding and layout,
+// and that the output cts[len(cts)-1] has the same dimension.
+func (eval *MatrixEvaluator) checkCompatible(cts ...*MatrixCiphertext) (err error) {
+	if err = eval.checkMatrixCiphertexts(cts...); err != nil {
+		return
+	}
+
+	dim := len(cts[0].Value)
+	for _, ct := range cts[1 : len(cts)-1] {
+		if ct.Pack != cts[0].Pack || ct.IsDiagonal != cts[0].IsDiagonal || !ct.Layout.Equal(cts[0].Layout) {
+			return fmt.Errorf("%w: operands must have the same pack, encoding and layout", ErrEncodingMismatch)
+		}
+	}
+
+	if len(cts[len(cts)-1].Value) != dim {
+		return fmt.Errorf("%w: dimensions do not match", ErrEncodingMismatch)
+	}
+
+	return
+}
+
+// checkCompatiblePlain checks that ptB has the same dimension, Pack, encoding and layout as ctA,
+// and that ctC has the same dimension.
+func (eval *MatrixEvaluator) checkCompatiblePlain(ctA *MatrixCiphertext, ptB *MatrixPlaintext, ctC *MatrixCiphertext) (err error) {
+	if err = eval.checkCompatible(ctA, ctC); err != nil {
+		return
+	}
+
+	if len(ptB.Value) != len(ctA.Value) || ptB.Pack != ctA.Pack || ptB.IsDiagonal != ctA.IsDiagonal || !ptB.Layout.Equal(ctA.Layout) {
+		return fmt.Errorf("%w: operands must have the same dimension, pack, encoding and layout", ErrEncodingMismatch)
+	}
+
+	for i := range ptB.Value {
+		if ptB.Value[i] == nil {
+			return fmt.Errorf("%w: missing diagonal %d", ErrEncodingMismatch, i)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,563 @@
+package hpbfv
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"hp-bfv/ring"
+	"hp-bfv/rlwe"
+)
+
+// matrixMarshalVersion is the version of the binary encoding of the matrix containers.
+// The version 2 adds the layout and encodes the slots of the Messages as the words of their Elements.
+// The version 1, with no layout and the slots of the Messages as length-prefixed big-endian integers,
+// is still read.
+const matrixMarshalVersion = 2
+
+// Kinds of matrix containers, so that a container is never decoded as another one.
+const (
+	matrixKindMessage = iota + 1
+	matrixKindPlaintext
+	matrixKindCiphertext
+)
+
+// The binary encoding of a matrix container is the header
+//
+//	version (1 byte) | kind (1 byte) | isDiagonal (1 byte) | dim (4 bytes) | pack (4 bytes)
+//
+// followed by the layout, i.e. its number of matrices (4 bytes), 0 for no layout, and the dimension (4 bytes)
+// and offset (4 bytes) of each matrix, and by dim elements, each prefixed by its length in bytes (4 bytes).
+const matrixHeaderSize = 11
+
+// writeMatrixHeader writes the header and the layout of a matrix container on w.
+func writeMatrixHeader(w io.Writer, kind uint8, dim, pack int, isDiagonal bool, layout *MatrixLayout) (n int64, err error) {
+	var count int
+	if layout != nil {
+		count = len(layout.Matrices)
+	}
+
+	header := make([]byte, matrixHeaderSize+4+8*count)
+	header[0] = matrixMarshalVersion
+	header[1] = kind
+	if isDiagonal {
+		header[2] = 1
+	}
+	binary.BigEndian.PutUint32(header[3:], uint32(dim))
+	binary.BigEndian.PutUint32(header[7:], uint32(pack))
+	binary.BigEndian.PutUint32(header[11:], uint32(count))
+	for l := 0; l < count; l++ {
+		binary.BigEndian.PutUint32(header[15+8*l:], uint32(layout.Matrices[l].Dim))
+		binary.BigEndian.PutUint32(header[19+8*l:], uint32(layout.Matrices[l].Offset))
+	}
+
+	inc, err := w.Write(header)
+	return int64(inc), err
+}
+
+// readMatrixHeader reads the header and the layout of a matrix container of the given kind from r.
+// The dimension and the pack must be dim and pack, the ones of the target container, and the layout
+// is checked against the dim * pack slots before anything is allocated from the sizes read from r.
+func readMatrixHeader(r io.Reader, kind uint8, dim, pack int) (version uint8, isDiagonal bool, layout *MatrixLayout, n int64, err error) {
+	var header [matrixHeaderSize]byte
+	inc, err := io.ReadFull(r, header[:])
+	if n = int64(inc); err != nil {
+		return
+	}
+
+	if header[0] != 1 && header[0] != matrixMarshalVersion {
+		err = fmt.Errorf("cannot ReadFrom: unsupported version %d", header[0])
+		return
+	}
+
+	if header[1] != kind {
+		err = fmt.Errorf("cannot ReadFrom: wrong kind of matrix container")
+		return
+	}
+
+	if header[2] > 1 {
+		err = fmt.Errorf("cannot ReadFrom: invalid diagonal flag")
+		return
+	}
+
+	version = header[0]
+	isDiagonal = header[2] == 1
+
+	if int(binary.BigEndian.Uint32(header[3:])) != dim || int(binary.BigEndian.Uint32(header[7:])) != pack {
+		err = fmt.Errorf("cannot ReadFrom: %w: dim and pack must be the ones of the target", ErrEncodingMismatch)
+		return
+	}
+
+	if version == 1 {
+		return
+	}
+
+	var size [4]byte
+	inc, err = io.ReadFull(r, size[:])
+	if n += int64(inc); err != nil {
+		return
+	}
+
+	// Each matrix of a layout takes at least one slot.
+	slots := dim * pack
+	count := int(binary.BigEndian.Uint32(size[:]))
+	if count == 0 {
+		return
+	}
+	if count > slots {
+		err = fmt.Errorf("cannot ReadFrom: %w: too many matrices", ErrInvalidLayout)
+		return
+	}
+
+	matrices := make([]byte, 8*count)
+	inc, err = io.ReadFull(r, matrices)
+	if n += int64(inc); err != nil {
+		return
+	}
+
+	layout = &MatrixLayout{Matrices: make([]MatrixPlacement, count)}
+	for l := range layout.Matrices {
+		layout.Matrices[l].Dim = int(binary.BigEndian.Uint32(matrices[8*l:]))
+		layout.Matrices[l].Offset = int(binary.BigEndian.Uint32(matrices[8*l+4:]))
+	}
+
+	if err = layout.checkSlots(slots); err != nil {
+		err = fmt.Errorf("cannot ReadFrom: %w", err)
+		return
+	}
+
+	if layout.Dim() != dim {
+		err = fmt.Errorf("cannot ReadFrom: %w: the largest matrix of the layout must have dimension dim", ErrInvalidLayout)
+		return
+	}
+
+	return
+}
+
+// checkTarget checks that the target of ReadFrom, of the given name, has been allocated with a constructor,
+// since its shape bounds what is read.
+func checkTarget(name string, dim, pack int, allocated bool) (err error) {
+	if dim == 0 || pack <= 0 || !allocated {
+		return fmt.Errorf("cannot ReadFrom: the target %s must be allocated, e.g. with New%s", name, name)
+	}
+	return
+}
+
+// checkMatrixShape checks the dimension, the pack and the layout of a matrix container against params.
+func checkMatrixShape(params Parameters, dim, pack int, layout *MatrixLayout) (err error) {
+	p, err := packFor(params, dim)
+	if err != nil {
+		return err
+	}
+
+	if pack != p {
+		return fmt.Errorf("%w: pack must be Slots() / dim", ErrEncodingMismatch)
+	}
+
+	if layout != nil {
+		if err = layout.check(params); err != nil {
+			return err
+		}
+
+		if layout.Dim() != dim {
+			return fmt.Errorf("%w: the largest matrix of the layout must have dimension dim", ErrInvalidLayout)
+		}
+	}
+
+	return
+}
+
+// checkCiphertextShape checks that ct is a ciphertext of the given degree at the maximum level of params.
+func checkCiphertextShape(params Parameters, ct *rlwe.Ciphertext, degree int) (err error) {
+	if ct == nil || ct.Degree() != degree {
+		return ErrInvalidDegree
+	}
+
+	for _, p := range ct.Value {
+		if p == nil || p.N() != params.N() || p.Level() != params.MaxLevel() {
+			return fmt.Errorf("%w: the ring degree and level must be the ones of the parameters", ErrEncodingMismatch)
+		}
+	}
+
+	return
+}
+
+// writeMatrixElement writes the length-prefixed encoding of el on w.
+func writeMatrixElement(w io.Writer, el encoding.BinaryMarshaler) (n int64, err error) {
+	data, err := el.MarshalBinary()
+	if err != nil {
+		return
+	}
+
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+
+	inc, err := w.Write(size[:])
+	if n = int64(inc); err != nil {
+		return
+	}
+
+	inc, err = w.Write(data)
+	n += int64(inc)
+	return
+}
+
+// readMatrixElement reads a length-prefixed encoding from r and decodes it with decode.
+// The length must be in [minSize, maxSize], the bounds on the encoding of the element for the parameters
+// of the container.
+func readMatrixElement(r io.Reader, minSize, maxSize int, decode func(data []byte) error) (n int64, err error) {
+	var prefix [4]byte
+	inc, err := io.ReadFull(r, prefix[:])
+	if n = int64(inc); err != nil {
+		return
+	}
+
+	size := int64(binary.BigEndian.Uint32(prefix[:]))
+	if size < int64(minSize) || size > int64(maxSize) {
+		return n, fmt.Errorf("cannot ReadFrom: invalid element length")
+	}
+
+	data := make([]byte, size)
+	inc, err = io.ReadFull(r, data)
+	if n += int64(inc); err != nil {
+		return
+	}
+
+	err = decode(data)
+	return
+}
+
+// ciphertextBinarySize returns the length of the encoding of a ciphertext of the given degree, ring degree
+// and level, as written by rlwe.Ciphertext.MarshalBinary.
+func ciphertextBinarySize(degree, N, level int) int {
+	return new(rlwe.MetaData).MarshalBinarySize() + 1 + (degree+1)*(5+8*N*(level+1))
+}
+
+// checkCiphertextEncoding checks that data, of length ciphertextBinarySize(degree, N, level), encodes a ciphertext
+// of the given degree, ring degree and level, since rlwe.Ciphertext.UnmarshalBinary allocates the polynomials
+// from the degree, the ring degree and the level read from data.
+func checkCiphertextEncoding(data []byte, degree, N, level int) (err error) {
+	// The scale is a text of at most MarshalBinarySize() - 1 bytes, prefixed by its length.
+	if int(data[0]) >= (rlwe.Scale{}).MarshalBinarySize() {
+		return fmt.Errorf("cannot ReadFrom: invalid scale")
+	}
+
+	ptr := new(rlwe.MetaData).MarshalBinarySize()
+	if int(data[ptr]) != degree+1 {
+		return fmt.Errorf("cannot ReadFrom: %w", ErrInvalidDegree)
+	}
+	ptr++
+
+	for i := 0; i <= degree; i++ {
+		if int(binary.BigEndian.Uint32(data[ptr:])) != N || int(data[ptr+4]) != level {
+			return fmt.Errorf("cannot ReadFrom: the ring degree and level must be the ones of the target")
+		}
+		ptr += 5 + 8*N*(level+1)
+	}
+
+	return
+}
+
+// readCiphertexts reads dim length-prefixed ciphertexts of the given degree, ring degree and level from r,
+// decoding the i-th on el(i).
+func readCiphertexts(r io.Reader, dim, degree, N, level int, el func(i int) encoding.BinaryUnmarshaler) (n int64, err error) {
+	size := ciphertextBinarySize(degree, N, level)
+
+	var inc int64
+	for i := 0; i < dim; i++ {
+		target := el(i)
+		inc, err = readMatrixElement(r, size, size, func(data []byte) (err error) {
+			if err = checkCiphertextEncoding(data, degree, N, level); err != nil {
+				return
+			}
+			return target.UnmarshalBinary(data)
+		})
+		if n += inc; err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// marshalMatrix encodes a matrix container through its WriteTo method.
+func marshalMatrix(m io.WriterTo) (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if _, err = m.WriteTo(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// unmarshalMatrix decodes a matrix container through its ReadFrom method.
+func unmarshalMatrix(m io.ReaderFrom, data []byte) (err error) {
+	r := bytes.NewReader(data)
+	if _, err = m.ReadFrom(r); err != nil {
+		return
+	}
+
+	if r.Len() != 0 {
+		return fmt.Errorf("cannot UnmarshalBinary: remaining unparsed data")
+	}
+
+	return
+}
+
+// WriteTo writes the binary encoding of the MatrixMessage on w, one diagonal at a time.
+func (em *MatrixMessage) WriteTo(w io.Writer) (n int64, err error) {
+	if n, err = writeMatrixHeader(w, matrixKindMessage, len(em.Value), em.Pack, em.IsDiagonal, em.Layout); err != nil {
+		return
+	}
+
+	var inc int64
+	for _, msg := range em.Value {
+		inc, err = writeMatrixElement(w, msg)
+		if n += inc; err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// ReadFrom reads a MatrixMessage written by WriteTo from r, one diagonal at a time.
+// The target MatrixMessage must be allocated, e.g. with NewMatrixMessage, and the dimension, the pack
+// and the shape of the Messages read from r must be its own, so that r can be untrusted.
+// The slots are not checked to be in [0, T): see Check.
+func (em *MatrixMessage) ReadFrom(r io.Reader) (n int64, err error) {
+	if err = checkTarget("MatrixMessage", len(em.Value), em.Pack, len(em.Value) != 0 && em.Value[0] != nil && len(em.Value[0].Value) != 0); err != nil {
+		return
+	}
+
+	dim := len(em.Value)
+	slots := len(em.Value[0].Value)
+	words := len(em.Value[0].Value[0])
+
+	if slots != dim*em.Pack || words == 0 {
+		return 0, fmt.Errorf("cannot ReadFrom: %w: the target MatrixMessage must have dim * pack slots", ErrEncodingMismatch)
+	}
+
+	version, isDiagonal, layout, n, err := readMatrixHeader(r, matrixKindMessage, dim, em.Pack)
+	if err != nil {
+		return
+	}
+
+	value := make([]*Message, dim)
+
+	minSize := 8 + 8*slots*words
+	maxSize := minSize
+	if version == 1 {
+		minSize, maxSize = 4+4*slots, 4+slots*(4+8*words)
+	}
+
+	var inc int64
+	for i := range value {
+		msg := &Message{Value: newElementsWithWords(slots, words)}
+		inc, err = readMatrixElement(r, minSize, maxSize, func(data []byte) error {
+			if version == 1 {
+				return msg.unmarshalBinaryV1(data)
+			}
+			return msg.UnmarshalBinary(data)
+		})
+		value[i] = msg
+		if n += inc; err != nil {
+			return
+		}
+	}
+
+	em.Value = value
+	em.IsDiagonal = isDiagonal
+	em.Layout = layout
+
+	return
+}
+
+// MarshalBinary encodes a MatrixMessage in a byte slice.
+func (em *MatrixMessage) MarshalBinary() (data []byte, err error) {
+	return marshalMatrix(em)
+}
+
+// UnmarshalBinary decodes a previously marshaled MatrixMessage in the target MatrixMessage, see ReadFrom.
+func (em *MatrixMessage) UnmarshalBinary(data []byte) (err error) {
+	return unmarshalMatrix(em, data)
+}
+
+// Check checks the dimension, the pack, the layout and the Messages of em against params,
+// and that every slot is in [0, T). It should be called on the MatrixMessages decoded from untrusted data.
+func (em *MatrixMessage) Check(params Parameters) (err error) {
+	if err = checkMatrixShape(params, len(em.Value), em.Pack, em.Layout); err != nil {
+		return fmt.Errorf("cannot Check: %w", err)
+	}
+
+	for i, msg := range em.Value {
+		if msg == nil {
+			return fmt.Errorf("cannot Check: %w: diagonal %d is nil", ErrEncodingMismatch, i)
+		}
+
+		if err = msg.Check(params); err != nil {
+			return fmt.Errorf("cannot Check: diagonal %d: %w", i, err)
+		}
+	}
+
+	return
+}
+
+// WriteTo writes the binary encoding of the MatrixPlaintext on w, one diagonal at a time.
+func (pm *MatrixPlaintext) WriteTo(w io.Writer) (n int64, err error) {
+	if n, err = writeMatrixHeader(w, matrixKindPlaintext, len(pm.Value), pm.Pack, pm.IsDiagonal, pm.Layout); err != nil {
+		return
+	}
+
+	var inc int64
+	for _, pt := range pm.Value {
+		inc, err = writeMatrixElement(w, pt)
+		if n += inc; err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// ReadFrom reads a MatrixPlaintext written by WriteTo from r, one diagonal at a time.
+// The target MatrixPlaintext must be allocated, e.g. with NewMatrixPlaintext, and the dimension, the pack,
+// the ring degree and the level read from r must be its own, so that r can be untrusted.
+func (pm *MatrixPlaintext) ReadFrom(r io.Reader) (n int64, err error) {
+	if err = checkTarget("MatrixPlaintext", len(pm.Value), pm.Pack, len(pm.Value) != 0 && pm.Value[0] != nil && pm.Value[0].Plaintext != nil && pm.Value[0].Value != nil); err != nil {
+		return
+	}
+
+	dim := len(pm.Value)
+	N, level := pm.Value[0].Value.N(), pm.Value[0].Level()
+
+	_, isDiagonal, layout, n, err := readMatrixHeader(r, matrixKindPlaintext, dim, pm.Pack)
+	if err != nil {
+		return
+	}
+
+	value := make([]*Plaintext, dim)
+
+	inc, err := readCiphertexts(r, dim, 0, N, level, func(i int) encoding.BinaryUnmarshaler {
+		value[i] = new(Plaintext)
+		return value[i]
+	})
+	if n += inc; err != nil {
+		return
+	}
+
+	pm.Value = value
+	pm.IsDiagonal = isDiagonal
+	pm.Layout = layout
+
+	return
+}
+
+// MarshalBinary encodes a MatrixPlaintext in a byte slice.
+func (pm *MatrixPlaintext) MarshalBinary() (data []byte, err error) {
+	return marshalMatrix(pm)
+}
+
+// UnmarshalBinary decodes a previously marshaled MatrixPlaintext in the target MatrixPlaintext, see ReadFrom.
+func (pm *MatrixPlaintext) UnmarshalBinary(data []byte) (err error) {
+	return unmarshalMatrix(pm, data)
+}
+
+// Check checks the dimension, the pack, the layout and the plaintexts of pm against params.
+func (pm *MatrixPlaintext) Check(params Parameters) (err error) {
+	if err = checkMatrixShape(params, len(pm.Value), pm.Pack, pm.Layout); err != nil {
+		return fmt.Errorf("cannot Check: %w", err)
+	}
+
+	for i, pt := range pm.Value {
+		if pt == nil || pt.Plaintext == nil {
+			return fmt.Errorf("cannot Check: %w: diagonal %d is nil", ErrEncodingMismatch, i)
+		}
+
+		if err = checkCiphertextShape(params, &rlwe.Ciphertext{Value: []*ring.Poly{pt.Value}}, 0); err != nil {
+			return fmt.Errorf("cannot Check: diagonal %d: %w", i, err)
+		}
+	}
+
+	return
+}
+
+// WriteTo writes the binary encoding of the MatrixCiphertext on w, one diagonal at a time.
+func (cm *MatrixCiphertext) WriteTo(w io.Writer) (n int64, err error) {
+	if n, err = writeMatrixHeader(w, matrixKindCiphertext, len(cm.Value), cm.Pack, cm.IsDiagonal, cm.Layout); err != nil {
+		return
+	}
+
+	var inc int64
+	for _, ct := range cm.Value {
+		inc, err = writeMatrixElement(w, ct)
+		if n += inc; err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// ReadFrom reads a MatrixCiphertext written by WriteTo from r, one diagonal at a time.
+// The target MatrixCiphertext must be allocated, e.g. with NewMatrixCiphertext, and the dimension, the pack,
+// the ring degree and the level read from r must be its own, so that r can be untrusted.
+func (cm *MatrixCiphertext) ReadFrom(r io.Reader) (n int64, err error) {
+	if err = checkTarget("MatrixCiphertext", len(cm.Value), cm.Pack, len(cm.Value) != 0 && cm.Value[0] != nil && cm.Value[0].Ciphertext != nil && len(cm.Value[0].Value) != 0 && cm.Value[0].Value[0] != nil); err != nil {
+		return
+	}
+
+	dim := len(cm.Value)
+	N, level := cm.Value[0].Value[0].N(), cm.Value[0].Level()
+
+	_, isDiagonal, layout, n, err := readMatrixHeader(r, matrixKindCiphertext, dim, cm.Pack)
+	if err != nil {
+		return
+	}
+
+	value := make([]*Ciphertext, dim)
+
+	inc, err := readCiphertexts(r, dim, 1, N, level, func(i int) encoding.BinaryUnmarshaler {
+		value[i] = new(Ciphertext)
+		return value[i]
+	})
+	if n += inc; err != nil {
+		return
+	}
+
+	cm.Value = value
+	cm.IsDiagonal = isDiagonal
+	cm.Layout = layout
+
+	return
+}
+
+// MarshalBinary encodes a MatrixCiphertext in a byte slice.
+func (cm *MatrixCiphertext) MarshalBinary() (data []byte, err error) {
+	return marshalMatrix(cm)
+}
+
+// UnmarshalBinary decodes a previously marshaled MatrixCiphertext in the target MatrixCiphertext, see ReadFrom.
+func (cm *MatrixCiphertext) UnmarshalBinary(data []byte) (err error) {
+	return unmarshalMatrix(cm, data)
+}
+
+// Check checks the dimension, the pack, the layout and the ciphertexts of cm against params.
+func (cm *MatrixCiphertext) Check(params Parameters) (err error) {
+	if err = checkMatrixShape(params, len(cm.Value), cm.Pack, cm.Layout); err != nil {
+		return fmt.Errorf("cannot Check: %w", err)
+	}
+
+	for i, ct := range cm.Value {
+		if ct == nil {
+			return fmt.Errorf("cannot Check: %w: diagonal %d is nil", ErrEncodingMismatch, i)
+		}
+
+		if err = checkCiphertextShape(params, ct.Ciphertext, 1); err != nil {
+			return fmt.Errorf("cannot Check: diagonal %d: %w", i, err)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,71 @@
+package hpbfv
+
+import (
+	"fmt"
+	"sync"
+
+	"hp-bfv/rlwe/ringqp"
+)
+
+// SetWorkers sets the number of goroutines computing the output diagonals of Mul, MulPrepared and MulMany.
+// Each additional worker allocates its own buffers for the products and the key switches, and shares
+// the lifted operands, the keys and the read-only data of eval. The results are identical for any number of workers.
+// The default, 1, computes the diagonals sequentially.
+func (eval *MatrixEvaluator) SetWorkers(workers int) (err error) {
+	if workers < 1 {
+		return fmt.Errorf("cannot SetWorkers: %w: the number of workers must be positive", ErrInvalidParameters)
+	}
+
+	if workers < len(eval.workers)+1 {
+		eval.workers = eval.workers[:workers-1]
+	}
+
+	for len(eval.workers) < workers-1 {
+		eval.workers = append(eval.workers, eval.newWorker())
+	}
+
+	return
+}
+
+// Workers returns the number of goroutines computing the output diagonals of Mul, see SetWorkers.
+func (eval *MatrixEvaluator) Workers() int {
+	return len(eval.workers) + 1
+}
+
+// newWorker returns a MatrixEvaluator sharing the permutation indexes and the keys of eval,
+// with its own buffers for mulDiagonal.
+func (eval *MatrixEvaluator) newWorker() (worker *MatrixEvaluator) {
+	worker = new(MatrixEvaluator)
+	worker.eval = eval.eval.ShallowCopy()
+
+	worker.dim = eval.dim
+
+	worker.allocateDiagonalPools()
+
+	worker.permuteQIdx = eval.permuteQIdx
+	worker.permuteQMulIdx = eval.permuteQMulIdx
+
+	worker.rlk = eval.rlk
+	worker.rks = eval.rks
+
+	return
+}
+
+// mulDiagonalsParallel computes the diagonals of the product as mulDiagonals, the diagonal i being
+// computed by the worker i mod Workers().
+func (eval *MatrixEvaluator) mulDiagonalsParallel(pack, dim int, aMul, bMul [][2]*ringqp.Poly, ctC *MatrixCiphertext) {
+	workers := append([]*MatrixEvaluator{eval}, eval.workers...)
+
+	var wg sync.WaitGroup
+	wg.Add(len(workers))
+	for w := range workers {
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < dim; i += len(workers) {
+				galEl := eval.eval.params.GaloisElementForColumnRotationBy(uint64(pack * i))
+				workers[w].mulDiagonal(dim, i, galEl, aMul, bMul, ctC.Value[i])
+			}
+		}(w)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,195 @@
+package hpbfv
+
+import (
+	"fmt"
+
+	"hp-bfv/rlwe/ringqp"
+)
+
+// PreparedMatrixLeft is a MatrixCiphertext prepared as the left operand of MatrixEvaluator.MulPrepared:
+// its diagonals are extended to (Q, QMul), scaled by QMul, in the NTT and Montgomery domain.
+// It can be reused for any number of multiplications.
+type PreparedMatrixLeft struct {
+	Value [][2]*ringqp.Poly
+
+	Pack int
+}
+
+// PreparedMatrixRight is a MatrixCiphertext prepared as the right operand of MatrixEvaluator.MulPrepared:
+// its shifted diagonals are extended to (Q, QMul) in the NTT domain.
+// It can be reused for any number of multiplications.
+type PreparedMatrixRight struct {
+	Value [][2]*ringqp.Poly
+
+	Pack int
+}
+
+// newPreparedValue allocates dim pairs of (Q, QMul) polynomials.
+func newPreparedValue(params Parameters, dim int) (value [][2]*ringqp.Poly) {
+	value = make([][2]*ringqp.Poly, dim)
+	for i := range value {
+		value[i] = [2]*ringqp.Poly{NewQQMulPoly(params), NewQQMulPoly(params)}
+	}
+	return
+}
+
+// PrepareLeft lifts the diagonally packed ctA to a PreparedMatrixLeft, so that the lifting done by Mul
+// is amortized over all the multiplications by ctA. See MulPrepared.
+func (eval *MatrixEvaluator) PrepareLeft(ctA *MatrixCiphertext) (pa *PreparedMatrixLeft, err error) {
+	if err = eval.checkMatrixCiphertexts(ctA); err != nil {
+		return nil, fmt.Errorf("cannot PrepareLeft: %w", err)
+	}
+
+	if err = checkUniform(ctA); err != nil {
+		return nil, fmt.Errorf("cannot PrepareLeft: %w", err)
+	}
+
+	if !ctA.IsDiagonal {
+		return nil, fmt.Errorf("cannot PrepareLeft: %w: ctA must be diagonal", ErrEncodingMismatch)
+	}
+
+	pa = &PreparedMatrixLeft{Value: newPreparedValue(eval.eval.params, len(ctA.Value)), Pack: ctA.Pack}
+	for i := range ctA.Value {
+		eval.fillAMul(ctA.Value[i], pa.Value[i])
+	}
+
+	return
+}
+
+// PrepareRight lifts the shifted diagonally packed ctB to a PreparedMatrixRight, so that the lifting done by Mul
+// is amortized over all the multiplications by ctB. See MulPrepared.
+func (eval *MatrixEvaluator) PrepareRight(ctB *MatrixCiphertext) (pb *PreparedMatrixRight, err error) {
+	if err = eval.checkMatrixCiphertexts(ctB); err != nil {
+		return nil, fmt.Errorf("cannot PrepareRight: %w", err)
+	}
+
+	if err = checkUniform(ctB); err != nil {
+		return nil, fmt.Errorf("cannot PrepareRight: %w", err)
+	}
+
+	if ctB.IsDiagonal {
+		return nil, fmt.Errorf("cannot PrepareRight: %w: ctB must be shifted diagonal", ErrEncodingMismatch)
+	}
+
+	pb = &PreparedMatrixRight{Value: newPreparedValue(eval.eval.params, len(ctB.Value)), Pack: ctB.Pack}
+	for i := range ctB.Value {
+		eval.fillBMul(ctB.Value[i], pb.Value[i])
+	}
+
+	return
+}
+
+// MulPreparedNew multiplies the prepared matrices pa and pb and returns the result. See MulPrepared.
+func (eval *MatrixEvaluator) MulPreparedNew(pa *PreparedMatrixLeft, pb *PreparedMatrixRight) (ctC *MatrixCiphertext, err error) {
+	if ctC, err = NewMatrixCiphertext(eval.eval.params, len(pa.Value), true); err != nil {
+		return nil, fmt.Errorf("cannot MulPreparedNew: %w", err)
+	}
+
+	if err = eval.MulPrepared(pa, pb, ctC); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// MulPrepared multiplies the prepared matrices pa and pb and writes the result, packed diagonally, on ctC.
+// It computes the same product as Mul, without lifting its operands again.
+func (eval *MatrixEvaluator) MulPrepared(pa *PreparedMatrixLeft, pb *PreparedMatrixRight, ctC *MatrixCiphertext) (err error) {
+	if err = eval.checkMatrixCiphertexts(ctC); err != nil {
+		return fmt.Errorf("cannot MulPrepared: %w", err)
+	}
+
+	pack := pa.Pack
+	dim := len(pa.Value)
+	if len(pb.Value) != dim || len(ctC.Value) != dim {
+		return fmt.Errorf("cannot MulPrepared: %w: dimensions do not match", ErrEncodingMismatch)
+	}
+	if pb.Pack != pack || ctC.Pack != pack {
+		return fmt.Errorf("cannot MulPrepared: %w: packs do not match", ErrEncodingMismatch)
+	}
+
+	if err = eval.checkMulKeys(pack, dim); err != nil {
+		return fmt.Errorf("cannot MulPrepared: %w", err)
+	}
+
+	eval.mulDiagonals(pack, dim, pa.Value, pb.Value, ctC)
+
+	return
+}
+
+// MulManyNew multiplies ctA by each of the matrices ctB and returns the results. See MulMany.
+func (eval *MatrixEvaluator) MulManyNew(ctA *MatrixCiphertext, ctB []*MatrixCiphertext) (ctC []*MatrixCiphertext, err error) {
+	ctC = make([]*MatrixCiphertext, len(ctB))
+	for i := range ctC {
+		if ctC[i], err = NewMatrixCiphertext(eval.eval.params, len(ctA.Value), true); err != nil {
+			return nil, fmt.Errorf("cannot MulManyNew: %w", err)
+		}
+	}
+
+	if err = eval.MulMany(ctA, ctB, ctC); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// MulMany multiplies ctA by each of the matrices ctB and writes the results on ctC, as Mul would.
+// ctA is lifted to (Q, QMul) only once for all the products, hence ctC[k] may be ctB[k] but neither ctA nor another ctB.
+func (eval *MatrixEvaluator) MulMany(ctA *MatrixCiphertext, ctB, ctC []*MatrixCiphertext) (err error) {
+	if len(ctB) != len(ctC) {
+		return fmt.Errorf("cannot MulMany: %w: ctB and ctC must have the same length", ErrEncodingMismatch)
+	}
+
+	if err = eval.checkMatrixCiphertexts(ctA); err != nil {
+		return fmt.Errorf("cannot MulMany: %w", err)
+	}
+
+	if err = checkUniform(ctA); err != nil {
+		return fmt.Errorf("cannot MulMany: %w", err)
+	}
+
+	if !ctA.IsDiagonal {
+		return fmt.Errorf("cannot MulMany: %w: ctA must be diagonal", ErrEncodingMismatch)
+	}
+
+	pack := ctA.Pack
+	dim := len(ctA.Value)
+	for i := range ctB {
+		if err = eval.checkMatrixCiphertexts(ctB[i], ctC[i]); err != nil {
+			return fmt.Errorf("cannot MulMany: %w", err)
+		}
+
+		if err = checkUniform(ctB[i]); err != nil {
+			return fmt.Errorf("cannot MulMany: %w", err)
+		}
+
+		if ctB[i].IsDiagonal {
+			return fmt.Errorf("cannot MulMany: %w: ctB must be shifted diagonal", ErrEncodingMismatch)
+		}
+
+		if len(ctB[i].Value) != dim || len(ctC[i].Value) != dim {
+			return fmt.Errorf("cannot MulMany: %w: dimensions do not match", ErrEncodingMismatch)
+		}
+		if ctB[i].Pack != pack || ctC[i].Pack != pack {
+			return fmt.Errorf("cannot MulMany: %w: packs do not match", ErrEncodingMismatch)
+		}
+	}
+
+	if err = eval.checkMulKeys(pack, dim); err != nil {
+		return fmt.Errorf("cannot MulMany: %w", err)
+	}
+
+	aMul, bMul := eval.mulPools(dim)
+	for i := 0; i < dim; i++ {
+		eval.fillAMul(ctA.Value[i], aMul[i])
+	}
+	for k := range ctB {
+		for i := 0; i < dim; i++ {
+			eval.fillBMul(ctB[k].Value[i], bMul[i])
+		}
+
+		eval.mulDiagonals(pack, dim, aMul, bMul, ctC[k])
+	}
+
+	return
+}
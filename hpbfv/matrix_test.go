@@ -1,13 +1,19 @@
 package hpbfv_test
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"hp-bfv/hpbfv"
-	"hp-bfv/rlwe/ringqp"
+	"math"
 	"math/big"
+	"math/rand"
+	"os"
+	"runtime"
 	"testing"
 
 	"hp-bfv/ring"
+	"hp-bfv/rlwe"
 	"hp-bfv/utils"
 )
 
@@ -23,96 +29,1796 @@ var matParamSet = []hpbfv.ParametersLiteral{
 	hpbfv.HPN13D9T256,
 	hpbfv.HPN13D8T512,
 	hpbfv.HPN13D7T1024,
+
+	hpbfv.HPN14D13T128P,
+	hpbfv.HPN14D12T256P,
+	hpbfv.HPN14D11T512P,
+	hpbfv.HPN14D10T1024P,
+	hpbfv.HPN14D9T2048P,
+	hpbfv.HPN14D8T4096P,
+
+	hpbfv.HPN13D10T128P,
+	hpbfv.HPN13D9T256P,
+	hpbfv.HPN13D8T512P,
+	hpbfv.HPN13D7T1024P,
+}
+
+var mulParamSet = []hpbfv.ParametersLiteral{
+	hpbfv.HPN14D13T128,
+	hpbfv.HPN14D12T256,
+	hpbfv.HPN14D11T512,
+	hpbfv.HPN14D10T1024,
+	hpbfv.HPN14D9T2048,
+	hpbfv.HPN14D8T4096,
+
+	hpbfv.HPN13D10T128,
+	hpbfv.HPN13D9T256,
+	hpbfv.HPN13D8T512,
+	hpbfv.HPN13D7T1024,
+	hpbfv.HPN13D6T2048,
+	hpbfv.HPN13D5T4096,
+
+	hpbfv.PN15T128,
+	hpbfv.PN16T256,
+	hpbfv.PN17T512,
+	hpbfv.PN18T1024,
+}
+
+func TestMatMul(t *testing.T) {
+	dims := 2
+	tc := newMatTestContext(t, hpbfv.HPN13D10T128, dims)
+
+	pack := tc.params.Slots() / dims
+	M0 := make([][][]*big.Int, pack)
+	M1 := make([][][]*big.Int, pack)
+	MOut := make([][][]*big.Int, pack)
+	for i := 0; i < pack; i++ {
+		M0[i] = [][]*big.Int{
+			{big.NewInt(1), big.NewInt(2)},
+			{big.NewInt(3), big.NewInt(4)},
+		}
+
+		M1[i] = [][]*big.Int{
+			{big.NewInt(5), big.NewInt(6)},
+			{big.NewInt(7), big.NewInt(8)},
+		}
+
+		MOut[i] = [][]*big.Int{
+			{big.NewInt(19), big.NewInt(22)},
+			{big.NewInt(43), big.NewInt(50)},
+		}
+	}
+
+	ct0 := tc.encrypt(t, M0, true)
+	ct1 := tc.encrypt(t, M1, false)
+
+	ctOut, err := tc.eval.MulNew(ct0, ct1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.check(t, ctOut, MOut)
+
+	if _, err := tc.eval.MulNew(ct1, ct0); !errors.Is(err, hpbfv.ErrEncodingMismatch) {
+		t.Errorf("expected %v, got %v", hpbfv.ErrEncodingMismatch, err)
+	}
+
+	if _, err := hpbfv.NewMatrixCiphertext(tc.params, 3, true); !errors.Is(err, hpbfv.ErrDimNotDivisor) {
+		t.Errorf("expected %v, got %v", hpbfv.ErrDimNotDivisor, err)
+	}
+}
+
+func TestMatMulNoise(t *testing.T) {
+	// withoutP returns pl without its special modulus, so that the sets are compared on the same Q.
+	withoutP := func(pl hpbfv.ParametersLiteral) hpbfv.ParametersLiteral {
+		pl.P, pl.LogP = nil, nil
+		return pl
+	}
+
+	t.Run("KeySwitchNoise", func(t *testing.T) {
+		for _, dim := range []int{4, 32} {
+			budget := matMulNoiseBudget(t, withoutP(hpbfv.HPN13D10T128P), dim)
+			budgetP := matMulNoiseBudget(t, hpbfv.HPN13D10T128P, dim)
+
+			if budgetP <= budget {
+				t.Errorf("dim=%d: expected the special modulus P to reduce the key-switching noise, got %.1f bits with P and %.1f bits without", dim, budgetP, budget)
+			}
+		}
+	})
+
+	// On a Q of 90 bits, the key-switching noise without P exceeds Q, while the noise of the product with P,
+	// which grows with dim, still fits at dim 64.
+	t.Run("LargerDim", func(t *testing.T) {
+		pl := hpbfv.HPN13D10T128
+		pl.Q, pl.LogQ = nil, []int{60, 30}
+		pl.LogP = []int{60}
+
+		dim := 64
+
+		if budget := matMulNoiseBudget(t, withoutP(pl), dim); budget >= 1 {
+			t.Errorf("dim=%d: expected the noise budget without P to be exhausted, got %.1f bits", dim, budget)
+		}
+
+		if budget := matMulNoiseBudget(t, pl, dim); budget < 1 {
+			t.Errorf("dim=%d: expected the special modulus P to leave a noise budget, got %.1f bits", dim, budget)
+		}
+	})
+}
+
+// matMulNoiseBudget returns the smallest noise budget of the diagonals of the product of two encrypted
+// matrices of dimension dim under pl. If the budget is not exhausted, it also checks the product.
+func matMulNoiseBudget(t *testing.T, pl hpbfv.ParametersLiteral, dim int) (budget float64) {
+	tc := newMatTestContext(t, pl, dim)
+	params := tc.params
+	dec := hpbfv.NewDecryptor(params, tc.sk)
+
+	M := sampleMatrices(params, params.Slots()/dim, dim, dim)
+
+	ctA := tc.encrypt(t, M, true)
+	ctB := tc.encrypt(t, M, false)
+
+	ctC, err := tc.eval.MulNew(ctA, ctB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	budget = dec.NoiseBudget(ctC.Value[0])
+	for i := range ctC.Value {
+		if b := dec.NoiseBudget(ctC.Value[i]); b < budget {
+			budget = b
+		}
+	}
+
+	t.Logf("LogN=%d/logQ=%d/#P=%d/dim=%d: fresh=%.1f bits, mul=%.1f bits", params.LogN(), params.LogQ(), params.PCount(), dim, dec.NoiseBudget(ctA.Value[0]), budget)
+
+	if budget < 1 {
+		return
+	}
+
+	tc.check(t, ctC, mulMatrices(M, M, params.T()))
+
+	return
+}
+
+func TestMatMulRect(t *testing.T) {
+	tc := newMatTestContext(t, hpbfv.HPN13D10T128, 0)
+	params, ecd, enc := tc.params, tc.ecd, tc.enc
+
+	rows, inner, cols := 3, 5, 2
+	count := 4
+
+	MA := sampleMatrices(params, count, rows, inner)
+	MB := sampleMatrices(params, count, inner, cols)
+
+	dim, err := ecd.MatMulDim(rows, inner, cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.genRotationKeysForMatMul(t, dim)
+
+	ptA, err := ecd.EncodeRectMatrixNew(MA, dim, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ptB, err := ecd.EncodeRectMatrixNew(MB, dim, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctA, err := enc.EncryptNew(ptA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctB, err := enc.EncryptNew(ptB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctC, err := tc.eval.MulNew(ctA, ctB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ptC, err := enc.DecryptNew(ctC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	MC, err := ecd.DecodeRectMatrixNew(ptC, rows, cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(MC) != params.Slots()/dim {
+		t.Fatalf("expected %d matrices, got %d", params.Slots()/dim, len(MC))
+	}
+
+	// the matrices beyond count are zero
+	want := mulMatrices(MA, MB, params.T())
+	for len(want) < len(MC) {
+		zero := make([][]*big.Int, rows)
+		for i := range zero {
+			zero[i] = make([]*big.Int, cols)
+			for j := range zero[i] {
+				zero[i][j] = new(big.Int)
+			}
+		}
+		want = append(want, zero)
+	}
+	compareMatrices(t, MC, want)
+
+	if _, err := ecd.EncodeRectMatrixNew(MA, 2, true); !errors.Is(err, hpbfv.ErrEncodingMismatch) {
+		t.Errorf("expected %v, got %v", hpbfv.ErrEncodingMismatch, err)
+	}
+}
+
+func TestMatMulBlock(t *testing.T) {
+	tileDim := 4
+	tc := newMatTestContext(t, hpbfv.HPN13D10T128, tileDim)
+	params, ecd, enc, eval := tc.params, tc.ecd, tc.enc, tc.eval
+
+	rows, inner, cols := 10, 7, 9
+	count := 2
+
+	MA := sampleMatrices(params, count, rows, inner)
+	MB := sampleMatrices(params, count, inner, cols)
+
+	ptA, err := ecd.EncodeBlockMatrixNew(MA, tileDim, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ptB, err := ecd.EncodeBlockMatrixNew(MB, tileDim, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctA, err := enc.EncryptBlockNew(ptA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctB, err := enc.EncryptBlockNew(ptB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctC, err := eval.MulBlockNew(ctA, ctB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ptC, err := enc.DecryptBlockNew(ctC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	MC, err := ecd.DecodeBlockMatrixNew(ptC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareMatrices(t, MC, mulMatrices(MA, MB, params.T()))
+
+	if _, err := eval.MulBlockNew(ctB, ctB); !errors.Is(err, hpbfv.ErrEncodingMismatch) {
+		t.Errorf("expected %v, got %v", hpbfv.ErrEncodingMismatch, err)
+	}
+}
+
+func TestMatMulPlain(t *testing.T) {
+	dim := 4
+	tc := newMatTestContext(t, hpbfv.HPN13D10T128, dim)
+	params, ecd, rks := tc.params, tc.ecd, tc.rks
+	pack := params.Slots() / dim
+
+	MA := sampleMatrices(params, pack, dim, dim)
+	MB := sampleMatrices(params, pack, dim, dim)
+
+	MC := mulMatrices(MA, MB, params.T())
+
+	t.Run("MulPlain", func(t *testing.T) {
+		ptA, err := ecd.EncodeMatrixMulNew(MA, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctB := tc.encrypt(t, MB, false)
+
+		eval := hpbfv.NewMatrixEvaluator(params, nil, rks)
+		ctC, err := eval.MulPlainNew(ptA, ctB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tc.check(t, ctC, MC)
+
+		eval = hpbfv.NewMatrixEvaluator(params, nil, nil)
+		if _, err := eval.MulPlainNew(ptA, ctB); !errors.Is(err, hpbfv.ErrMissingRotationKey) {
+			t.Errorf("expected %v, got %v", hpbfv.ErrMissingRotationKey, err)
+		}
+	})
+
+	t.Run("MulPlainRight", func(t *testing.T) {
+		ctA := tc.encrypt(t, MA, true)
+		ptB, err := ecd.EncodeMatrixMulNew(MB, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		eval := hpbfv.NewMatrixEvaluator(params, nil, nil)
+		ctC, err := eval.MulPlainRightNew(ctA, ptB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tc.check(t, ctC, MC)
+
+		ptA, err := ecd.EncodeMatrixMulNew(MA, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := eval.MulPlainRightNew(ctA, ptA); !errors.Is(err, hpbfv.ErrEncodingMismatch) {
+			t.Errorf("expected %v, got %v", hpbfv.ErrEncodingMismatch, err)
+		}
+	})
+}
+
+func TestMatMulBSGS(t *testing.T) {
+	tc := newMatTestContext(t, hpbfv.HPN13D10T128, 0)
+	params, kg, sk, rlk := tc.params, tc.kg, tc.sk, tc.rlk
+
+	dim := 8
+	pack := params.Slots() / dim
+
+	MA := sampleMatrices(params, pack, dim, dim)
+	MB := sampleMatrices(params, pack, dim, dim)
+
+	MC := mulMatrices(MA, MB, params.T())
+
+	ctA := tc.encrypt(t, MA, true)
+	ctB := tc.encrypt(t, MB, false)
+
+	if hpbfv.DefaultBabySteps(dim) != 3 {
+		t.Fatalf("expected 3 baby steps, got %d", hpbfv.DefaultBabySteps(dim))
+	}
+
+	for _, babySteps := range []int{1, 2, hpbfv.DefaultBabySteps(dim), dim} {
+		t.Run(fmt.Sprintf("BabySteps=%d", babySteps), func(t *testing.T) {
+			rks, err := kg.GenRotationKeysForMatMulBSGS(sk, dim, babySteps)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if nKeys := babySteps + (dim+babySteps-1)/babySteps - 1; len(rks.Keys) != nKeys {
+				t.Fatalf("expected %d rotation keys, got %d", nKeys, len(rks.Keys))
+			}
+
+			eval := hpbfv.NewMatrixEvaluator(params, rlk, rks)
+			ctC, err := eval.MulBSGSNew(ctA, ctB, babySteps)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.check(t, ctC, MC)
+		})
+	}
+
+	rks, err := kg.GenRotationKeysForMatMulBSGS(sk, dim, hpbfv.DefaultBabySteps(dim))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eval := hpbfv.NewMatrixEvaluator(params, rlk, rks)
+
+	t.Run("ReuseLayoutCiphertext", func(t *testing.T) {
+		// ctC has the dim and pack of the product but the layout of a previous product
+		layout, err := hpbfv.NewMatrixLayout(params, dim, dim/2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctC, err := hpbfv.NewMatrixCiphertextWithLayout(params, layout, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = eval.MulBSGS(ctA, ctB, ctC, hpbfv.DefaultBabySteps(dim)); err != nil {
+			t.Fatal(err)
+		}
+		if ctC.Layout != nil || ctC.Pack != pack || !ctC.IsDiagonal {
+			t.Fatalf("wrong encoding of the product")
+		}
+		tc.check(t, ctC, MC)
+	})
+
+	if _, err := eval.MulBSGSNew(ctA, ctB, 1); !errors.Is(err, hpbfv.ErrMissingRotationKey) {
+		t.Errorf("expected %v, got %v", hpbfv.ErrMissingRotationKey, err)
+	}
+	if _, err := eval.MulBSGSNew(ctA, ctB, dim+1); !errors.Is(err, hpbfv.ErrInvalidParameters) {
+		t.Errorf("expected %v, got %v", hpbfv.ErrInvalidParameters, err)
+	}
+	if _, err := kg.GenRotationKeysForMatMulBSGS(sk, dim, 0); !errors.Is(err, hpbfv.ErrInvalidParameters) {
+		t.Errorf("expected %v, got %v", hpbfv.ErrInvalidParameters, err)
+	}
+}
+
+func TestMatMulPrepared(t *testing.T) {
+	dim := 4
+	tc := newMatTestContext(t, hpbfv.HPN13D10T128, dim)
+	params, eval := tc.params, tc.eval
+	pack := params.Slots() / dim
+	count := 3
+
+	MA := sampleMatrices(params, pack, dim, dim)
+	ctA := tc.encrypt(t, MA, true)
+
+	MB := make([][][][]*big.Int, count)
+	ctB := make([]*hpbfv.MatrixCiphertext, count)
+	for k := range MB {
+		MB[k] = sampleMatrices(params, pack, dim, dim)
+		ctB[k] = tc.encrypt(t, MB[k], false)
+	}
+
+	t.Run("MulPrepared", func(t *testing.T) {
+		pa, err := eval.PrepareLeft(ctA)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for k := range ctB {
+			pb, err := eval.PrepareRight(ctB[k])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctC, err := eval.MulPreparedNew(pa, pb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.check(t, ctC, mulMatrices(MA, MB[k], params.T()))
+		}
+	})
+
+	t.Run("MulMany", func(t *testing.T) {
+		ctC, err := eval.MulManyNew(ctA, ctB)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for k := range ctC {
+			tc.check(t, ctC[k], mulMatrices(MA, MB[k], params.T()))
+		}
+	})
+
+	if _, err := eval.PrepareLeft(ctB[0]); !errors.Is(err, hpbfv.ErrEncodingMismatch) {
+		t.Errorf("expected %v, got %v", hpbfv.ErrEncodingMismatch, err)
+	}
+	if _, err := eval.PrepareRight(ctA); !errors.Is(err, hpbfv.ErrEncodingMismatch) {
+		t.Errorf("expected %v, got %v", hpbfv.ErrEncodingMismatch, err)
+	}
+	if _, err := eval.MulManyNew(ctA, []*hpbfv.MatrixCiphertext{ctB[0], ctA}); !errors.Is(err, hpbfv.ErrEncodingMismatch) {
+		t.Errorf("expected %v, got %v", hpbfv.ErrEncodingMismatch, err)
+	}
+}
+
+func TestMatMulParallel(t *testing.T) {
+	dim := 8
+	tc := newMatTestContext(t, hpbfv.HPN13D10T128, dim)
+	params, eval := tc.params, tc.eval
+	pack := params.Slots() / dim
+
+	ctA := tc.encrypt(t, sampleMatrices(params, pack, dim, dim), true)
+	ctB := tc.encrypt(t, sampleMatrices(params, pack, dim, dim), false)
+
+	ctWant, err := eval.MulNew(ctA, ctB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, workers := range []int{2, 3, dim, 2 * dim, 1} {
+		t.Run(fmt.Sprintf("Workers=%d", workers), func(t *testing.T) {
+			if err := eval.SetWorkers(workers); err != nil {
+				t.Fatal(err)
+			}
+
+			if eval.Workers() != workers {
+				t.Fatalf("expected %d workers, got %d", workers, eval.Workers())
+			}
+
+			ctC, err := eval.MulNew(ctA, ctB)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := range ctC.Value {
+				for j := range ctC.Value[i].Value {
+					if !ctC.Value[i].Value[j].Equals(ctWant.Value[i].Value[j]) {
+						t.Fatalf("diagonal %d differs from the sequential product", i)
+					}
+				}
+			}
+		})
+	}
+
+	if err := eval.SetWorkers(0); !errors.Is(err, hpbfv.ErrInvalidParameters) {
+		t.Errorf("expected %v, got %v", hpbfv.ErrInvalidParameters, err)
+	}
+}
+
+func TestMatShallowCopy(t *testing.T) {
+	dim := 4
+	tc := newMatTestContext(t, hpbfv.HPN13D10T128, dim)
+	params, ecd, enc, eval := tc.params, tc.ecd, tc.enc, tc.eval
+	pack := params.Slots() / dim
+	goroutines := 3
+
+	MA := make([][][][]*big.Int, goroutines)
+	MB := make([][][][]*big.Int, goroutines)
+	for g := range MA {
+		MA[g] = sampleMatrices(params, pack, dim, dim)
+		MB[g] = sampleMatrices(params, pack, dim, dim)
+	}
+
+	errs := make(chan error, goroutines)
+	MC := make([][][][]*big.Int, goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func(g int, ecd *hpbfv.MatrixEncoder, enc *hpbfv.MatrixEncryptor, eval *hpbfv.MatrixEvaluator) {
+			errs <- func() (err error) {
+				ctA, err := encryptMatrices(ecd, enc, MA[g], true)
+				if err != nil {
+					return
+				}
+				ctB, err := encryptMatrices(ecd, enc, MB[g], false)
+				if err != nil {
+					return
+				}
+				ctC, err := eval.MulNew(ctA, ctB)
+				if err != nil {
+					return
+				}
+				MC[g], err = decryptMatrices(ecd, enc, ctC)
+				return
+			}()
+		}(g, ecd.ShallowCopy(), enc.ShallowCopy(), eval.ShallowCopy())
+	}
+
+	for g := 0; g < goroutines; g++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for g := range MC {
+		t.Run(fmt.Sprintf("Goroutine=%d", g), func(t *testing.T) {
+			compareMatrices(t, MC[g], mulMatrices(MA[g], MB[g], params.T()))
+		})
+	}
+}
+
+func TestMatMulWindowed(t *testing.T) {
+	dim := 8
+	tc := newMatTestContext(t, hpbfv.HPN13D10T128, dim)
+	params, rlk, rks := tc.params, tc.rlk, tc.rks
+	pack := params.Slots() / dim
+
+	eval, err := hpbfv.NewMatrixEvaluatorForDim(params, dim, rlk, rks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctA := tc.encrypt(t, sampleMatrices(params, pack, dim, dim), true)
+	ctB := tc.encrypt(t, sampleMatrices(params, pack, dim, dim), false)
+
+	ctWant, err := tc.eval.MulNew(ctA, ctB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(t *testing.T, ctC *hpbfv.MatrixCiphertext) {
+		for i := range ctC.Value {
+			for j := range ctC.Value[i].Value {
+				if !ctC.Value[i].Value[j].Equals(ctWant.Value[i].Value[j]) {
+					t.Fatalf("diagonal %d differs from the product of Mul", i)
+				}
+			}
+		}
+	}
+
+	t.Run("ForDim", func(t *testing.T) {
+		ctC, err := eval.MulNew(ctA, ctB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, ctC)
+	})
+
+	for _, window := range []int{1, 3, dim, 2 * dim} {
+		t.Run(fmt.Sprintf("Window=%d", window), func(t *testing.T) {
+			ctC, err := eval.MulWindowedNew(ctA, ctB, window)
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t, ctC)
+		})
+	}
+
+	if _, err := eval.MulWindowedNew(ctA, ctB, 0); !errors.Is(err, hpbfv.ErrInvalidParameters) {
+		t.Errorf("expected %v, got %v", hpbfv.ErrInvalidParameters, err)
+	}
+	if err := eval.MulWindowed(ctA, ctB, ctB, 1); !errors.Is(err, hpbfv.ErrEncodingMismatch) {
+		t.Errorf("expected %v, got %v", hpbfv.ErrEncodingMismatch, err)
+	}
+
+	ctOther, err := hpbfv.NewMatrixCiphertext(params, 2*dim, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := eval.AddNew(ctOther, ctOther); !errors.Is(err, hpbfv.ErrEncodingMismatch) {
+		t.Errorf("expected %v, got %v", hpbfv.ErrEncodingMismatch, err)
+	}
+	if _, err := hpbfv.NewMatrixEvaluatorForDim(params, 3, rlk, rks); !errors.Is(err, hpbfv.ErrDimNotDivisor) {
+		t.Errorf("expected %v, got %v", hpbfv.ErrDimNotDivisor, err)
+	}
+}
+
+func TestMatMulVector(t *testing.T) {
+	dim := 4
+	tc := newMatTestContext(t, hpbfv.HPN13D10T128, dim)
+	params, ecd, enc, rlk, rks := tc.params, tc.ecd, tc.enc, tc.rlk, tc.rks
+	pack := params.Slots() / dim
+
+	M := make([][][]*big.Int, pack)
+	V := make([][]*big.Int, pack)
+	W := make([][]*big.Int, pack)
+	for l := 0; l < pack; l++ {
+		M[l] = make([][]*big.Int, dim)
+		V[l] = make([]*big.Int, dim)
+		for i := 0; i < dim; i++ {
+			M[l][i] = make([]*big.Int, dim)
+			for j := 0; j < dim; j++ {
+				M[l][i][j] = ring.RandInt(params.T())
+			}
+			V[l][i] = ring.RandInt(params.T())
+		}
+
+		W[l] = make([]*big.Int, dim)
+		for i := 0; i < dim; i++ {
+			W[l][i] = big.NewInt(0)
+			for k := 0; k < dim; k++ {
+				W[l][i].Add(W[l][i], new(big.Int).Mul(M[l][i][k], V[l][k]))
+			}
+			W[l][i].Mod(W[l][i], params.T())
+		}
+	}
+
+	ptV, err := ecd.EncodeVectorNew(V)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctV, err := enc.EncryptVectorNew(ptV)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("InvalidPack", func(t *testing.T) {
+		for _, pack := range []int{0, -1, params.Slots() + 1} {
+			pv := &hpbfv.VectorPlaintext{Value: ptV.Value, Pack: pack}
+			if _, err := ecd.DecodeVectorNew(pv); !errors.Is(err, hpbfv.ErrEncodingMismatch) {
+				t.Errorf("DecodeVectorNew pack=%d: expected %v, got %v", pack, hpbfv.ErrEncodingMismatch, err)
+			}
+			if _, err := enc.EncryptVectorNew(pv); !errors.Is(err, hpbfv.ErrEncodingMismatch) {
+				t.Errorf("EncryptVectorNew pack=%d: expected %v, got %v", pack, hpbfv.ErrEncodingMismatch, err)
+			}
+		}
+	})
+
+	checkProduct := func(t *testing.T, ctW *hpbfv.VectorCiphertext) {
+		ptW, err := enc.DecryptVectorNew(ctW)
+		if err != nil {
+			t.Fatal(err)
+		}
+		WTest, err := ecd.DecodeVectorNew(ptW)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for l := 0; l < pack; l++ {
+			for i := 0; i < dim; i++ {
+				if WTest[l][i].Cmp(W[l][i]) != 0 {
+					t.Fatalf("vector %d entry %d: expected %v, got %v", l, i, W[l][i], WTest[l][i])
+				}
+			}
+		}
+	}
+
+	t.Run("MulVector", func(t *testing.T) {
+		ctM := tc.encrypt(t, M, true)
+
+		eval := hpbfv.NewMatrixEvaluator(params, rlk, rks)
+		ctW, err := eval.MulVectorNew(ctM, ctV)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkProduct(t, ctW)
+
+		eval = hpbfv.NewMatrixEvaluator(params, nil, rks)
+		if _, err := eval.MulVectorNew(ctM, ctV); !errors.Is(err, hpbfv.ErrMissingRelinearizationKey) {
+			t.Errorf("expected %v, got %v", hpbfv.ErrMissingRelinearizationKey, err)
+		}
+
+		ctMShifted := tc.encrypt(t, M, false)
+		if _, err := eval.MulVectorNew(ctMShifted, ctV); !errors.Is(err, hpbfv.ErrEncodingMismatch) {
+			t.Errorf("expected %v, got %v", hpbfv.ErrEncodingMismatch, err)
+		}
+	})
+
+	t.Run("MulVectorPlain", func(t *testing.T) {
+		ptM, err := ecd.EncodeMatrixMulNew(M, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		eval := hpbfv.NewMatrixEvaluator(params, nil, rks)
+		ctW, err := eval.MulVectorPlainNew(ptM, ctV)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkProduct(t, ctW)
+
+		// ctV can be overwritten by the product
+		ctW = &hpbfv.VectorCiphertext{Value: ctV.Value.CopyNew(), Pack: ctV.Pack}
+		if err := eval.MulVectorPlain(ptM, ctW, ctW); err != nil {
+			t.Fatal(err)
+		}
+		checkProduct(t, ctW)
+	})
+}
+
+func TestMatReencode(t *testing.T) {
+	dim := 4
+	tc := newMatTestContext(t, hpbfv.HPN13D10T128, dim)
+	params, eval := tc.params, tc.eval
+	pack := params.Slots() / dim
+
+	MA := sampleMatrices(params, pack, dim, dim)
+	MB := sampleMatrices(params, pack, dim, dim)
+	MC := sampleMatrices(params, pack, dim, dim)
+
+	t.Run("Reencode", func(t *testing.T) {
+		ct := tc.encrypt(t, MA, true)
+
+		ctShifted, err := eval.ReencodeNew(ct, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ctShifted.IsDiagonal {
+			t.Fatal("expected a shifted-diagonal encoding")
+		}
+		tc.check(t, ctShifted, MA)
+
+		if err = eval.Reencode(ctShifted, true, ctShifted); err != nil {
+			t.Fatal(err)
+		}
+		if !ctShifted.IsDiagonal {
+			t.Fatal("expected a diagonal encoding")
+		}
+		tc.check(t, ctShifted, MA)
+	})
+
+	t.Run("MulChain", func(t *testing.T) {
+		ctA := tc.encrypt(t, MA, true)
+		ctB := tc.encrypt(t, MB, true)
+		ctC := tc.encrypt(t, MC, false)
+
+		ctOut, err := eval.MulChainNew(ctA, ctB, ctC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tc.check(t, ctOut, mulMatrices(mulMatrices(MA, MB, params.T()), MC, params.T()))
+	})
+}
+
+func TestMatTranspose(t *testing.T) {
+	dim := 4
+	tc := newMatTestContext(t, hpbfv.HPN13D10T128, 0)
+	params := tc.params
+	pack := params.Slots() / dim
+
+	MA := sampleMatrices(params, pack, dim, dim)
+	MB := sampleMatrices(params, pack, dim, dim)
+
+	rksT, err := tc.kg.GenRotationKeysForTranspose(tc.sk, dim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evalT := hpbfv.NewMatrixEvaluator(params, nil, rksT)
+
+	for _, isDiagonal := range []bool{true, false} {
+		t.Run(fmt.Sprintf("Transpose/IsDiagonal=%v", isDiagonal), func(t *testing.T) {
+			ct := tc.encrypt(t, MA, isDiagonal)
+
+			ctT, err := evalT.TransposeNew(ct)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// transposing in place twice gives back the input
+			ct2 := &hpbfv.MatrixCiphertext{Value: make([]*hpbfv.Ciphertext, dim), Pack: ctT.Pack, IsDiagonal: ctT.IsDiagonal}
+			for i := range ct2.Value {
+				ct2.Value[i] = ctT.Value[i].CopyNew()
+			}
+			if err = evalT.Transpose(ct2, ct2); err != nil {
+				t.Fatal(err)
+			}
+
+			tc.check(t, ctT, transposeMatrices(MA))
+			tc.check(t, ct2, MA)
+		})
+	}
+
+	t.Run("TransposeMul", func(t *testing.T) {
+		tc.genRotationKeysForMatMul(t, dim)
+
+		ctA := tc.encrypt(t, MA, true)
+		ctB := tc.encrypt(t, MB, false)
+
+		if err = tc.eval.Transpose(ctA, ctA); err != nil {
+			t.Fatal(err)
+		}
+
+		ctC, err := tc.eval.MulNew(ctA, ctB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tc.check(t, ctC, mulMatrices(transposeMatrices(MA), MB, params.T()))
+	})
+}
+
+func TestMatLinear(t *testing.T) {
+	dim := 4
+	tc := newMatTestContext(t, hpbfv.HPN13D10T128, 0)
+	params, ecd, eval := tc.params, tc.ecd, tc.eval
+	pack := params.Slots() / dim
+	T := params.T()
+
+	MA := sampleMatrices(params, pack, dim, dim)
+	MB := sampleMatrices(params, pack, dim, dim)
+
+	ctA := tc.encrypt(t, MA, true)
+	ctB := tc.encrypt(t, MB, true)
+	ptB, err := ecd.EncodeMatrixNew(MB, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// check checks that ct decrypts to f(a, b) mod T entrywise.
+	check := func(t *testing.T, ct *hpbfv.MatrixCiphertext, f func(a, b *big.Int) *big.Int) {
+		tc.check(t, ct, mapMatrices(MA, MB, T, f))
+	}
+
+	t.Run("Add", func(t *testing.T) {
+		ct, err := eval.AddNew(ctA, ctB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, ct, func(a, b *big.Int) *big.Int { return new(big.Int).Add(a, b) })
+	})
+
+	t.Run("Sub", func(t *testing.T) {
+		ct, err := eval.SubNew(ctA, ctB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, ct, func(a, b *big.Int) *big.Int { return new(big.Int).Sub(a, b) })
+	})
+
+	t.Run("Neg", func(t *testing.T) {
+		ct, err := eval.NegNew(ctA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, ct, func(a, b *big.Int) *big.Int { return new(big.Int).Neg(a) })
+	})
+
+	t.Run("MulScalar", func(t *testing.T) {
+		for _, c := range []*big.Int{ring.RandInt(T), big.NewInt(-3)} {
+			ct, err := eval.MulScalarNew(ctA, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t, ct, func(a, b *big.Int) *big.Int { return new(big.Int).Mul(a, c) })
+		}
+	})
+
+	t.Run("AddPlain", func(t *testing.T) {
+		ct, err := eval.AddPlainNew(ctA, ptB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, ct, func(a, b *big.Int) *big.Int { return new(big.Int).Add(a, b) })
+	})
+
+	t.Run("SubPlain", func(t *testing.T) {
+		ct, err := eval.SubPlainNew(ctA, ptB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, ct, func(a, b *big.Int) *big.Int { return new(big.Int).Sub(a, b) })
+	})
+
+	t.Run("EncodingMismatch", func(t *testing.T) {
+		ctShifted := tc.encrypt(t, MB, false)
+
+		if _, err := eval.AddNew(ctA, ctShifted); !errors.Is(err, hpbfv.ErrEncodingMismatch) {
+			t.Errorf("expected %v, got %v", hpbfv.ErrEncodingMismatch, err)
+		}
+
+		ptShifted, err := ecd.EncodeMatrixNew(MB, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := eval.AddPlainNew(ctA, ptShifted); !errors.Is(err, hpbfv.ErrEncodingMismatch) {
+			t.Errorf("expected %v, got %v", hpbfv.ErrEncodingMismatch, err)
+		}
+	})
+}
+
+func TestMatAuth(t *testing.T) {
+	tc := newMatTestContext(t, hpbfv.HPN13D10T128, 0)
+	params := tc.params
+
+	dims := 2
+	pack := params.Slots() / dims
+	alpha := big.NewInt(3)
+	M := make([][][]*big.Int, pack)
+	for i := 0; i < pack; i++ {
+		M[i] = [][]*big.Int{
+			{big.NewInt(1), big.NewInt(2)},
+			{big.NewInt(3), big.NewInt(4)},
+		}
+	}
+
+	MMac := mapMatrices(M, M, params.T(), func(a, _ *big.Int) *big.Int { return new(big.Int).Mul(alpha, a) })
+
+	msgAlpha := hpbfv.NewMessage(params)
+	for i := range msgAlpha.Value {
+		hpbfv.NewZT(params).SetBigInt(alpha, msgAlpha.Value[i])
+	}
+	ctAlpha := hpbfv.NewEncryptor(params, tc.pk).EncryptMsgNew(msgAlpha)
+
+	for _, isDiagonal := range []bool{true, false} {
+		ct := tc.encrypt(t, M, isDiagonal)
+
+		ctMacs, err := tc.eval.AuthenticateNew(ctAlpha, ct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctMac := ctMacs[0]
+
+		if ctMac.IsDiagonal != isDiagonal || ctMac.Pack != pack {
+			t.Fatalf("wrong encoding of the MAC")
+		}
+		tc.check(t, ctMac, MMac)
+	}
+}
+
+func TestMatTyped(t *testing.T) {
+	dim := 4
+	tc := newMatTestContext(t, hpbfv.HPN13D10T128, dim)
+	params, ecd, enc := tc.params, tc.ecd, tc.enc
+	pack := params.Slots() / dim
+	r := rand.New(rand.NewSource(0))
+
+	newInt64 := func(f func(l, i, j int) int64) (matrices [][][]int64) {
+		matrices = make([][][]int64, pack)
+		for l := range matrices {
+			matrices[l] = make([][]int64, dim)
+			for i := range matrices[l] {
+				matrices[l][i] = make([]int64, dim)
+				for j := range matrices[l][i] {
+					matrices[l][i][j] = f(l, i, j)
+				}
+			}
+		}
+		return
+	}
+
+	eval, err := hpbfv.NewMatrixEvaluatorForDim(params, dim, tc.rlk, tc.rks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mul := func(t *testing.T, ptA, ptB *hpbfv.MatrixPlaintext) *hpbfv.MatrixPlaintext {
+		ctA, err := enc.EncryptNew(ptA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctB, err := enc.EncryptNew(ptB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctC, err := eval.MulNew(ctA, ctB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ptC, err := enc.DecryptNew(ctC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ptC
+	}
+
+	t.Run("Int64/RoundTrip", func(t *testing.T) {
+		extremes := []int64{math.MinInt64, math.MaxInt64, -1, 0}
+		M := newInt64(func(l, i, j int) int64 { return extremes[(l+i+j)%len(extremes)] })
+
+		for _, isDiagonal := range []bool{true, false} {
+			pt, err := ecd.EncodeMatrixInt64New(M, isDiagonal)
+			if err != nil {
+				t.Fatal(err)
+			}
+			MOut, err := ecd.DecodeMatrixInt64New(pt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for l := range M {
+				for i := range M[l] {
+					for j := range M[l][i] {
+						if MOut[l][i][j] != M[l][i][j] {
+							t.Fatalf("expected %d, got %d", M[l][i][j], MOut[l][i][j])
+						}
+					}
+				}
+			}
+
+			if _, err = ecd.DecodeMatrixUint64New(pt); !errors.Is(err, hpbfv.ErrOverflow) {
+				t.Fatalf("expected ErrOverflow decoding negative values to uint64, got %v", err)
+			}
+		}
+	})
+
+	t.Run("Uint64/RoundTrip", func(t *testing.T) {
+		M := make([][][]uint64, pack)
+		for l := range M {
+			M[l] = make([][]uint64, dim)
+			for i := range M[l] {
+				M[l][i] = make([]uint64, dim)
+				for j := range M[l][i] {
+					M[l][i][j] = math.MaxUint64 - uint64(l+i+j)
+				}
+			}
+		}
+
+		pt, err := ecd.EncodeMatrixUint64New(M, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		MOut, err := ecd.DecodeMatrixUint64New(pt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for l := range M {
+			for i := range M[l] {
+				for j := range M[l][i] {
+					if MOut[l][i][j] != M[l][i][j] {
+						t.Fatalf("expected %d, got %d", M[l][i][j], MOut[l][i][j])
+					}
+				}
+			}
+		}
+
+		if _, err = ecd.DecodeMatrixInt64New(pt); !errors.Is(err, hpbfv.ErrOverflow) {
+			t.Fatalf("expected ErrOverflow decoding values above MaxInt64 to int64, got %v", err)
+		}
+	})
+
+	t.Run("Int64/Mul", func(t *testing.T) {
+		MA := newInt64(func(l, i, j int) int64 { return r.Int63n(2001) - 1000 })
+		MB := newInt64(func(l, i, j int) int64 { return r.Int63n(2001) - 1000 })
+
+		ptA, err := ecd.EncodeMatrixInt64New(MA, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ptB, err := ecd.EncodeMatrixInt64New(MB, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		MC, err := ecd.DecodeMatrixInt64New(mul(t, ptA, ptB))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for l := 0; l < pack; l++ {
+			for i := 0; i < dim; i++ {
+				for j := 0; j < dim; j++ {
+					var want int64
+					for k := 0; k < dim; k++ {
+						want += MA[l][i][k] * MB[l][k][j]
+					}
+					if MC[l][i][j] != want {
+						t.Fatalf("expected %d, got %d", want, MC[l][i][j])
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("Int64/Overflow", func(t *testing.T) {
+		ptA, err := ecd.EncodeMatrixInt64New(newInt64(func(l, i, j int) int64 { return math.MaxInt64 }), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ptB, err := ecd.EncodeMatrixInt64New(newInt64(func(l, i, j int) int64 { return -2 }), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = ecd.DecodeMatrixInt64New(mul(t, ptA, ptB)); !errors.Is(err, hpbfv.ErrOverflow) {
+			t.Fatalf("expected ErrOverflow, got %v", err)
+		}
+	})
+
+	t.Run("Float64/Mul", func(t *testing.T) {
+		scale := float64(1 << 20)
+
+		newFloat64 := func() (matrices [][][]float64) {
+			matrices = make([][][]float64, pack)
+			for l := range matrices {
+				matrices[l] = make([][]float64, dim)
+				for i := range matrices[l] {
+					matrices[l][i] = make([]float64, dim)
+					for j := range matrices[l][i] {
+						matrices[l][i][j] = 2*r.Float64() - 1
+					}
+				}
+			}
+			return
+		}
+
+		MA, MB := newFloat64(), newFloat64()
+
+		ptA, err := ecd.EncodeMatrixFloat64New(MA, scale, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ptB, err := ecd.EncodeMatrixFloat64New(MB, scale, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		MC, err := ecd.DecodeMatrixFloat64New(mul(t, ptA, ptB), scale*scale)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for l := 0; l < pack; l++ {
+			for i := 0; i < dim; i++ {
+				for j := 0; j < dim; j++ {
+					var want float64
+					for k := 0; k < dim; k++ {
+						want += MA[l][i][k] * MB[l][k][j]
+					}
+					if math.Abs(MC[l][i][j]-want) > float64(dim)/scale {
+						t.Fatalf("expected %v, got %v", want, MC[l][i][j])
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("Float64/Invalid", func(t *testing.T) {
+		M := [][][]float64{{{math.NaN()}}}
+		for l := 1; l < params.Slots(); l++ {
+			M = append(M, [][]float64{{0}})
+		}
+
+		if _, err := ecd.EncodeMatrixFloat64New(M, 1, true); !errors.Is(err, hpbfv.ErrOverflow) {
+			t.Fatalf("expected ErrOverflow, got %v", err)
+		}
+
+		M[0][0][0] = math.Ldexp(1, 200)
+		if _, err := ecd.EncodeMatrixFloat64New(M, 1, true); !errors.Is(err, hpbfv.ErrOverflow) {
+			t.Fatalf("expected ErrOverflow, got %v", err)
+		}
+
+		M[0][0][0] = math.Ldexp(1, 100)
+		pt, err := ecd.EncodeMatrixFloat64New(M, 1, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		MOut, err := ecd.DecodeMatrixFloat64New(pt, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if MOut[0][0][0] != M[0][0][0] {
+			t.Fatalf("expected %v, got %v", M[0][0][0], MOut[0][0][0])
+		}
+
+		if _, err := ecd.EncodeMatrixFloat64New(M, 0, true); !errors.Is(err, hpbfv.ErrInvalidParameters) {
+			t.Fatalf("expected ErrInvalidParameters, got %v", err)
+		}
+	})
 }
 
-var mulParamSet = []hpbfv.ParametersLiteral{
-	hpbfv.HPN14D13T128,
-	hpbfv.HPN14D12T256,
-	hpbfv.HPN14D11T512,
-	hpbfv.HPN14D10T1024,
-	hpbfv.HPN14D9T2048,
-	hpbfv.HPN14D8T4096,
+func TestMatLayout(t *testing.T) {
+	maxDim := 16
+	tc := newMatTestContext(t, hpbfv.HPN13D10T128, maxDim)
+	params, ecd, enc := tc.params, tc.ecd, tc.enc
+	r := rand.New(rand.NewSource(0))
 
-	hpbfv.HPN13D10T128,
-	hpbfv.HPN13D9T256,
-	hpbfv.HPN13D8T512,
-	hpbfv.HPN13D7T1024,
-	hpbfv.HPN13D6T2048,
-	hpbfv.HPN13D5T4096,
+	newMatrices := func(dims []int) (matrices [][][]*big.Int) {
+		matrices = make([][][]*big.Int, len(dims))
+		for l, dim := range dims {
+			matrices[l] = make([][]*big.Int, dim)
+			for i := range matrices[l] {
+				matrices[l][i] = make([]*big.Int, dim)
+				for j := range matrices[l][i] {
+					matrices[l][i][j] = big.NewInt(r.Int63n(1 << 16))
+				}
+			}
+		}
+		return
+	}
 
-	hpbfv.PN15T128,
-	hpbfv.PN16T256,
-	hpbfv.PN17T512,
-	hpbfv.PN18T1024,
+	eval, err := hpbfv.NewMatrixEvaluatorForDim(params, maxDim, tc.rlk, tc.rks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypt := func(t *testing.T, ct *hpbfv.MatrixCiphertext) [][][]*big.Int {
+		pt, err := enc.DecryptNew(ct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		matrices, err := ecd.DecodeLayoutMatrixNew(pt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return matrices
+	}
+
+	encrypt := func(t *testing.T, matrices [][][]*big.Int, layout *hpbfv.MatrixLayout, isDiagonal bool) *hpbfv.MatrixCiphertext {
+		pt, err := ecd.EncodeLayoutMatrixNew(matrices, layout, isDiagonal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct, err := enc.EncryptNew(pt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ct
+	}
+
+	// The last layout repeats the second one, for the masks and buffers reused from the previous products.
+	for _, dims := range [][]int{{16, 16, 16}, {4, 16, 8, 16, 2, 8}, {16, 2, 8}, {4, 16, 8, 16, 2, 8}} {
+		t.Run(fmt.Sprintf("Mul/dims=%v", dims), func(t *testing.T) {
+			layout, err := hpbfv.NewMatrixLayout(params, dims...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			A, B := newMatrices(dims), newMatrices(dims)
+			ctA := encrypt(t, A, layout, true)
+			ctB := encrypt(t, B, layout, false)
+
+			ctC, err := eval.MulNew(ctA, ctB)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ctC.Layout.Equal(layout) {
+				t.Fatalf("expected layout %v, got %v", layout, ctC.Layout)
+			}
+
+			compareMatrices(t, decrypt(t, ctC), mulMatrices(A, B, params.T()))
+		})
+	}
+
+	t.Run("Linear", func(t *testing.T) {
+		dims := []int{16, 2}
+		layout, err := hpbfv.NewMatrixLayout(params, dims...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		A, B := newMatrices(dims), newMatrices(dims)
+		ctC, err := eval.AddNew(encrypt(t, A, layout, true), encrypt(t, B, layout, true))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		compareMatrices(t, decrypt(t, ctC), mapMatrices(A, B, params.T(), func(a, b *big.Int) *big.Int { return new(big.Int).Add(a, b) }))
+
+		ptC, err := enc.DecryptNew(ctC)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		other, err := hpbfv.NewMatrixLayout(params, 2, 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = eval.AddNew(ctC, encrypt(t, newMatrices([]int{2, 16}), other, true)); !errors.Is(err, hpbfv.ErrEncodingMismatch) {
+			t.Errorf("expected %v, got %v", hpbfv.ErrEncodingMismatch, err)
+		}
+		if _, err = ecd.DecodeMatrixNew(ptC); !errors.Is(err, hpbfv.ErrEncodingMismatch) {
+			t.Errorf("expected %v, got %v", hpbfv.ErrEncodingMismatch, err)
+		}
+		if _, err = eval.TransposeNew(ctC); !errors.Is(err, hpbfv.ErrEncodingMismatch) {
+			t.Errorf("expected %v, got %v", hpbfv.ErrEncodingMismatch, err)
+		}
+	})
+
+	t.Run("Marshal", func(t *testing.T) {
+		layout, err := hpbfv.NewMatrixLayout(params, 4, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct := encrypt(t, newMatrices([]int{4, 2}), layout, true)
+
+		data, err := ct.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the layout is read from data, the target only fixes the dimension
+		ctOut, err := hpbfv.NewMatrixCiphertext(params, layout.Dim(), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ctOut.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		if err = ctOut.Check(params); err != nil {
+			t.Fatal(err)
+		}
+		if !ctOut.Layout.Equal(layout) {
+			t.Errorf("expected layout %v, got %v", layout, ctOut.Layout)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		if _, err := hpbfv.NewMatrixLayout(params, params.Slots(), 1); !errors.Is(err, hpbfv.ErrInvalidLayout) {
+			t.Errorf("expected %v, got %v", hpbfv.ErrInvalidLayout, err)
+		}
+		if _, err := hpbfv.NewMatrixLayout(params, 3); !errors.Is(err, hpbfv.ErrDimNotDivisor) {
+			t.Errorf("expected %v, got %v", hpbfv.ErrDimNotDivisor, err)
+		}
+
+		overlap := &hpbfv.MatrixLayout{Matrices: []hpbfv.MatrixPlacement{{Dim: 4, Offset: 0}, {Dim: 2, Offset: params.Slots() / 4}}}
+		if _, err := hpbfv.NewMatrixCiphertextWithLayout(params, overlap, true); !errors.Is(err, hpbfv.ErrInvalidLayout) {
+			t.Errorf("expected %v, got %v", hpbfv.ErrInvalidLayout, err)
+		}
+	})
 }
 
-func TestMatMul(t *testing.T) {
-	params := hpbfv.NewParametersFromLiteral(hpbfv.HPN13D10T128)
+func TestMatMarshal(t *testing.T) {
+	tc := newMatTestContext(t, hpbfv.HPN14D13T128, 0)
+	params, ecd := tc.params, tc.ecd
 
 	dims := 2
 	pack := params.Slots() / dims
-	M0 := make([][][]*big.Int, pack)
-	M1 := make([][][]*big.Int, pack)
-	MOut := make([][][]*big.Int, pack)
+	M := make([][][]*big.Int, pack)
 	for i := 0; i < pack; i++ {
-		M0[i] = [][]*big.Int{
+		M[i] = [][]*big.Int{
 			{big.NewInt(1), big.NewInt(2)},
 			{big.NewInt(3), big.NewInt(4)},
 		}
+	}
 
-		M1[i] = [][]*big.Int{
-			{big.NewInt(5), big.NewInt(6)},
-			{big.NewInt(7), big.NewInt(8)},
+	t.Run("MatrixMessage", func(t *testing.T) {
+		em, err := ecd.EncodeMatrixMessageNew(M, false)
+		if err != nil {
+			t.Fatal(err)
 		}
 
-		MOut[i] = [][]*big.Int{
-			{big.NewInt(19), big.NewInt(22)},
-			{big.NewInt(43), big.NewInt(50)},
+		data, err := em.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		emTest, err := hpbfv.NewMatrixMessage(params, dims, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := emTest.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := emTest.Check(params); err != nil {
+			t.Fatal(err)
+		}
+
+		if emTest.Pack != em.Pack || emTest.IsDiagonal != em.IsDiagonal {
+			t.Fatalf("wrong encoding of the MatrixMessage")
+		}
+
+		MTest, err := ecd.DecodeMatrixMessageNew(emTest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compareMatrices(t, MTest, M)
+	})
+
+	t.Run("MatrixMessage/Version1", func(t *testing.T) {
+		// Written by the version 1 of the encoding, for HPN13D10T128 and a single 2 x 2 matrix
+		// (1, 2; 3, T - 1) followed by zero matrices.
+		data, err := os.ReadFile("testdata/matrix_message_v1.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		paramsV1, err := hpbfv.NewParametersFromLiteral(hpbfv.HPN13D10T128)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		em, err := hpbfv.NewMatrixMessage(paramsV1, 2, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := em.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := em.Check(paramsV1); err != nil {
+			t.Fatal(err)
+		}
+
+		if em.Pack != paramsV1.Slots()/2 || em.IsDiagonal || em.Layout != nil {
+			t.Fatalf("wrong encoding of the MatrixMessage")
+		}
+
+		MTest, err := hpbfv.NewMatrixEncoder(paramsV1).DecodeMatrixMessageNew(em)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := make([][][]*big.Int, len(MTest))
+		want[0] = [][]*big.Int{{big.NewInt(1), big.NewInt(2)}, {big.NewInt(3), new(big.Int).Sub(paramsV1.T(), big.NewInt(1))}}
+		for l := 1; l < len(want); l++ {
+			want[l] = [][]*big.Int{{new(big.Int), new(big.Int)}, {new(big.Int), new(big.Int)}}
+		}
+		compareMatrices(t, MTest, want)
+
+		if em, err = hpbfv.NewMatrixMessage(params, 2, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := em.UnmarshalBinary(data); err == nil {
+			t.Fatalf("MatrixMessage decoded with other parameters")
+		}
+	})
+
+	t.Run("MatrixPlaintext", func(t *testing.T) {
+		pm, err := ecd.EncodeMatrixNew(M, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := pm.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pmTest, err := hpbfv.NewMatrixPlaintext(params, dims, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pmTest.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := pmTest.Check(params); err != nil {
+			t.Fatal(err)
+		}
+
+		if pmTest.Pack != pm.Pack || pmTest.IsDiagonal != pm.IsDiagonal {
+			t.Fatalf("wrong encoding of the MatrixPlaintext")
+		}
+
+		MTest, err := ecd.DecodeMatrixNew(pmTest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compareMatrices(t, MTest, M)
+	})
+
+	t.Run("MatrixCiphertext", func(t *testing.T) {
+		cm := tc.encrypt(t, M, false)
+
+		buf := new(bytes.Buffer)
+		n, err := cm.WriteTo(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if int(n) != buf.Len() {
+			t.Fatalf("WriteTo: expected %d bytes, got %d", buf.Len(), n)
+		}
+
+		cmTest, err := hpbfv.NewMatrixCiphertext(params, dims, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m, err := cmTest.ReadFrom(buf); err != nil || m != n {
+			t.Fatalf("ReadFrom: read %d bytes out of %d: %v", m, n, err)
+		}
+		if err := cmTest.Check(params); err != nil {
+			t.Fatal(err)
+		}
+
+		if cmTest.Pack != cm.Pack || cmTest.IsDiagonal != cm.IsDiagonal {
+			t.Fatalf("wrong encoding of the MatrixCiphertext")
+		}
+
+		tc.check(t, cmTest, M)
+
+		data, err := cm.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pm, err := hpbfv.NewMatrixPlaintext(params, dims, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pm.UnmarshalBinary(data); err == nil {
+			t.Fatalf("MatrixCiphertext decoded as a MatrixPlaintext")
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		cm := tc.encrypt(t, M, false)
+
+		data, err := cm.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The header is version | kind | isDiagonal | dim | pack | number of matrices of the layout,
+		// followed by the length of the first diagonal and its rlwe.Ciphertext encoding.
+		corrupt := func(offset int, value []byte) []byte {
+			out := append([]byte{}, data...)
+			copy(out[offset:], value)
+			return out
+		}
+
+		for name, invalid := range map[string][]byte{
+			"Dim":           corrupt(3, []byte{0x80, 0, 0, 0}),
+			"DimNotDivisor": corrupt(3, []byte{0, 0, 0, 3}),
+			"Pack":          corrupt(7, []byte{0, 0, 0, 1}),
+			"Layout":        corrupt(11, []byte{0xff, 0xff, 0xff, 0xff}),
+			"ElementLength": corrupt(15, []byte{0xff, 0xff, 0xff, 0xff}),
+			"Scale":         corrupt(19, []byte{0xff}),
+			"Degree":        corrupt(19+50, []byte{0xff}),
+			"RingDegree":    corrupt(19+51, []byte{0, 0, 0, 1}),
+			"Truncated":     data[:len(data)-1],
+		} {
+			cmTest, err := hpbfv.NewMatrixCiphertext(params, dims, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cmTest.UnmarshalBinary(invalid); err == nil {
+				t.Errorf("%s: expected an error", name)
+			}
+		}
+
+		if err := new(hpbfv.MatrixCiphertext).UnmarshalBinary(data); err == nil {
+			t.Errorf("MatrixCiphertext decoded in an unallocated target")
+		}
+
+		other, err := hpbfv.NewParametersFromLiteral(hpbfv.HPN13D10T128)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmOther, err := hpbfv.NewMatrixCiphertext(other, dims, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cmOther.UnmarshalBinary(data); err == nil {
+			t.Errorf("MatrixCiphertext decoded with other parameters")
+		}
+		if err := cm.Check(other); err == nil {
+			t.Errorf("MatrixCiphertext checked against other parameters")
+		}
+	})
+}
+
+// sampleMatrices returns count uniform rows x cols matrices over Z_T.
+func sampleMatrices(params hpbfv.Parameters, count, rows, cols int) (matrices [][][]*big.Int) {
+	matrices = make([][][]*big.Int, count)
+	for l := range matrices {
+		matrices[l] = make([][]*big.Int, rows)
+		for i := range matrices[l] {
+			matrices[l][i] = make([]*big.Int, cols)
+			for j := range matrices[l][i] {
+				matrices[l][i][j] = ring.RandInt(params.T())
+			}
+		}
+	}
+	return
+}
+
+// mulMatrices returns the products mod T of the matrices of A by the ones of B.
+func mulMatrices(A, B [][][]*big.Int, T *big.Int) (C [][][]*big.Int) {
+	C = make([][][]*big.Int, len(A))
+	for l := range C {
+		C[l] = make([][]*big.Int, len(A[l]))
+		for i := range C[l] {
+			C[l][i] = make([]*big.Int, len(B[l][0]))
+			for j := range C[l][i] {
+				C[l][i][j] = new(big.Int)
+				for k := range B[l] {
+					C[l][i][j].Add(C[l][i][j], new(big.Int).Mul(A[l][i][k], B[l][k][j]))
+				}
+				C[l][i][j].Mod(C[l][i][j], T)
+			}
 		}
 	}
+	return
+}
 
-	kg := hpbfv.NewKeyGenerator(params)
-	sk, pk := kg.GenKeyPair()
-	rlk := kg.GenRelinearizationKey(sk, 1)
-	rks := kg.GenRotationKeysForMatMul(sk, dims)
+// transposeMatrices returns the transposes of the matrices of A.
+func transposeMatrices(A [][][]*big.Int) (AT [][][]*big.Int) {
+	AT = make([][][]*big.Int, len(A))
+	for l := range A {
+		AT[l] = make([][]*big.Int, len(A[l][0]))
+		for j := range AT[l] {
+			AT[l][j] = make([]*big.Int, len(A[l]))
+			for i := range AT[l][j] {
+				AT[l][j][i] = A[l][i][j]
+			}
+		}
+	}
+	return
+}
 
-	ecd := hpbfv.NewMatrixEncoder(params)
-	pt0 := ecd.EncodeMatrixNew(M0, true)
-	pt1 := ecd.EncodeMatrixNew(M1, false)
+// mapMatrices returns the matrices of entries f(a, b) mod T, for a and b the entries of A and B.
+func mapMatrices(A, B [][][]*big.Int, T *big.Int, f func(a, b *big.Int) *big.Int) (C [][][]*big.Int) {
+	C = make([][][]*big.Int, len(A))
+	for l := range A {
+		C[l] = make([][]*big.Int, len(A[l]))
+		for i := range A[l] {
+			C[l][i] = make([]*big.Int, len(A[l][i]))
+			for j := range A[l][i] {
+				C[l][i][j] = f(A[l][i][j], B[l][i][j])
+				C[l][i][j].Mod(C[l][i][j], T)
+			}
+		}
+	}
+	return
+}
 
-	enc := hpbfv.NewMatrixEncryptor(params, pk, sk)
-	ct0 := enc.EncryptNew(pt0)
-	ct1 := enc.EncryptNew(pt1)
+// encryptMatrices encodes and encrypts matrices.
+func encryptMatrices(ecd *hpbfv.MatrixEncoder, enc *hpbfv.MatrixEncryptor, matrices [][][]*big.Int, isDiagonal bool) (ct *hpbfv.MatrixCiphertext, err error) {
+	pt, err := ecd.EncodeMatrixNew(matrices, isDiagonal)
+	if err != nil {
+		return nil, err
+	}
+	return enc.EncryptNew(pt)
+}
 
-	eval := hpbfv.NewMatrixEvaluator(params, rlk, rks)
-	ctOut := eval.MulNew(ct0, ct1)
+// decryptMatrices decrypts and decodes ct.
+func decryptMatrices(ecd *hpbfv.MatrixEncoder, enc *hpbfv.MatrixEncryptor, ct *hpbfv.MatrixCiphertext) (matrices [][][]*big.Int, err error) {
+	pt, err := enc.DecryptNew(ct)
+	if err != nil {
+		return nil, err
+	}
+	return ecd.DecodeMatrixNew(pt)
+}
 
-	ptOut := enc.DecryptNew(ctOut)
-	MOutTest := ecd.DecodeMatrixNew(ptOut)
+// checkMatrices decrypts and decodes ct and checks that its matrices are want.
+func checkMatrices(t *testing.T, ecd *hpbfv.MatrixEncoder, enc *hpbfv.MatrixEncryptor, ct *hpbfv.MatrixCiphertext, want [][][]*big.Int) {
+	t.Helper()
 
-	for i := 0; i < pack; i++ {
-		for j := 0; j < dims; j++ {
-			for k := 0; k < dims; k++ {
-				if MOutTest[i][j][k].Cmp(MOut[i][j][k]) != 0 {
-					t.Errorf("expected %v, got %v", MOut[i][j][k], MOutTest[i][j][k])
+	got, err := decryptMatrices(ecd, enc, ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareMatrices(t, got, want)
+}
+
+// compareMatrices checks that the first len(want) matrices of got have the entries of want.
+func compareMatrices(t *testing.T, got, want [][][]*big.Int) {
+	t.Helper()
+
+	if len(got) < len(want) {
+		t.Fatalf("expected %d matrices, got %d", len(want), len(got))
+	}
+
+	for l := range want {
+		for i := range want[l] {
+			for j := range want[l][i] {
+				if i >= len(got[l]) || j >= len(got[l][i]) {
+					t.Fatalf("matrix %d: entry (%d, %d) is missing", l, i, j)
+				}
+				if got[l][i][j].Cmp(want[l][i][j]) != 0 {
+					t.Fatalf("matrix %d entry (%d, %d): expected %v, got %v", l, i, j, want[l][i][j], got[l][i][j])
 				}
 			}
 		}
 	}
 }
 
+// matTestContext stores the keys, the encoder, the encryptor and the evaluator of a matrix test.
+type matTestContext struct {
+	params hpbfv.Parameters
+	kg     hpbfv.KeyGenerator
+	sk     *rlwe.SecretKey
+	pk     *rlwe.PublicKey
+	rlk    *rlwe.RelinearizationKey
+	rks    *rlwe.RotationKeySet
+	ecd    *hpbfv.MatrixEncoder
+	enc    *hpbfv.MatrixEncryptor
+	eval   *hpbfv.MatrixEvaluator
+}
+
+// newMatTestContext creates the parameters of pl and generates a key pair and a relinearization key, along
+// with the rotation keys of GenRotationKeysForMatMul for dim if dim > 0.
+func newMatTestContext(t *testing.T, pl hpbfv.ParametersLiteral, dim int) (tc *matTestContext) {
+	t.Helper()
+
+	params, err := hpbfv.NewParametersFromLiteral(pl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tc = &matTestContext{params: params, kg: hpbfv.NewKeyGenerator(params)}
+	tc.sk, tc.pk = tc.kg.GenKeyPair()
+	tc.rlk = tc.kg.GenRelinearizationKey(tc.sk, 1)
+
+	tc.ecd = hpbfv.NewMatrixEncoder(params)
+	tc.enc = hpbfv.NewMatrixEncryptor(params, tc.pk, tc.sk)
+	tc.eval = hpbfv.NewMatrixEvaluator(params, tc.rlk, nil)
+
+	if dim > 0 {
+		tc.genRotationKeysForMatMul(t, dim)
+	}
+
+	return
+}
+
+// genRotationKeysForMatMul generates the rotation keys of GenRotationKeysForMatMul for dim
+// and recreates the evaluator with them.
+func (tc *matTestContext) genRotationKeysForMatMul(t *testing.T, dim int) {
+	t.Helper()
+
+	rks, err := tc.kg.GenRotationKeysForMatMul(tc.sk, dim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.rks = rks
+	tc.eval = hpbfv.NewMatrixEvaluator(tc.params, tc.rlk, tc.rks)
+}
+
+// encrypt encodes and encrypts matrices.
+func (tc *matTestContext) encrypt(t *testing.T, matrices [][][]*big.Int, isDiagonal bool) (ct *hpbfv.MatrixCiphertext) {
+	t.Helper()
+
+	ct, err := encryptMatrices(tc.ecd, tc.enc, matrices, isDiagonal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// check decrypts and decodes ct and checks that its matrices are want.
+func (tc *matTestContext) check(t *testing.T, ct *hpbfv.MatrixCiphertext, want [][][]*big.Int) {
+	t.Helper()
+	checkMatrices(t, tc.ecd, tc.enc, ct, want)
+}
+
 func BenchmarkMatMul(b *testing.B) {
 	dim := 128
 	prng, _ := utils.NewPRNG()
 
 	for _, pl := range matParamSet {
-		params := hpbfv.NewParametersFromLiteral(pl)
+		params, err := hpbfv.NewParametersFromLiteral(pl)
+		if err != nil {
+			b.Fatal(err)
+		}
 
 		us := ring.NewUniformSampler(prng, params.RingQ())
 
-		ctA := hpbfv.NewMatrixCiphertext(params, dim, true)
-		ctB := hpbfv.NewMatrixCiphertext(params, dim, false)
-		ctC := hpbfv.NewMatrixCiphertext(params, dim, true)
+		ctA, err := hpbfv.NewMatrixCiphertext(params, dim, true)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ctB, err := hpbfv.NewMatrixCiphertext(params, dim, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ctC, err := hpbfv.NewMatrixCiphertext(params, dim, true)
+		if err != nil {
+			b.Fatal(err)
+		}
 
 		for i := range ctA.Value {
 			us.Read(ctA.Value[i].Value[0])
@@ -126,23 +1832,37 @@ func BenchmarkMatMul(b *testing.B) {
 		kg := hpbfv.NewKeyGenerator(params)
 		sk := kg.GenSecretKey()
 		rlk := kg.GenRelinearizationKey(sk, 1)
-		rks := kg.GenRotationKeysForMatMul(sk, dim)
+		rks, err := kg.GenRotationKeysForMatMul(sk, dim)
+		if err != nil {
+			b.Fatal(err)
+		}
 
 		eval := hpbfv.NewMatrixEvaluator(params, rlk, rks)
 
 		logT := params.T().BitLen()
 
-		b.Run(fmt.Sprintf("MatMul/N=%v/T=%v/Pack=%v", params.LogN(), logT, ctA.Pack), func(b *testing.B) {
-			for i := 0; i < b.N; i++ {
-				eval.Mul(ctA, ctB, ctC)
+		for workers := 1; workers <= runtime.NumCPU(); workers *= 2 {
+			if err := eval.SetWorkers(workers); err != nil {
+				b.Fatal(err)
 			}
-		})
+
+			b.Run(fmt.Sprintf("MatMul/N=%v/T=%v/Pack=%v/Workers=%v", params.LogN(), logT, ctA.Pack, workers), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if err := eval.Mul(ctA, ctB, ctC); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
 	}
 }
 
 func BenchmarkMatMulAuth(b *testing.B) {
 
-	params := hpbfv.NewParametersFromLiteral(hpbfv.HPN14D13T128)
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.HPN14D13T128)
+	if err != nil {
+		b.Fatal(err)
+	}
 	prng, _ := utils.NewPRNG()
 	us := ring.NewUniformSampler(prng, params.RingQ())
 
@@ -150,21 +1870,23 @@ func BenchmarkMatMulAuth(b *testing.B) {
 	us.Read(ctAlpha.Value[0])
 	us.Read(ctAlpha.Value[1])
 
-	ctAlphaHoisted := []ringqp.Poly{
-		{Q: params.RingQ().NewPoly(), P: params.RingQMul().NewPoly()},
-		{Q: params.RingQ().NewPoly(), P: params.RingQMul().NewPoly()},
-	}
-
 	kg := hpbfv.NewKeyGenerator(params)
 	sk := kg.GenSecretKey()
 	rlk := kg.GenRelinearizationKey(sk, 1)
 
-	cEval := hpbfv.NewEvaluator(params)
-
 	for _, dim := range []int{128, 256, 512} {
-		ctA := hpbfv.NewMatrixCiphertext(params, dim, true)
-		ctB := hpbfv.NewMatrixCiphertext(params, dim, false)
-		ctC := hpbfv.NewMatrixCiphertext(params, dim, true)
+		ctA, err := hpbfv.NewMatrixCiphertext(params, dim, true)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ctB, err := hpbfv.NewMatrixCiphertext(params, dim, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ctC, err := hpbfv.NewMatrixCiphertext(params, dim, true)
+		if err != nil {
+			b.Fatal(err)
+		}
 
 		for i := range ctA.Value {
 			us.Read(ctA.Value[i].Value[0])
@@ -175,19 +1897,20 @@ func BenchmarkMatMulAuth(b *testing.B) {
 			us.Read(ctB.Value[i].Value[1])
 		}
 
-		rks := kg.GenRotationKeysForMatMul(sk, dim)
+		rks, err := kg.GenRotationKeysForMatMul(sk, dim)
+		if err != nil {
+			b.Fatal(err)
+		}
 
 		eval := hpbfv.NewMatrixEvaluator(params, rlk, rks)
 
 		b.Run(fmt.Sprintf("MatMulAuth/d=%v/Pack=%v", dim, ctA.Pack), func(b *testing.B) {
 			for i := 0; i < b.N; i++ {
-				eval.Mul(ctA, ctB, ctC)
-
-				cEval.RescaleQMul(ctAlpha, ctAlphaHoisted)
-				for j := 0; j < dim; j++ {
-					cEval.MulAndRelinHoisted(ctAlphaHoisted, ctA.Value[j], rlk, ctA.Value[j])
-					cEval.MulAndRelinHoisted(ctAlphaHoisted, ctB.Value[j], rlk, ctB.Value[j])
-					cEval.MulAndRelinHoisted(ctAlphaHoisted, ctC.Value[j], rlk, ctC.Value[j])
+				if err := eval.Mul(ctA, ctB, ctC); err != nil {
+					b.Fatal(err)
+				}
+				if err := eval.Authenticate(ctAlpha, []*hpbfv.MatrixCiphertext{ctA, ctB, ctC}, []*hpbfv.MatrixCiphertext{ctA, ctB, ctC}); err != nil {
+					b.Fatal(err)
 				}
 			}
 		})
@@ -198,7 +1921,10 @@ func BenchmarkMul(b *testing.B) {
 	prng, _ := utils.NewPRNG()
 
 	for _, pl := range mulParamSet {
-		params := hpbfv.NewParametersFromLiteral(pl)
+		params, err := hpbfv.NewParametersFromLiteral(pl)
+		if err != nil {
+			b.Fatal(err)
+		}
 
 		us := ring.NewUniformSampler(prng, params.RingQ())
 
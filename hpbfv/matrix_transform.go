@@ -0,0 +1,222 @@
+package hpbfv
+
+import "fmt"
+
+// ReencodeNew converts ctIn to the diagonal encoding if isDiagonal is true, and to the
+// shifted-diagonal encoding otherwise, and returns the result. See Reencode.
+func (eval *MatrixEvaluator) ReencodeNew(ctIn *MatrixCiphertext, isDiagonal bool) (ctOut *MatrixCiphertext, err error) {
+	if ctOut, err = NewMatrixCiphertext(eval.eval.params, len(ctIn.Value), isDiagonal); err != nil {
+		return nil, fmt.Errorf("cannot ReencodeNew: %w", err)
+	}
+
+	if err = eval.Reencode(ctIn, isDiagonal, ctOut); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Reencode converts ctIn to the diagonal encoding if isDiagonal is true, and to the
+// shifted-diagonal encoding otherwise, and writes the result on ctOut.
+//
+// The i-th shifted diagonal of a matrix is its i-th diagonal rotated by i rows, and the rows
+// of the packed matrices are cyclically rotated by a rotation of pack * i slots. Hence each
+// diagonal is converted with a single rotation, using the rotation keys generated by
+// GenRotationKeysForMatMul. ctIn and ctOut can be the same MatrixCiphertext.
+func (eval *MatrixEvaluator) Reencode(ctIn *MatrixCiphertext, isDiagonal bool, ctOut *MatrixCiphertext) (err error) {
+	if err = eval.checkMatrixCiphertexts(ctIn, ctOut); err != nil {
+		return fmt.Errorf("cannot Reencode: %w", err)
+	}
+
+	if err = checkUniform(ctIn); err != nil {
+		return fmt.Errorf("cannot Reencode: %w", err)
+	}
+
+	pack := ctIn.Pack
+	dim := len(ctIn.Value)
+	if len(ctOut.Value) != dim {
+		return fmt.Errorf("cannot Reencode: %w: dimensions do not match", ErrEncodingMismatch)
+	}
+
+	if ctIn.IsDiagonal != isDiagonal {
+		if err = eval.checkMatMulRotationKeys(pack, dim); err != nil {
+			return fmt.Errorf("cannot Reencode: %w", err)
+		}
+	}
+
+	for i := range ctIn.Value {
+		switch {
+		case ctIn.IsDiagonal == isDiagonal || i == 0:
+			if ctIn != ctOut {
+				ctOut.Value[i].Copy(ctIn.Value[i].El())
+			}
+		case isDiagonal:
+			// shifted diagonal -> diagonal: d_i[j] = s_i[j + i]
+			eval.rotate(ctIn.Value[i], eval.eval.params.GaloisElementForColumnRotationBy(uint64(pack*i)), ctOut.Value[i])
+		default:
+			// diagonal -> shifted diagonal: s_i[j] = d_i[j - i]
+			eval.rotate(ctIn.Value[i], eval.eval.params.GaloisElementForColumnRotationBy(uint64(pack*(dim-i))), ctOut.Value[i])
+		}
+	}
+
+	ctOut.Pack = pack
+	ctOut.Layout = nil
+	ctOut.IsDiagonal = isDiagonal
+
+	return
+}
+
+// MulChainNew multiplies the matrices cts from left to right and returns the result. See MulChain.
+func (eval *MatrixEvaluator) MulChainNew(cts ...*MatrixCiphertext) (ctOut *MatrixCiphertext, err error) {
+	if len(cts) == 0 {
+		return nil, fmt.Errorf("cannot MulChainNew: %w: no matrices to multiply", ErrEncodingMismatch)
+	}
+
+	if ctOut, err = NewMatrixCiphertext(eval.eval.params, len(cts[0].Value), true); err != nil {
+		return nil, fmt.Errorf("cannot MulChainNew: %w", err)
+	}
+
+	if err = eval.MulChain(cts, ctOut); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// MulChain multiplies the matrices cts from left to right and writes the result on ctOut,
+// which is packed diagonally. The matrices can be in any encoding: each of them is converted
+// with Reencode to the encoding required by Mul when needed, so that each product consumes
+// one multiplicative level.
+func (eval *MatrixEvaluator) MulChain(cts []*MatrixCiphertext, ctOut *MatrixCiphertext) (err error) {
+	if len(cts) < 2 {
+		return fmt.Errorf("cannot MulChain: %w: at least two matrices are required", ErrEncodingMismatch)
+	}
+
+	for _, ct := range cts[1:] {
+		if ct == ctOut {
+			return fmt.Errorf("cannot MulChain: %w: ctOut can only alias the first matrix", ErrEncodingMismatch)
+		}
+	}
+
+	var tmp *MatrixCiphertext
+	if tmp, err = NewMatrixCiphertext(eval.eval.params, len(cts[0].Value), false); err != nil {
+		return fmt.Errorf("cannot MulChain: %w", err)
+	}
+
+	if err = eval.Reencode(cts[0], true, ctOut); err != nil {
+		return fmt.Errorf("cannot MulChain: %w", err)
+	}
+
+	for _, ct := range cts[1:] {
+		ctB := ct
+		if ct.IsDiagonal {
+			if err = eval.Reencode(ct, false, tmp); err != nil {
+				return fmt.Errorf("cannot MulChain: %w", err)
+			}
+			ctB = tmp
+		}
+
+		if err = eval.Mul(ctOut, ctB, ctOut); err != nil {
+			return fmt.Errorf("cannot MulChain: %w", err)
+		}
+	}
+
+	return
+}
+
+// TransposeNew transposes every matrix packed in ctIn and returns the result. See Transpose.
+func (eval *MatrixEvaluator) TransposeNew(ctIn *MatrixCiphertext) (ctOut *MatrixCiphertext, err error) {
+	if ctOut, err = NewMatrixCiphertext(eval.eval.params, len(ctIn.Value), ctIn.IsDiagonal); err != nil {
+		return nil, fmt.Errorf("cannot TransposeNew: %w", err)
+	}
+
+	if err = eval.Transpose(ctIn, ctOut); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Transpose transposes every matrix packed in ctIn and writes the result on ctOut, in the same encoding.
+//
+// The i-th diagonal of the transpose is the (dim-i)-th diagonal rotated by i rows, and the i-th shifted
+// diagonal of the transpose is the (dim-i)-th shifted diagonal rotated by -i rows, so that each diagonal
+// is obtained with a single rotation, using the rotation keys generated by GenRotationKeysForTranspose
+// or GenRotationKeysForMatMul. ctIn and ctOut can be the same MatrixCiphertext.
+func (eval *MatrixEvaluator) Transpose(ctIn, ctOut *MatrixCiphertext) (err error) {
+	if err = eval.checkMatrixCiphertexts(ctIn, ctOut); err != nil {
+		return fmt.Errorf("cannot Transpose: %w", err)
+	}
+
+	if err = checkUniform(ctIn); err != nil {
+		return fmt.Errorf("cannot Transpose: %w", err)
+	}
+
+	pack := ctIn.Pack
+	dim := len(ctIn.Value)
+	if len(ctOut.Value) != dim {
+		return fmt.Errorf("cannot Transpose: %w: dimensions do not match", ErrEncodingMismatch)
+	}
+
+	if err = eval.checkMatMulRotationKeys(pack, dim); err != nil {
+		return fmt.Errorf("cannot Transpose: %w", err)
+	}
+
+	// galEl returns the Galois element of the rotation giving the i-th diagonal of the transpose.
+	galEl := func(i int) uint64 {
+		if ctIn.IsDiagonal {
+			return eval.eval.params.GaloisElementForColumnRotationBy(uint64(pack * i))
+		}
+		return eval.eval.params.GaloisElementForColumnRotationBy(uint64(pack * (dim - i)))
+	}
+
+	if ctIn != ctOut {
+		ctOut.Value[0].Copy(ctIn.Value[0].El())
+	}
+
+	// The diagonals i and dim-i are swapped, using poolCt when ctIn and ctOut are the same.
+	for i := 1; 2*i <= dim; i++ {
+		j := dim - i
+		if i == j {
+			eval.rotate(ctIn.Value[i], galEl(i), ctOut.Value[i])
+			continue
+		}
+
+		eval.rotate(ctIn.Value[j], galEl(i), eval.poolCt)
+		eval.rotate(ctIn.Value[i], galEl(j), ctOut.Value[j])
+		ctOut.Value[i].Copy(eval.poolCt.El())
+	}
+
+	ctOut.Pack = pack
+	ctOut.Layout = nil
+	ctOut.IsDiagonal = ctIn.IsDiagonal
+
+	return
+}
+
+// checkMatMulRotationKeys checks that the rotation keys for the rotations by pack * i are available.
+func (eval *MatrixEvaluator) checkMatMulRotationKeys(pack, dim int) (err error) {
+	for i := 1; i < dim; i++ {
+		galEl := eval.eval.params.GaloisElementForColumnRotationBy(uint64(pack * i))
+		if eval.rks == nil || eval.rks.Keys[galEl] == nil {
+			return fmt.Errorf("%w: rotation by %d", ErrMissingRotationKey, pack*i)
+		}
+	}
+	return
+}
+
+// rotate applies the automorphism galEl to ctIn and writes the result on ctOut.
+// Contrary to Evaluator.RotateColumns, the automorphism is applied before the key switch,
+// which uses the rotation keys generated by GenRotationKeysForMatMul from the rotated secret key.
+func (eval *MatrixEvaluator) rotate(ctIn *Ciphertext, galEl uint64, ctOut *Ciphertext) {
+	ringQ := eval.eval.params.RingQ()
+
+	ringQ.Permute(ctIn.Value[0], galEl, eval.poolC[0])
+	ringQ.Permute(ctIn.Value[1], galEl, eval.poolC[1])
+
+	// KeySwitch rot(s) -> (1, s)
+	eval.keySwitch(eval.poolC[1], eval.rks.Keys[galEl].GadgetCiphertext, eval.poolKeySwitch[0])
+
+	ringQ.Add(eval.poolC[0], eval.poolKeySwitch[0].Value[0], ctOut.Value[0])
+	ctOut.Value[1].Copy(eval.poolKeySwitch[0].Value[1])
+}
@@ -0,0 +1,174 @@
+package hpbfv
+
+import (
+	"fmt"
+	"math"
+)
+
+// EncodeMatrixInt64New encodes Pack matrices of int64 into a MatrixPlaintext, mapping each entry x to x mod T.
+func (ecd *MatrixEncoder) EncodeMatrixInt64New(matrices [][][]int64, isDiagonal bool) (pt *MatrixPlaintext, err error) {
+	elements := make([][][]Element, len(matrices))
+	for l := range matrices {
+		elements[l] = make([][]Element, len(matrices[l]))
+		for i := range matrices[l] {
+			elements[l][i] = ecd.zt.NewVector(len(matrices[l][i]))
+			for j, x := range matrices[l][i] {
+				ecd.zt.SetInt64(x, elements[l][i][j])
+			}
+		}
+	}
+
+	if pt, err = ecd.encodeElements(elements, isDiagonal); err != nil {
+		return nil, fmt.Errorf("cannot EncodeMatrixInt64New: %w", err)
+	}
+
+	return
+}
+
+// EncodeMatrixUint64New encodes Pack matrices of uint64 into a MatrixPlaintext.
+func (ecd *MatrixEncoder) EncodeMatrixUint64New(matrices [][][]uint64, isDiagonal bool) (pt *MatrixPlaintext, err error) {
+	elements := make([][][]Element, len(matrices))
+	for l := range matrices {
+		elements[l] = make([][]Element, len(matrices[l]))
+		for i := range matrices[l] {
+			elements[l][i] = ecd.zt.NewVector(len(matrices[l][i]))
+			for j, x := range matrices[l][i] {
+				ecd.zt.SetUint64(x, elements[l][i][j])
+			}
+		}
+	}
+
+	if pt, err = ecd.encodeElements(elements, isDiagonal); err != nil {
+		return nil, fmt.Errorf("cannot EncodeMatrixUint64New: %w", err)
+	}
+
+	return
+}
+
+// EncodeMatrixFloat64New encodes Pack matrices of float64 into a MatrixPlaintext in fixed-point:
+// each entry x is mapped to round(x * scale) mod T.
+// The product of two matrices encoded with scales s0 and s1 must be decoded with the scale s0 * s1.
+// It returns an error wrapping ErrOverflow if an entry is not finite or round(x * scale) is not in (-T/2, T/2).
+func (ecd *MatrixEncoder) EncodeMatrixFloat64New(matrices [][][]float64, scale float64, isDiagonal bool) (pt *MatrixPlaintext, err error) {
+	if err = checkScale(scale); err != nil {
+		return nil, fmt.Errorf("cannot EncodeMatrixFloat64New: %w", err)
+	}
+
+	elements := make([][][]Element, len(matrices))
+	for l := range matrices {
+		elements[l] = make([][]Element, len(matrices[l]))
+		for i := range matrices[l] {
+			elements[l][i] = ecd.zt.NewVector(len(matrices[l][i]))
+			for j, x := range matrices[l][i] {
+				if err = ecd.zt.SetFloat64(x*scale, elements[l][i][j]); err != nil {
+					return nil, fmt.Errorf("cannot EncodeMatrixFloat64New: entry (%d, %d) of matrix %d: %w", i, j, l, err)
+				}
+			}
+		}
+	}
+
+	if pt, err = ecd.encodeElements(elements, isDiagonal); err != nil {
+		return nil, fmt.Errorf("cannot EncodeMatrixFloat64New: %w", err)
+	}
+
+	return
+}
+
+// DecodeMatrixInt64New decodes a MatrixPlaintext into Pack matrices of int64, taking the centered
+// representative in (-T/2, T/2) of each entry. It returns an error wrapping ErrOverflow if an entry
+// does not fit in an int64.
+func (ecd *MatrixEncoder) DecodeMatrixInt64New(pt *MatrixPlaintext) (matrices [][][]int64, err error) {
+	elements, err := ecd.decodePlaintextElements(pt)
+	if err != nil {
+		return nil, fmt.Errorf("cannot DecodeMatrixInt64New: %w", err)
+	}
+
+	matrices = make([][][]int64, len(elements))
+	for l := range elements {
+		matrices[l] = make([][]int64, len(elements[l]))
+		for i := range elements[l] {
+			matrices[l][i] = make([]int64, len(elements[l][i]))
+			for j, el := range elements[l][i] {
+				if matrices[l][i][j], err = ecd.zt.Int64(el); err != nil {
+					return nil, fmt.Errorf("cannot DecodeMatrixInt64New: entry (%d, %d) of matrix %d: %w", i, j, l, err)
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// DecodeMatrixUint64New decodes a MatrixPlaintext into Pack matrices of uint64, taking the centered
+// representative in (-T/2, T/2) of each entry. It returns an error wrapping ErrOverflow if an entry
+// is negative or does not fit in an uint64.
+func (ecd *MatrixEncoder) DecodeMatrixUint64New(pt *MatrixPlaintext) (matrices [][][]uint64, err error) {
+	elements, err := ecd.decodePlaintextElements(pt)
+	if err != nil {
+		return nil, fmt.Errorf("cannot DecodeMatrixUint64New: %w", err)
+	}
+
+	matrices = make([][][]uint64, len(elements))
+	for l := range elements {
+		matrices[l] = make([][]uint64, len(elements[l]))
+		for i := range elements[l] {
+			matrices[l][i] = make([]uint64, len(elements[l][i]))
+			for j, el := range elements[l][i] {
+				if matrices[l][i][j], err = ecd.zt.Uint64(el); err != nil {
+					return nil, fmt.Errorf("cannot DecodeMatrixUint64New: entry (%d, %d) of matrix %d: %w", i, j, l, err)
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// DecodeMatrixFloat64New decodes a MatrixPlaintext encoded in fixed-point with the given scale into
+// Pack matrices of float64, dividing the centered representative in (-T/2, T/2) of each entry by scale.
+// It returns an error wrapping ErrOverflow if an entry does not fit in a float64.
+func (ecd *MatrixEncoder) DecodeMatrixFloat64New(pt *MatrixPlaintext, scale float64) (matrices [][][]float64, err error) {
+	if err = checkScale(scale); err != nil {
+		return nil, fmt.Errorf("cannot DecodeMatrixFloat64New: %w", err)
+	}
+
+	elements, err := ecd.decodePlaintextElements(pt)
+	if err != nil {
+		return nil, fmt.Errorf("cannot DecodeMatrixFloat64New: %w", err)
+	}
+
+	matrices = make([][][]float64, len(elements))
+	for l := range elements {
+		matrices[l] = make([][]float64, len(elements[l]))
+		for i := range elements[l] {
+			matrices[l][i] = make([]float64, len(elements[l][i]))
+			for j, el := range elements[l][i] {
+				x := ecd.zt.Float64(el) / scale
+				if math.IsInf(x, 0) {
+					return nil, fmt.Errorf("cannot DecodeMatrixFloat64New: entry (%d, %d) of matrix %d: %w: %s does not fit in a float64",
+						i, j, l, ErrOverflow, ecd.zt.centeredText(el))
+				}
+				matrices[l][i][j] = x
+			}
+		}
+	}
+
+	return
+}
+
+// decodePlaintextElements decodes every diagonal of pt into new matrices of Elements.
+func (ecd *MatrixEncoder) decodePlaintextElements(pt *MatrixPlaintext) (matrices [][][]Element, err error) {
+	em, err := ecd.decodeMessages(pt)
+	if err != nil {
+		return nil, err
+	}
+	return ecd.decodeElements(em)
+}
+
+// checkScale checks that the fixed-point scale is positive and finite.
+func checkScale(scale float64) error {
+	if !(scale > 0) || math.IsInf(scale, 0) {
+		return fmt.Errorf("%w: the scale must be positive and finite", ErrInvalidParameters)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package hpbfv
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// VectorPlaintext packs Pack vectors of dimension Slots() / Pack in one Plaintext,
+// with the same layout as a diagonal of a MatrixPlaintext: the j-th entry of the l-th
+// vector is stored in the slot j * Pack + l.
+type VectorPlaintext struct {
+	Value *Plaintext
+	Pack  int
+}
+
+// VectorCiphertext is the encryption of a VectorPlaintext.
+type VectorCiphertext struct {
+	Value *Ciphertext
+	Pack  int
+}
+
+// NewVectorPlaintext creates a new VectorPlaintext of vectors of dimension dim.
+func NewVectorPlaintext(params Parameters, dim int) (pv *VectorPlaintext, err error) {
+	pack, err := packFor(params, dim)
+	if err != nil {
+		return nil, fmt.Errorf("cannot NewVectorPlaintext: %w", err)
+	}
+	return &VectorPlaintext{Value: NewPlaintext(params), Pack: pack}, nil
+}
+
+// NewVectorCiphertext creates a new VectorCiphertext of vectors of dimension dim.
+func NewVectorCiphertext(params Parameters, dim int) (cv *VectorCiphertext, err error) {
+	pack, err := packFor(params, dim)
+	if err != nil {
+		return nil, fmt.Errorf("cannot NewVectorCiphertext: %w", err)
+	}
+	return &VectorCiphertext{Value: NewCiphertext(params, 1), Pack: pack}, nil
+}
+
+// checkPack returns an error if pack vectors cannot be packed in the slots.
+func checkPack(params Parameters, pack int) error {
+	if pack <= 0 || params.Slots()%pack != 0 {
+		return fmt.Errorf("%w: pack=%d must be positive and divide the number of slots %d", ErrEncodingMismatch, pack, params.Slots())
+	}
+	return nil
+}
+
+// EncodeVectorNew encodes Slots() / dim vectors of dimension dim into a VectorPlaintext.
+func (ecd *MatrixEncoder) EncodeVectorNew(vectors [][]*big.Int) (pv *VectorPlaintext, err error) {
+	if len(vectors) == 0 || len(vectors)*len(vectors[0]) != ecd.ecd.params.Slots() {
+		return nil, fmt.Errorf("cannot EncodeVectorNew: %w: pack * dim must be equal to the number of slots", ErrDimNotDivisor)
+	}
+
+	pack := len(vectors)
+	dim := len(vectors[0])
+
+	msg := NewMessage(ecd.ecd.params)
+	for l := range vectors {
+		if len(vectors[l]) != dim {
+			return nil, fmt.Errorf("cannot EncodeVectorNew: %w: vectors must have the same dimension", ErrEncodingMismatch)
+		}
+
+		for j := range vectors[l] {
+			ecd.zt.SetBigInt(vectors[l][j], msg.Value[j*pack+l])
+		}
+	}
+
+	pv = &VectorPlaintext{Value: ecd.ecd.EncodeNew(msg), Pack: pack}
+	return
+}
+
+// DecodeVectorNew decodes a VectorPlaintext into its Pack vectors.
+func (ecd *MatrixEncoder) DecodeVectorNew(pv *VectorPlaintext) (vectors [][]*big.Int, err error) {
+	if err = checkPack(ecd.ecd.params, pv.Pack); err != nil {
+		return nil, fmt.Errorf("cannot DecodeVectorNew: %w", err)
+	}
+
+	pack := pv.Pack
+	dim := ecd.ecd.params.Slots() / pack
+
+	msg := ecd.dcd.DecodeNew(pv.Value)
+
+	vectors = make([][]*big.Int, pack)
+	for l := range vectors {
+		vectors[l] = make([]*big.Int, dim)
+		for j := range vectors[l] {
+			vectors[l][j] = msg.Value[j*pack+l].BigInt()
+		}
+	}
+
+	return
+}
+
+// EncryptVectorNew encrypts the input vectors and returns the ciphertext.
+// It returns an error if the pack of pv does not divide the number of slots.
+func (enc *MatrixEncryptor) EncryptVectorNew(pv *VectorPlaintext) (cv *VectorCiphertext, err error) {
+	if err = checkPack(enc.ecd.ecd.params, pv.Pack); err != nil {
+		return nil, fmt.Errorf("cannot EncryptVectorNew: %w", err)
+	}
+
+	return &VectorCiphertext{Value: enc.enc.EncryptNew(pv.Value), Pack: pv.Pack}, nil
+}
+
+// DecryptVectorNew decrypts the input vectors and returns the plaintext.
+func (enc *MatrixEncryptor) DecryptVectorNew(cv *VectorCiphertext) (pv *VectorPlaintext, err error) {
+	if enc.dec == nil {
+		return nil, fmt.Errorf("cannot DecryptVectorNew: %w", ErrMissingSecretKey)
+	}
+
+	if cv.Value.Degree() != 1 {
+		return nil, fmt.Errorf("cannot DecryptVectorNew: %w", ErrInvalidDegree)
+	}
+
+	return &VectorPlaintext{Value: enc.dec.DecryptNew(cv.Value), Pack: cv.Pack}, nil
+}
+
+// MulVectorNew multiplies the matrices ctM by the vectors ctV and returns the result. See MulVector.
+func (eval *MatrixEvaluator) MulVectorNew(ctM *MatrixCiphertext, ctV *VectorCiphertext) (ctOut *VectorCiphertext, err error) {
+	if ctOut, err = NewVectorCiphertext(eval.eval.params, len(ctM.Value)); err != nil {
+		return nil, fmt.Errorf("cannot MulVectorNew: %w", err)
+	}
+
+	if err = eval.MulVector(ctM, ctV, ctOut); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// MulVector multiplies each of the Pack matrices of ctM by the corresponding vector of ctV and
+// writes the result on ctOut. ctM must be packed diagonally.
+//
+// The product is computed with the diagonal method, M * v = sum_i d_i * rot_i(v), where d_i is
+// the i-th diagonal of M and rot_i rotates the vectors by i entries, i.e. the slots by Pack * i.
+// The degree 2 products are accumulated before a single relinearization. The rotation keys
+// generated by GenRotationKeysForMatMul are used for the rotations.
+func (eval *MatrixEvaluator) MulVector(ctM *MatrixCiphertext, ctV, ctOut *VectorCiphertext) (err error) {
+	if err = eval.checkMatrixCiphertexts(ctM); err != nil {
+		return fmt.Errorf("cannot MulVector: %w", err)
+	}
+
+	if err = checkUniform(ctM); err != nil {
+		return fmt.Errorf("cannot MulVector: %w", err)
+	}
+
+	if !ctM.IsDiagonal {
+		return fmt.Errorf("cannot MulVector: %w: ctM must be diagonal", ErrEncodingMismatch)
+	}
+
+	if err = eval.checkVectors(ctM.Pack, ctV, ctOut); err != nil {
+		return fmt.Errorf("cannot MulVector: %w", err)
+	}
+
+	if eval.rlk == nil || len(eval.rlk.Keys) == 0 {
+		return fmt.Errorf("cannot MulVector: %w", ErrMissingRelinearizationKey)
+	}
+
+	pack := ctM.Pack
+	dim := len(ctM.Value)
+	if err = eval.checkMatMulRotationKeys(pack, dim); err != nil {
+		return fmt.Errorf("cannot MulVector: %w", err)
+	}
+
+	ringQ := eval.eval.params.RingQ()
+
+	acc := eval.poolTensor[0]
+	for i := 0; i < dim; i++ {
+		rotated := ctV.Value
+		if i != 0 {
+			eval.rotate(ctV.Value, eval.eval.params.GaloisElementForColumnRotationBy(uint64(pack*i)), eval.poolCt)
+			rotated = eval.poolCt
+		}
+
+		if i == 0 {
+			eval.eval.tensorAndRescale(ctM.Value[i].Ciphertext, rotated.Ciphertext, acc.Ciphertext)
+			continue
+		}
+
+		eval.eval.tensorAndRescale(ctM.Value[i].Ciphertext, rotated.Ciphertext, eval.poolTensor[1].Ciphertext)
+		for j := range acc.Value {
+			ringQ.Add(acc.Value[j], eval.poolTensor[1].Value[j], acc.Value[j])
+		}
+	}
+
+	eval.eval.relinearize(acc, eval.rlk, ctOut.Value)
+	ctOut.Pack = pack
+
+	return
+}
+
+// MulVectorPlainNew multiplies the plaintext matrices ptM by the vectors ctV and returns the result.
+// See MulVectorPlain.
+func (eval *MatrixEvaluator) MulVectorPlainNew(ptM *MatrixPlaintextMul, ctV *VectorCiphertext) (ctOut *VectorCiphertext, err error) {
+	if ctOut, err = NewVectorCiphertext(eval.eval.params, len(ptM.Value)); err != nil {
+		return nil, fmt.Errorf("cannot MulVectorPlainNew: %w", err)
+	}
+
+	if err = eval.MulVectorPlain(ptM, ctV, ctOut); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// MulVectorPlain multiplies each of the Pack plaintext matrices of ptM by the corresponding vector
+// of ctV and writes the result on ctOut. ptM must be packed diagonally.
+// The product is computed with the diagonal method as in MulVector, without relinearization.
+func (eval *MatrixEvaluator) MulVectorPlain(ptM *MatrixPlaintextMul, ctV, ctOut *VectorCiphertext) (err error) {
+	if err = eval.checkMatrixPlaintextMul(ptM); err != nil {
+		return fmt.Errorf("cannot MulVectorPlain: %w", err)
+	}
+
+	if !ptM.IsDiagonal {
+		return fmt.Errorf("cannot MulVectorPlain: %w: ptM must be diagonal", ErrEncodingMismatch)
+	}
+
+	if err = eval.checkVectors(ptM.Pack, ctV, ctOut); err != nil {
+		return fmt.Errorf("cannot MulVectorPlain: %w", err)
+	}
+
+	pack := ptM.Pack
+	dim := len(ptM.Value)
+	if err = eval.checkMatMulRotationKeys(pack, dim); err != nil {
+		return fmt.Errorf("cannot MulVectorPlain: %w", err)
+	}
+
+	ringQ := eval.eval.params.RingQ()
+
+	eval.poolCMul[0].Q.Zero()
+	eval.poolCMul[1].Q.Zero()
+
+	for i := 0; i < dim; i++ {
+		rotated := ctV.Value
+		if i != 0 {
+			eval.rotate(ctV.Value, eval.eval.params.GaloisElementForColumnRotationBy(uint64(pack*i)), eval.poolCt)
+			rotated = eval.poolCt
+		}
+
+		for j := 0; j < 2; j++ {
+			ringQ.NTT(rotated.Value[j], eval.poolRot[0].Q)
+			ringQ.MulCoeffsMontgomeryAndAdd(ptM.Value[i].Value, eval.poolRot[0].Q, eval.poolCMul[j].Q)
+		}
+	}
+
+	ringQ.InvNTT(eval.poolCMul[0].Q, ctOut.Value.Value[0])
+	ringQ.InvNTT(eval.poolCMul[1].Q, ctOut.Value.Value[1])
+	ctOut.Pack = pack
+
+	return
+}
+
+// checkVectors checks that the VectorCiphertexts pack pack vectors and are of degree 1.
+func (eval *MatrixEvaluator) checkVectors(pack int, cvs ...*VectorCiphertext) (err error) {
+	for _, cv := range cvs {
+		if cv.Pack != pack {
+			return fmt.Errorf("%w: the vectors and the matrices must have the same pack", ErrEncodingMismatch)
+		}
+
+		if cv.Value == nil || cv.Value.Degree() != 1 {
+			return ErrInvalidDegree
+		}
+	}
+	return
+}
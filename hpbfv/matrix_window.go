@@ -0,0 +1,121 @@
+package hpbfv
+
+import (
+	"fmt"
+
+	"hp-bfv/rlwe/ringqp"
+	"hp-bfv/utils"
+)
+
+// MulWindowedNew multiplies two matrices keeping only a window of lifted diagonals in memory and returns the result.
+// See MulWindowed.
+func (eval *MatrixEvaluator) MulWindowedNew(ctA, ctB *MatrixCiphertext, window int) (ctC *MatrixCiphertext, err error) {
+	if ctC, err = NewMatrixCiphertext(eval.eval.params, len(ctA.Value), true); err != nil {
+		return nil, fmt.Errorf("cannot MulWindowedNew: %w", err)
+	}
+
+	if err = eval.MulWindowed(ctA, ctB, ctC, window); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// MulWindowed multiplies two matrices as Mul, with the same result, but keeps only a window of lifted diagonals
+// in memory instead of the 2 * dim lifted diagonals of ctA and ctB.
+// The output diagonals are computed by blocks of window diagonals: for each block, the diagonals of ctA and ctB
+// are lifted by blocks of window and 2 * window - 1 diagonals, and the products are accumulated into window
+// accumulators. Hence MulWindowed uses about 5 * window lifted diagonals, against 2 * dim for Mul, at the cost
+// of about 3 * dim^2 / window lifts of diagonals, against 2 * dim for Mul.
+// ctC cannot be ctA or ctB, and the diagonals are computed sequentially, regardless of SetWorkers.
+func (eval *MatrixEvaluator) MulWindowed(ctA, ctB, ctC *MatrixCiphertext, window int) (err error) {
+	if err = eval.checkMatrixCiphertexts(ctA, ctB, ctC); err != nil {
+		return fmt.Errorf("cannot MulWindowed: %w", err)
+	}
+
+	if err = checkUniform(ctA, ctB); err != nil {
+		return fmt.Errorf("cannot MulWindowed: %w", err)
+	}
+
+	if !(ctA.IsDiagonal && !ctB.IsDiagonal && ctC.IsDiagonal) {
+		return fmt.Errorf("cannot MulWindowed: %w: ctA and ctC must be diagonal and ctB shifted diagonal", ErrEncodingMismatch)
+	}
+
+	pack := ctA.Pack
+	dim := len(ctA.Value)
+	if len(ctB.Value) != dim || len(ctC.Value) != dim {
+		return fmt.Errorf("cannot MulWindowed: %w: dimensions do not match", ErrEncodingMismatch)
+	}
+	if ctB.Pack != pack || ctC.Pack != pack {
+		return fmt.Errorf("cannot MulWindowed: %w: packs do not match", ErrEncodingMismatch)
+	}
+
+	if ctC == ctA || ctC == ctB {
+		return fmt.Errorf("cannot MulWindowed: %w: ctC cannot be ctA or ctB", ErrEncodingMismatch)
+	}
+
+	if window < 1 {
+		return fmt.Errorf("cannot MulWindowed: %w: window must be positive", ErrInvalidParameters)
+	}
+
+	if err = eval.checkMulKeys(pack, dim); err != nil {
+		return fmt.Errorf("cannot MulWindowed: %w", err)
+	}
+
+	params := eval.eval.params
+	window = utils.MinInt(window, dim)
+
+	aWin := newPreparedValue(params, window)
+	bWin := newPreparedValue(params, 2*window-1)
+	acc := make([][4]*ringqp.Poly, window)
+	for i := range acc {
+		acc[i] = [4]*ringqp.Poly{
+			NewQQMulPoly(params), NewQQMulPoly(params),
+			NewQQMulPoly(params), NewQQMulPoly(params),
+		}
+	}
+	reduce := make([]int, window)
+
+	galEl := func(i int) uint64 {
+		return params.GaloisElementForColumnRotationBy(uint64(pack * i))
+	}
+
+	for i0 := 0; i0 < dim; i0 += window {
+		nI := utils.MinInt(window, dim-i0)
+
+		for i := 0; i < nI; i++ {
+			zeroAccumulator(acc[i])
+			reduce[i] = 0
+		}
+
+		for j0 := 0; j0 < dim; j0 += window {
+			nJ := utils.MinInt(window, dim-j0)
+
+			for j := 0; j < nJ; j++ {
+				eval.fillAMul(ctA.Value[j0+j], aWin[j])
+			}
+
+			// bWin[t] = ctB[i - j] for t = (i - i0) - (j - j0) + nJ - 1
+			base := i0 - j0 - (nJ - 1)
+			for t := 0; t < nI+nJ-1; t++ {
+				eval.fillBMul(ctB.Value[((base+t)%dim+dim)%dim], bWin[t])
+			}
+
+			for i := 0; i < nI; i++ {
+				for j := 0; j < nJ; j++ {
+					eval.accumulateProduct(aWin[j], bWin[i-j+nJ-1], galEl(i0+i), acc[i], &reduce[i])
+				}
+			}
+		}
+
+		for i := 0; i < nI; i++ {
+			eval.finalizeDiagonal(acc[i], reduce[i], galEl(i0+i), ctC.Value[i0+i])
+		}
+	}
+
+	ctC.Pack = pack
+	ctC.IsDiagonal = true
+	ctC.Layout = nil
+
+	return
+}
@@ -0,0 +1,278 @@
+package hpbfv
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// montgomery implements the arithmetic modulo an odd modulus m in the Montgomery domain, on fixed-width
+// elements of n 64-bit words in little-endian order, with R = 2^(64n) > m. Products of two elements out of
+// the Montgomery domain are reduced with a Barrett reduction instead, see mulMod.
+// A montgomery is read-only and can be shared between goroutines; the temporary buffers of its methods
+// are held by a montgomeryBuffer.
+type montgomery struct {
+	n int
+
+	m    []uint64
+	mInv uint64   // -m^-1 mod 2^64
+	r2   []uint64 // R^2 mod m
+	mu   []uint64 // floor(R^2 / m), n + 1 words
+
+	mBig *big.Int
+}
+
+// montgomeryBuffer holds the temporary buffers of the methods of a montgomery.
+type montgomeryBuffer struct {
+	t     []uint64 // n + 2 words
+	w     []uint64 // n words
+	p     []uint64 // 2n words
+	q     []uint64 // 2n + 2 words
+	s     []uint64 // n + 1 words
+	bytes []byte   // 8n bytes
+	x     *big.Int
+}
+
+// newMontgomery returns the montgomery arithmetic modulo the odd modulus m.
+func newMontgomery(m *big.Int) (mont *montgomery) {
+	if m.Sign() <= 0 || m.Bit(0) == 0 {
+		panic("cannot newMontgomery: modulus must be positive and odd")
+	}
+
+	mont = new(montgomery)
+	mont.n = (m.BitLen() + 63) / 64
+	mont.mBig = new(big.Int).Set(m)
+
+	mont.m = make([]uint64, mont.n)
+	bigToWords(m, mont.m)
+
+	// Newton iteration for m^-1 mod 2^64, each step doubling the number of correct bits
+	inv := mont.m[0]
+	for i := 0; i < 5; i++ {
+		inv *= 2 - mont.m[0]*inv
+	}
+	mont.mInv = -inv
+
+	r2 := new(big.Int).Lsh(big.NewInt(1), uint(128*mont.n))
+	r2.Mod(r2, m)
+	mont.r2 = make([]uint64, mont.n)
+	bigToWords(r2, mont.r2)
+
+	mu := new(big.Int).Lsh(big.NewInt(1), uint(128*mont.n))
+	mu.Quo(mu, m)
+	mont.mu = make([]uint64, mont.n+1)
+	bigToWords(mu, mont.mu)
+
+	return
+}
+
+// newBuffer allocates the temporary buffers of the methods of mont.
+func (mont *montgomery) newBuffer() *montgomeryBuffer {
+	return &montgomeryBuffer{
+		t:     make([]uint64, mont.n+2),
+		w:     make([]uint64, mont.n),
+		p:     make([]uint64, 2*mont.n),
+		q:     make([]uint64, 2*mont.n+2),
+		s:     make([]uint64, mont.n+1),
+		bytes: make([]byte, 8*mont.n),
+		x:     new(big.Int),
+	}
+}
+
+// newElements allocates count elements of n words.
+func (mont *montgomery) newElements(count int) (el [][]uint64) {
+	el = make([][]uint64, count)
+	words := make([]uint64, count*mont.n)
+	for i := range el {
+		el[i] = words[i*mont.n : (i+1)*mont.n : (i+1)*mont.n]
+	}
+	return
+}
+
+// mul writes a * b * R^-1 mod m on c, for a and b in [0, m). c may alias a or b.
+func (mont *montgomery) mul(a, b, c []uint64, buf *montgomeryBuffer) {
+	n := mont.n
+	m := mont.m
+	t := buf.t
+
+	for i := range t {
+		t[i] = 0
+	}
+
+	// CIOS Montgomery multiplication
+	var carry, hi, lo, mi uint64
+	for i := 0; i < n; i++ {
+		bi := b[i]
+
+		carry = 0
+		for j := 0; j < n; j++ {
+			hi, lo = bits.Mul64(a[j], bi)
+			lo, hi = addWide(lo, hi, t[j])
+			lo, hi = addWide(lo, hi, carry)
+			t[j], carry = lo, hi
+		}
+		t[n], carry = bits.Add64(t[n], carry, 0)
+		t[n+1] = carry
+
+		mi = t[0] * mont.mInv
+		hi, lo = bits.Mul64(mi, m[0])
+		_, carry = bits.Add64(lo, t[0], 0)
+		carry += hi
+		for j := 1; j < n; j++ {
+			hi, lo = bits.Mul64(mi, m[j])
+			lo, hi = addWide(lo, hi, t[j])
+			lo, hi = addWide(lo, hi, carry)
+			t[j-1], carry = lo, hi
+		}
+		t[n-1], carry = bits.Add64(t[n], carry, 0)
+		t[n] = t[n+1] + carry
+	}
+
+	// t < 2m
+	var borrow uint64
+	for j := 0; j < n; j++ {
+		c[j], borrow = bits.Sub64(t[j], m[j], borrow)
+	}
+	if _, borrow = bits.Sub64(t[n], 0, borrow); borrow != 0 {
+		copy(c, t[:n])
+	}
+}
+
+// mulMod writes a * b mod m on c, for a and b in [0, m) out of the Montgomery domain. c may alias a or b.
+// It reduces the product with a Barrett reduction (HAC, Algorithm 14.42), which costs about as much as
+// a single Montgomery multiplication, instead of two Montgomery multiplications by b and by R^2.
+func (mont *montgomery) mulMod(a, b, c []uint64, buf *montgomeryBuffer) {
+	n := mont.n
+	p, q, r, s := buf.p, buf.q, buf.t[:n+1], buf.s
+
+	mulWords(a, b, p)
+
+	// q3 = floor(floor(p / 2^(64(n-1))) * mu / 2^(64(n+1))), which is smaller than R
+	mulWords(p[n-1:], mont.mu, q)
+	q3 := q[n+1 : 2*n+1]
+
+	// r = p - q3 * m mod 2^(64(n+1)), which is in [0, 3m)
+	mulWords(q3, mont.m, s)
+	var borrow uint64
+	for j := 0; j <= n; j++ {
+		r[j], borrow = bits.Sub64(p[j], s[j], borrow)
+	}
+
+	for r[n] != 0 || !lessWords(r[:n], mont.m) {
+		borrow = 0
+		for j := 0; j < n; j++ {
+			r[j], borrow = bits.Sub64(r[j], mont.m[j], borrow)
+		}
+		r[n] -= borrow
+	}
+
+	copy(c, r[:n])
+}
+
+// mulWords writes x * y mod 2^(64 len(z)) on z, of at most len(x) + len(y) words.
+func mulWords(x, y, z []uint64) {
+	for i := range z {
+		z[i] = 0
+	}
+
+	var carry, hi, lo uint64
+	for i := 0; i < len(x) && i < len(z); i++ {
+		xi := x[i]
+
+		carry = 0
+		j := 0
+		for ; j < len(y) && i+j < len(z); j++ {
+			hi, lo = bits.Mul64(xi, y[j])
+			lo, hi = addWide(lo, hi, z[i+j])
+			lo, hi = addWide(lo, hi, carry)
+			z[i+j], carry = lo, hi
+		}
+		if i+j < len(z) {
+			z[i+j] = carry
+		}
+	}
+}
+
+// add writes a + b mod m on c, for a and b in [0, m). c may alias a or b.
+func (mont *montgomery) add(a, b, c []uint64, buf *montgomeryBuffer) {
+	n := mont.n
+	w := buf.w
+
+	var carry, borrow uint64
+	for j := 0; j < n; j++ {
+		c[j], carry = bits.Add64(a[j], b[j], carry)
+	}
+	for j := 0; j < n; j++ {
+		w[j], borrow = bits.Sub64(c[j], mont.m[j], borrow)
+	}
+	if carry != 0 || borrow == 0 {
+		copy(c, w)
+	}
+}
+
+// sub writes a - b mod m on c, for a and b in [0, m). c may alias a or b.
+func (mont *montgomery) sub(a, b, c []uint64) {
+	n := mont.n
+
+	var carry, borrow uint64
+	for j := 0; j < n; j++ {
+		c[j], borrow = bits.Sub64(a[j], b[j], borrow)
+	}
+	if borrow != 0 {
+		for j := 0; j < n; j++ {
+			c[j], carry = bits.Add64(c[j], mont.m[j], carry)
+		}
+	}
+}
+
+// setBig writes x * R mod m on c, for any x.
+func (mont *montgomery) setBig(x *big.Int, c []uint64, buf *montgomeryBuffer) {
+	mont.reduceBig(x, buf.w, buf)
+	mont.mul(buf.w, mont.r2, c, buf)
+}
+
+// reduceBig writes x mod m on c, for any x.
+func (mont *montgomery) reduceBig(x *big.Int, c []uint64, buf *montgomeryBuffer) {
+	if x.Sign() < 0 || x.Cmp(mont.mBig) >= 0 {
+		x = buf.x.Mod(x, mont.mBig)
+	}
+	bigToWords(x, c)
+}
+
+// bigToWords writes the non-negative x, of at most 64 * len(w) bits, on w in little-endian order.
+func bigToWords(x *big.Int, w []uint64) {
+	for i := range w {
+		w[i] = 0
+	}
+	for i, word := range x.Bits() {
+		w[i*bits.UintSize/64] |= uint64(word) << (uint(i*bits.UintSize) % 64)
+	}
+}
+
+// lessWords returns true if the integer whose little-endian words are a is smaller than the one of b,
+// for a and b of the same length.
+func lessWords(a, b []uint64) bool {
+	for j := len(a) - 1; j >= 0; j-- {
+		if a[j] != b[j] {
+			return a[j] < b[j]
+		}
+	}
+	return false
+}
+
+// wordsToBig writes on x the integer whose little-endian words are w, using the buffer b of 8 * len(w) bytes.
+func wordsToBig(w []uint64, x *big.Int, b []byte) {
+	n := len(w)
+	for i := 0; i < n; i++ {
+		for j := 0; j < 8; j++ {
+			b[8*(n-1-i)+7-j] = byte(w[i] >> (8 * uint(j)))
+		}
+	}
+	x.SetBytes(b)
+}
+
+// addWide returns (hi, lo) + x as (lo, hi), assuming that the sum does not overflow 128 bits.
+func addWide(lo, hi, x uint64) (uint64, uint64) {
+	var carry uint64
+	lo, carry = bits.Add64(lo, x, 0)
+	return lo, hi + carry
+}
@@ -0,0 +1,132 @@
+package hpbfv
+
+import (
+	"math/big"
+)
+
+// slotsNTT is the iterative radix-2 NTT over Z_T on the slots of a Message, on the fixed-width representation
+// of Z_T. Its twiddle factors are precomputed in the Montgomery domain, so that the values are transformed
+// in place of their representatives in [0, T), each product of a butterfly costs a single Montgomery
+// multiplication, and the transform does not allocate.
+// A slotsNTT is read-only and can be shared between goroutines; the temporary buffers of its transforms
+// are held by a slotsNTTBuffer.
+type slotsNTT struct {
+	mont  *montgomery
+	slots int
+
+	// twiddles[h-1+k] = roots[k]^(slots/(2h)) * R mod T, for the stage of half-size h and 0 <= k < h
+	twiddles [][]uint64
+
+	// bitRev[i] is the bit reversal of i on log2(slots) bits
+	bitRev []int
+}
+
+// slotsNTTBuffer holds the temporary buffers of the transforms of a slotsNTT.
+type slotsNTTBuffer struct {
+	values [][]uint64
+	v      []uint64
+	mont   *montgomeryBuffer
+}
+
+// newSlotsNTT precomputes the twiddle factors of the NTT whose butterflies of size 2h multiply by
+// roots[k]^(slots/(2h)), for the slots roots of Z_T.
+func newSlotsNTT(params Parameters, roots []*big.Int) (ntt *slotsNTT) {
+	T := params.T()
+	slots := params.Slots()
+
+	ntt = new(slotsNTT)
+	ntt.mont = newMontgomery(T)
+	ntt.slots = slots
+
+	buf := ntt.mont.newBuffer()
+	ntt.twiddles = ntt.mont.newElements(slots)
+	tw := new(big.Int)
+	for h := 1; h < slots; h <<= 1 {
+		step := big.NewInt(int64(slots / (2 * h)))
+		for k := 0; k < h; k++ {
+			ntt.mont.setBig(tw.Exp(roots[k], step, T), ntt.twiddles[h-1+k], buf)
+		}
+	}
+
+	ntt.bitRev = make([]int, slots)
+	j := 0
+	for i := 1; i < slots; i++ {
+		bit := (slots >> 1)
+		for j >= bit {
+			j -= bit
+			bit >>= 1
+		}
+		j += bit
+		ntt.bitRev[i] = j
+	}
+
+	return
+}
+
+// newBuffer allocates the temporary buffers of the transforms of ntt.
+func (ntt *slotsNTT) newBuffer() *slotsNTTBuffer {
+	return &slotsNTTBuffer{
+		values: ntt.mont.newElements(ntt.slots),
+		v:      make([]uint64, ntt.mont.n),
+		mont:   ntt.mont.newBuffer(),
+	}
+}
+
+// load writes the Elements of v, in the order given by indexMap if not nil, on buf.
+func (ntt *slotsNTT) load(v []Element, indexMap []int, buf *slotsNTTBuffer) {
+	for i := 0; i < ntt.slots; i++ {
+		j := i
+		if indexMap != nil {
+			j = indexMap[i]
+		}
+		copy(buf.values[i], v[j])
+	}
+}
+
+// loadBig writes x[i] mod T on buf.
+func (ntt *slotsNTT) loadBig(x []*big.Int, buf *slotsNTTBuffer) {
+	for i := 0; i < ntt.slots; i++ {
+		ntt.mont.reduceBig(x[i], buf.values[i], buf.mont)
+	}
+}
+
+// transform applies in place the bit reversal and the butterflies of the NTT on the values of buf.
+func (ntt *slotsNTT) transform(buf *slotsNTTBuffer) {
+	mont := ntt.mont
+	values := buf.values
+	v := buf.v
+
+	for i, j := range ntt.bitRev {
+		if i < j {
+			values[i], values[j] = values[j], values[i]
+		}
+	}
+
+	for h := 1; h < ntt.slots; h <<= 1 {
+		twiddles := ntt.twiddles[h-1 : 2*h-1]
+		for j := 0; j < ntt.slots; j += 2 * h {
+			for k := 0; k < h; k++ {
+				u, w := values[j+k], values[j+k+h]
+				mont.mul(w, twiddles[k], v, buf.mont)
+				mont.sub(u, v, w)
+				mont.add(u, v, u, buf.mont)
+			}
+		}
+	}
+}
+
+// store writes the values of buf on v.
+func (ntt *slotsNTT) store(v []Element, buf *slotsNTTBuffer) {
+	for i := 0; i < ntt.slots; i++ {
+		copy(v[i], buf.values[i])
+	}
+}
+
+// storeBig writes the values of buf multiplied by scale[i] on x[i].
+// The scaling factors are in the Montgomery domain, see montgomery.setBig.
+func (ntt *slotsNTT) storeBig(x []*big.Int, scale [][]uint64, buf *slotsNTTBuffer) {
+	for i := 0; i < ntt.slots; i++ {
+		ntt.mont.mul(buf.values[i], scale[i], buf.v, buf.mont)
+		wordsToBig(buf.v, x[i], buf.mont.bytes)
+	}
+}
@@ -1,10 +1,15 @@
 package hpbfv
 
 import (
+	"encoding/json"
+	"fmt"
 	"math/big"
 
 	"hp-bfv/ring"
 	"hp-bfv/rlwe"
+	"hp-bfv/utils"
+
+	"golang.org/x/crypto/blake2b"
 )
 
 type ParametersLiteral struct {
@@ -32,40 +37,254 @@ type Parameters struct {
 	t        *big.Int //plaint text modulus
 }
 
-func NewParametersFromLiteral(pl ParametersLiteral) (params Parameters) {
+// NewParametersFromLiteral instantiates a set of HP-BFV parameters from a ParametersLiteral specification.
+// It returns the empty parameters Parameters{} and a non-nil error if the specified parameters are invalid.
+func NewParametersFromLiteral(pl ParametersLiteral) (params Parameters, err error) {
 	rlweParams, err := rlwe.NewParametersFromLiteral(rlwe.ParametersLiteral{LogN: pl.LogN, Q: pl.Q, P: pl.P, LogQ: pl.LogQ, LogP: pl.LogP, H: pl.H, Sigma: pl.Sigma})
 	if err != nil {
-		panic("cannot NewParametersFromLiteral: rlweParams cannot be generated")
+		return Parameters{}, fmt.Errorf("cannot NewParametersFromLiteral: %w: rlweParams cannot be generated: %s", ErrInvalidParameters, err)
+	}
+
+	if params, err = newParameters(rlweParams, pl.QMul, pl.B, pl.D, pl.G); err != nil {
+		return Parameters{}, fmt.Errorf("cannot NewParametersFromLiteral: %w", err)
+	}
+
+	return
+}
+
+// newParameters creates the HP-BFV parameters from the RLWE parameters and the plaintext parameters,
+// and checks that they are consistent.
+func newParameters(rlweParams rlwe.Parameters, qMul []uint64, b *big.Int, d uint64, g *big.Int) (params Parameters, err error) {
+	N := rlweParams.N()
+
+	if b == nil || g == nil {
+		return Parameters{}, fmt.Errorf("%w: B and G must be set", ErrInvalidParameters)
+	}
+
+	if d == 0 || uint64(N)%d != 0 {
+		return Parameters{}, fmt.Errorf("%w: D must divide N", ErrInvalidParameters)
 	}
 
-	N := (1 << pl.LogN)
-	K := N / int(pl.D)
+	K := N / int(d)
 
-	ringQMul, err := ring.NewRing(N, pl.QMul)
+	ringQMul, err := ring.NewRing(N, qMul)
 	if err != nil {
-		panic("cannot NewParametersFromLiteral: ring QMul cannot be generated")
+		return Parameters{}, fmt.Errorf("%w: ring QMul cannot be generated", ErrInvalidParameters)
 	}
 
 	params.Parameters = rlweParams
 	params.ringQMul = ringQMul
-	params.b = new(big.Int).Set(pl.B)
-	params.d = pl.D
-	params.g = new(big.Int).Set(pl.G)
-	params.t = new(big.Int).Exp(pl.B, big.NewInt(int64(K)), nil)
+	params.b = new(big.Int).Set(b)
+	params.d = d
+	params.g = new(big.Int).Set(g)
+	params.t = new(big.Int).Exp(b, big.NewInt(int64(K)), nil)
 	params.t.Add(params.t, big.NewInt(1))
 
 	if !params.t.ProbablyPrime(0) {
-		panic("cannot NewParametersFromLiteral: T is not a prime")
+		return Parameters{}, fmt.Errorf("%w: T is not a prime", ErrInvalidParameters)
 	}
 
-	BK := new(big.Int).Exp(pl.B, big.NewInt(int64(K)), nil)
+	BK := new(big.Int).Exp(b, big.NewInt(int64(K)), nil)
 	if BK.Mod(BK, big.NewInt(int64(2*N))).Int64() != 0 {
-		panic("cannot NewParametersFromLiteral: 2N does not divide b^k")
+		return Parameters{}, fmt.Errorf("%w: 2N does not divide b^k", ErrInvalidParameters)
 	}
 
 	return
 }
 
+// ParametersLiteral returns the ParametersLiteral of the target Parameters.
+func (p Parameters) ParametersLiteral() ParametersLiteral {
+	return ParametersLiteral{
+		LogN:  p.LogN(),
+		Q:     p.Q(),
+		QMul:  p.QMul(),
+		P:     p.P(),
+		H:     p.HammingWeight(),
+		Sigma: p.Sigma(),
+		B:     p.B(),
+		D:     p.D(),
+		G:     p.G(),
+	}
+}
+
+// QMul returns a new slice with the moduli of the extended basis for multiplication.
+func (p Parameters) QMul() []uint64 {
+	qMul := make([]uint64, len(p.ringQMul.Modulus))
+	copy(qMul, p.ringQMul.Modulus)
+	return qMul
+}
+
+// Equals compares two sets of parameters for equality.
+func (p Parameters) Equals(other Parameters) bool {
+	if p.ringQMul == nil || other.ringQMul == nil {
+		return p.ringQMul == other.ringQMul && p.Parameters.Equals(other.Parameters)
+	}
+
+	res := p.Parameters.Equals(other.Parameters)
+	res = res && utils.EqualSliceUint64(p.ringQMul.Modulus, other.ringQMul.Modulus)
+	res = res && (p.b.Cmp(other.b) == 0)
+	res = res && (p.d == other.d)
+	res = res && (p.g.Cmp(other.g) == 0)
+	return res
+}
+
+// MarshalBinary returns a []byte representation of the parameter set.
+func (p Parameters) MarshalBinary() ([]byte, error) {
+	if p.ringQMul == nil { // p is the zero value
+		return []byte{}, nil
+	}
+
+	rlweBytes, err := p.Parameters.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	bBytes, gBytes := p.b.Bytes(), p.g.Bytes()
+
+	// 8 bytes : len(rlweBytes)
+	// len(rlweBytes) bytes : rlwe.Parameters
+	// 1 byte : #QMul
+	// 8 * (#QMul) : QMul
+	// 8 bytes : d
+	// 8 bytes + len(bBytes) : b
+	// 8 bytes + len(gBytes) : g
+	buf := utils.NewBuffer(make([]byte, 0, p.MarshalBinarySize()))
+	buf.WriteUint64(uint64(len(rlweBytes)))
+	buf.WriteUint8Slice(rlweBytes)
+	buf.WriteUint8(uint8(len(p.ringQMul.Modulus)))
+	buf.WriteUint64Slice(p.ringQMul.Modulus)
+	buf.WriteUint64(p.d)
+	buf.WriteUint64(uint64(len(bBytes)))
+	buf.WriteUint8Slice(bBytes)
+	buf.WriteUint64(uint64(len(gBytes)))
+	buf.WriteUint8Slice(gBytes)
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a []byte into a parameter set struct.
+func (p *Parameters) UnmarshalBinary(data []byte) (err error) {
+	r := data
+
+	// next returns the next n bytes of data, or nil if data is too short.
+	next := func(n uint64) (b []byte) {
+		if uint64(len(r)) < n {
+			return nil
+		}
+		b, r = r[:n], r[n:]
+		return
+	}
+
+	nextUint64 := func() (v uint64, ok bool) {
+		b := next(8)
+		if b == nil {
+			return 0, false
+		}
+		return utils.NewBuffer(b).ReadUint64(), true
+	}
+
+	invalid := fmt.Errorf("cannot UnmarshalBinary: %w: invalid hpbfv.Parameters serialization", ErrInvalidParameters)
+
+	size, ok := nextUint64()
+	if !ok {
+		return invalid
+	}
+
+	rlweBytes := next(size)
+	if rlweBytes == nil {
+		return invalid
+	}
+
+	var rlweParams rlwe.Parameters
+	if err = rlweParams.UnmarshalBinary(rlweBytes); err != nil {
+		return err
+	}
+
+	lenQMul := next(1)
+	if lenQMul == nil {
+		return invalid
+	}
+
+	qMul := make([]uint64, lenQMul[0])
+	for i := range qMul {
+		if qMul[i], ok = nextUint64(); !ok {
+			return invalid
+		}
+	}
+
+	d, ok := nextUint64()
+	if !ok {
+		return invalid
+	}
+
+	var bg [2]*big.Int
+	for i := range bg {
+		if size, ok = nextUint64(); !ok {
+			return invalid
+		}
+
+		v := next(size)
+		if v == nil {
+			return invalid
+		}
+
+		bg[i] = new(big.Int).SetBytes(v)
+	}
+
+	if len(r) != 0 {
+		return invalid
+	}
+
+	params, err := newParameters(rlweParams, qMul, bg[0], d, bg[1])
+	if err != nil {
+		return fmt.Errorf("cannot UnmarshalBinary: %w", err)
+	}
+
+	*p = params
+	return nil
+}
+
+// MarshalBinarySize returns the length of the []byte encoding of the receiver.
+func (p Parameters) MarshalBinarySize() int {
+	if p.ringQMul == nil {
+		return 0
+	}
+	return 8 + p.Parameters.MarshalBinarySize() + 1 + 8*len(p.ringQMul.Modulus) + 8 + 8 + len(p.b.Bytes()) + 8 + len(p.g.Bytes())
+}
+
+// MarshalJSON returns a JSON representation of this parameter set. See `Marshal` from the `encoding/json` package.
+func (p Parameters) MarshalJSON() ([]byte, error) {
+	paramsLit := p.ParametersLiteral()
+	return json.Marshal(&paramsLit)
+}
+
+// UnmarshalJSON reads a JSON representation of a parameter set into the receiver Parameter. See `Unmarshal` from the `encoding/json` package.
+func (p *Parameters) UnmarshalJSON(data []byte) (err error) {
+	var pl ParametersLiteral
+	if err = json.Unmarshal(data, &pl); err != nil {
+		return err
+	}
+
+	params, err := NewParametersFromLiteral(pl)
+	if err != nil {
+		return err
+	}
+
+	*p = params
+	return nil
+}
+
+// Fingerprint returns a stable digest of the parameter set, which can be included in protocol
+// transcripts to check that all the parties use the same parameters.
+// It returns an error if the parameters cannot be marshaled.
+func (p Parameters) Fingerprint() (fingerprint [32]byte, err error) {
+	data, err := p.MarshalBinary()
+	if err != nil {
+		return fingerprint, fmt.Errorf("cannot Fingerprint: %w", err)
+	}
+	return blake2b.Sum256(data), nil
+}
+
 func (p Parameters) RingQMul() *ring.Ring {
 	return p.ringQMul
 }
@@ -77,6 +296,40 @@ func (p Parameters) GaloisElementForColumnRotationBy(rotidx uint64) uint64 {
 	return ret
 }
 
+// GaloisElementsForMatMul returns the Galois elements of the rotations used by the
+// multiplication of dim x dim matrices, i.e. the rotations by multiples of Slots()/dim.
+func (p Parameters) GaloisElementsForMatMul(dim int) (galEls []uint64) {
+	pack := p.Slots() / dim
+	galEls = make([]uint64, 0, dim)
+	for k := 0; k < p.Slots(); k += pack {
+		galEls = append(galEls, p.GaloisElementForColumnRotationBy(uint64(k)))
+	}
+	return
+}
+
+// GaloisElementsForTranspose returns the Galois elements of the rotations used by the
+// transposition of dim x dim matrices, i.e. the non-trivial rotations by multiples of Slots()/dim.
+func (p Parameters) GaloisElementsForTranspose(dim int) (galEls []uint64) {
+	return p.GaloisElementsForMatMul(dim)[1:]
+}
+
+// GaloisElementsForMatMulBSGS returns the Galois elements of the rotations used by the baby-step
+// giant-step multiplication of dim x dim matrices with babySteps baby steps, i.e. the rotations by
+// pack * b for 0 < b < babySteps and by pack * g * babySteps for 0 <= g < ceil(dim / babySteps),
+// where pack = Slots()/dim.
+func (p Parameters) GaloisElementsForMatMulBSGS(dim, babySteps int) (galEls []uint64) {
+	pack := p.Slots() / dim
+	giantSteps := (dim + babySteps - 1) / babySteps
+	galEls = make([]uint64, 0, babySteps+giantSteps-1)
+	for b := 1; b < babySteps; b++ {
+		galEls = append(galEls, p.GaloisElementForColumnRotationBy(uint64(pack*b)))
+	}
+	for g := 0; g < giantSteps; g++ {
+		galEls = append(galEls, p.GaloisElementForColumnRotationBy(uint64(pack*g*babySteps)))
+	}
+	return
+}
+
 func (p Parameters) Slots() int {
 	return int(p.d)
 }
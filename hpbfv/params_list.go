@@ -267,6 +267,272 @@ var (
 		G: MustBigFromDecimal("13289078263368535010350719491824534628404827374597126975388693711018284879396957772210874852435860862184279107707"), // 3^235
 	}
 
+	// The sets with the suffix P define a special modulus P for hybrid key switching. P is taken out of
+	// the Q of the set without the suffix, so that both have the same logQP, hence the same security.
+	HPN14D13T128P = ParametersLiteral{
+		LogN: 14,
+
+		Q: []uint64{
+			0x1fffffffffe10001, 0x1fffffffffe00001,
+			0x1fffffffffdd0001, 0x1fffffffffd08001,
+			0x1fffffffffcf8001, 0x1fffffffffc80001,
+		}, // 61 * 6 = 366, 366 + 61 = 427
+		QMul: []uint64{
+			0x1fffffffffab0001, 0x1fffffffffa10001,
+			0x1fffffffff998001, 0x1fffffffff978001,
+			0x1fffffffff8a8001, 0x1fffffffff7c8001,
+		},
+
+		P: []uint64{0x1fffffffff500001}, // 61
+
+		Sigma: rlwe.DefaultSigma,
+
+		B: MustBigFromDecimal("18446744073709548544"), // 2^64 - 3072
+		D: 1 << 13,
+		G: big.NewInt(27), // 3^3
+	}
+
+	HPN14D12T256P = ParametersLiteral{
+		LogN: 14,
+
+		Q: []uint64{
+			0x1fffffffffe10001, 0x1fffffffffe00001,
+			0x1fffffffffdd0001, 0x1fffffffffd08001,
+			0x1fffffffffcf8001, 0x1fffffffffc80001,
+		}, // 61 * 6 = 366, 366 + 61 = 427
+		QMul: []uint64{
+			0x1fffffffffab0001, 0x1fffffffffa10001,
+			0x1fffffffff998001, 0x1fffffffff978001,
+			0x1fffffffff8a8001, 0x1fffffffff7c8001,
+		},
+
+		P: []uint64{0x1fffffffff500001}, // 61
+
+		Sigma: rlwe.DefaultSigma,
+
+		B: MustBigFromDecimal("18446744073709551552"), // 2^64 - 64
+		D: 1 << 12,
+		G: big.NewInt(48828125), // 5^11
+	}
+
+	HPN14D11T512P = ParametersLiteral{
+		LogN: 14,
+
+		Q: []uint64{
+			0x1fffffffffe10001, 0x1fffffffffe00001,
+			0x1fffffffffdd0001, 0x1fffffffffd08001,
+			0x1fffffffffcf8001, 0x1fffffffffc80001,
+		}, // 61 * 6 = 366, 366 + 61 = 427
+		QMul: []uint64{
+			0x1fffffffffab0001, 0x1fffffffffa10001,
+			0x1fffffffff998001, 0x1fffffffff978001,
+			0x1fffffffff8a8001, 0x1fffffffff7c8001,
+		},
+
+		P: []uint64{0x1fffffffff500001}, // 61
+
+		Sigma: rlwe.DefaultSigma,
+
+		B: MustBigFromDecimal("18446744073709551188"), // 2^64 - 428
+		D: 1 << 11,
+		G: big.NewInt(27), // 3^3
+	}
+
+	HPN14D10T1024P = ParametersLiteral{
+		LogN: 14,
+
+		Q: []uint64{
+			0x1fffffffffe10001, 0x1fffffffffe00001,
+			0x1fffffffffdd0001, 0x1fffffffffd08001,
+			0x1fffffffffcf8001, 0x1fffffffffc80001,
+		}, // 61 * 6 = 366, 366 + 61 = 427
+		QMul: []uint64{
+			0x1fffffffffab0001, 0x1fffffffffa10001,
+			0x1fffffffff998001, 0x1fffffffff978001,
+			0x1fffffffff8a8001, 0x1fffffffff7c8001,
+		},
+
+		P: []uint64{0x1fffffffff500001}, // 61
+
+		Sigma: rlwe.DefaultSigma,
+
+		B: MustBigFromDecimal("18446744073709551608"), // 2^64 - 8
+		D: 1 << 10,
+		G: big.NewInt(7625597484987), // 3^27
+	}
+
+	HPN14D9T2048P = ParametersLiteral{
+		LogN: 14,
+
+		Q: []uint64{
+			0x1fffffffffe10001, 0x1fffffffffe00001,
+			0x1fffffffffdd0001, 0x1fffffffffd08001,
+			0x1fffffffffcf8001, 0x1fffffffffc80001,
+		}, // 61 * 6 = 366, 366 + 61 = 427
+		QMul: []uint64{
+			0x1fffffffffab0001, 0x1fffffffffa10001,
+			0x1fffffffff998001, 0x1fffffffff978001,
+			0x1fffffffff8a8001, 0x1fffffffff7c8001,
+		},
+
+		P: []uint64{0x1fffffffff500001}, // 61
+
+		Sigma: rlwe.DefaultSigma,
+
+		B: MustBigFromDecimal("18446744073709551594"), // 2^64 - 22
+		D: 1 << 9,
+		G: big.NewInt(5), // 5^1
+	}
+
+	HPN14D8T4096P = ParametersLiteral{
+		LogN: 14,
+
+		Q: []uint64{
+			0x1fffffffffe10001, 0x1fffffffffe00001,
+			0x1fffffffffdd0001, 0x1fffffffffd08001,
+			0x1fffffffffcf8001, 0x1fffffffffc80001,
+		}, // 61 * 6 = 366, 366 + 61 = 427
+		QMul: []uint64{
+			0x1fffffffffab0001, 0x1fffffffffa10001,
+			0x1fffffffff998001, 0x1fffffffff978001,
+			0x1fffffffff8a8001, 0x1fffffffff7c8001,
+		},
+
+		P: []uint64{0x1fffffffff500001}, // 61
+
+		Sigma: rlwe.DefaultSigma,
+
+		B: MustBigFromDecimal("18446744073709551560"), // 2^64 - 56
+		D: 1 << 8,
+		G: MustBigFromDecimal("328256967394537077627"), // 3^43
+	}
+
+	HPN13D10T128P = ParametersLiteral{
+		LogN: 13,
+
+		Q: []uint64{
+			0x1fffffffffe10001, 0x1fffffffffe00001,
+			0x1fffffffffdd0001,
+		}, // 61 * 3 = 183, 183 + 61 = 244
+		QMul: []uint64{
+			0x1fffffffffab0001, 0x1fffffffffa10001,
+			0x1fffffffff998001,
+		},
+
+		P: []uint64{0x1fffffffff500001}, // 61
+
+		Sigma: rlwe.DefaultSigma,
+
+		B: big.NewInt(65340), // 2^16 - 196
+		D: 1 << 10,
+		G: big.NewInt(823543), // 7^7
+	}
+
+	HPN13D9T256P = ParametersLiteral{
+		LogN: 13,
+
+		Q: []uint64{
+			0x1fffffffffe10001, 0x1fffffffffe00001,
+			0x1fffffffffdd0001,
+		}, // 61 * 3 = 183, 183 + 61 = 244
+		QMul: []uint64{
+			0x1fffffffffab0001, 0x1fffffffffa10001,
+			0x1fffffffff998001,
+		},
+
+		P: []uint64{0x1fffffffff500001}, // 61
+
+		Sigma: rlwe.DefaultSigma,
+
+		B: big.NewInt(65514), // 2^16 - 22
+		D: 1 << 9,
+		G: big.NewInt(762939453125), // 5^17
+	}
+
+	HPN13D8T512P = ParametersLiteral{
+		LogN: 13,
+
+		Q: []uint64{
+			0x1fffffffffe10001, 0x1fffffffffe00001,
+			0x1fffffffffdd0001,
+		}, // 61 * 3 = 183, 183 + 61 = 244
+		QMul: []uint64{
+			0x1fffffffffab0001, 0x1fffffffffa10001,
+			0x1fffffffff998001,
+		},
+
+		P: []uint64{0x1fffffffff500001}, // 61
+
+		Sigma: rlwe.DefaultSigma,
+
+		B: big.NewInt(65464), // 2^16 - 72
+		D: 1 << 8,
+		G: big.NewInt(5), // 5
+	}
+
+	HPN13D7T1024P = ParametersLiteral{
+		LogN: 13,
+
+		Q: []uint64{
+			0x1fffffffffe10001, 0x1fffffffffe00001,
+			0x1fffffffffdd0001,
+		}, // 61 * 3 = 183, 183 + 61 = 244
+		QMul: []uint64{
+			0x1fffffffffab0001, 0x1fffffffffa10001,
+			0x1fffffffff998001,
+		},
+
+		P: []uint64{0x1fffffffff500001}, // 61
+
+		Sigma: rlwe.DefaultSigma,
+
+		B: big.NewInt(65508), // 2^16 - 28
+		D: 1 << 7,
+		G: big.NewInt(5), // 5
+	}
+
+	HPN13D6T2048P = ParametersLiteral{
+		LogN: 13,
+
+		Q: []uint64{
+			0x1fffffffffe10001, 0x1fffffffffe00001,
+			0x1fffffffffdd0001,
+		}, // 61 * 3 = 183, 183 + 61 = 244
+		QMul: []uint64{
+			0x1fffffffffab0001, 0x1fffffffffa10001,
+			0x1fffffffff998001,
+		},
+
+		P: []uint64{0x1fffffffff500001}, // 61
+
+		Sigma: rlwe.DefaultSigma,
+
+		B: big.NewInt(65346), // 2^16 - 190
+		D: 1 << 6,
+		G: MustBigFromDecimal("34211388289180104270598866779538968048834520065344623333912799899653102604635268590982377645559608936309814453125"), // 5^161
+	}
+
+	HPN13D5T4096P = ParametersLiteral{
+		LogN: 13,
+
+		Q: []uint64{
+			0x1fffffffffe10001, 0x1fffffffffe00001,
+			0x1fffffffffdd0001,
+		}, // 61 * 3 = 183, 183 + 61 = 244
+		QMul: []uint64{
+			0x1fffffffffab0001, 0x1fffffffffa10001,
+			0x1fffffffff998001,
+		},
+
+		P: []uint64{0x1fffffffff500001}, // 61
+
+		Sigma: rlwe.DefaultSigma,
+
+		B: big.NewInt(65248), // 2^16 - 288
+		D: 1 << 5,
+		G: MustBigFromDecimal("13289078263368535010350719491824534628404827374597126975388693711018284879396957772210874852435860862184279107707"), // 3^235
+	}
+
 	PN15T128 = ParametersLiteral{
 		LogN: 15,
 
@@ -1,8 +1,11 @@
 package hpbfv
 
 import (
+	"encoding/binary"
+	"fmt"
 	"math/big"
 
+	"hp-bfv/ring"
 	"hp-bfv/rlwe"
 )
 
@@ -23,17 +26,229 @@ func NewPlaintext(params Parameters) *Plaintext {
 	return plaintext
 }
 
+// MarshalBinary encodes a Plaintext in a byte slice.
+func (pt *Plaintext) MarshalBinary() (data []byte, err error) {
+	ct := &rlwe.Ciphertext{Value: []*ring.Poly{pt.Value}, MetaData: pt.MetaData}
+	return ct.MarshalBinary()
+}
+
+// UnmarshalBinary decodes a previously marshaled Plaintext in the target Plaintext.
+func (pt *Plaintext) UnmarshalBinary(data []byte) (err error) {
+	ct := new(rlwe.Ciphertext)
+	if err = ct.UnmarshalBinary(data); err != nil {
+		return
+	}
+
+	if ct.Degree() != 0 {
+		return fmt.Errorf("cannot UnmarshalBinary: data is not a Plaintext")
+	}
+
+	pt.Plaintext = &rlwe.Plaintext{Value: ct.Value[0], MetaData: ct.MetaData}
+	return
+}
+
+// PlaintextMul represents a plaintext element in R_q, in NTT and Montgomery form, but without scale up by Q/(X^d-b).
+// A PlaintextMul is a special-purpose plaintext for efficient Ciphertext x Plaintext multiplication: its coefficients
+// are small, so that multiplying a ciphertext by it multiplies the underlying message without relinearization.
+type PlaintextMul struct {
+	*rlwe.Plaintext
+}
+
+// NewPlaintextMul creates and allocates a new plaintext optimized for Ciphertext x Plaintext multiplication.
+func NewPlaintextMul(params Parameters) *PlaintextMul {
+	return &PlaintextMul{rlwe.NewPlaintext(params.Parameters, params.MaxLevel())}
+}
+
+// Message is a vector of Slots() elements of Z_T, the slots of a Plaintext.
 type Message struct {
-	Value []*big.Int
+	Value []Element
 }
 
+// NewMessage allocates a new Message set to zero.
 func NewMessage(params Parameters) *Message {
 	msg := new(Message)
-	msg.Value = make([]*big.Int, params.Slots())
+	msg.Value = newElements(params, params.Slots())
+	return msg
+}
 
-	for i := 0; i < params.Slots(); i++ {
-		msg.Value[i] = big.NewInt(0)
+// NewMessageFromBigInts creates a new Message whose slots are values mod T.
+func NewMessageFromBigInts(params Parameters, values []*big.Int) *Message {
+	msg := NewMessage(params)
+	msg.SetBigInts(params, values)
+	return msg
+}
+
+// newElements allocates size Elements of Z_T set to zero, backed by a single slice.
+func newElements(params Parameters, size int) (v []Element) {
+	return newElementsWithWords(size, (params.T().BitLen()+63)/64)
+}
+
+// newElementsWithWords allocates size Elements of n words set to zero, backed by a single slice.
+func newElementsWithWords(size, n int) (v []Element) {
+	words := make([]uint64, n*size)
+	v = make([]Element, size)
+	for i := range v {
+		v[i] = words[i*n : (i+1)*n : (i+1)*n]
 	}
+	return
+}
 
-	return msg
+// SetBigInts writes values mod T on the slots of msg.
+func (msg *Message) SetBigInts(params Parameters, values []*big.Int) {
+	T := params.T()
+	x := new(big.Int)
+	for i := range msg.Value {
+		bigToWords(x.Mod(values[i], T), msg.Value[i])
+	}
+}
+
+// BigInts returns the slots of msg as big.Int in [0, T).
+func (msg *Message) BigInts() []*big.Int {
+	return BigInts(msg.Value)
+}
+
+// MarshalBinarySize returns the length in bytes of the target Message.
+func (msg *Message) MarshalBinarySize() (dataLen int) {
+	// 4 bytes : number of slots
+	// 4 bytes : number of words per slot
+	dataLen = 8
+	for _, v := range msg.Value {
+		dataLen += 8 * len(v)
+	}
+	return
+}
+
+// MarshalBinary encodes a Message in a byte slice.
+func (msg *Message) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, msg.MarshalBinarySize())
+
+	words := 0
+	if len(msg.Value) > 0 {
+		words = len(msg.Value[0])
+	}
+
+	binary.BigEndian.PutUint32(data, uint32(len(msg.Value)))
+	binary.BigEndian.PutUint32(data[4:], uint32(words))
+	ptr := 8
+
+	for _, v := range msg.Value {
+		if len(v) != words {
+			return nil, fmt.Errorf("cannot MarshalBinary: Message values must have the same number of words")
+		}
+
+		for _, w := range v {
+			binary.BigEndian.PutUint64(data[ptr:], w)
+			ptr += 8
+		}
+	}
+
+	return
+}
+
+// UnmarshalBinary decodes a previously marshaled Message in the target Message.
+// If the target Message is allocated, e.g. with NewMessage, the number of slots and of words per slot
+// must be its own, so that untrusted data is decoded in a Message of known size. The slots are not
+// checked to be in [0, T): see Check.
+func (msg *Message) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < 8 {
+		return fmt.Errorf("cannot UnmarshalBinary: len(data) is too small")
+	}
+
+	slots := int(binary.BigEndian.Uint32(data))
+	words := int(binary.BigEndian.Uint32(data[4:]))
+	ptr := 8
+
+	if len(msg.Value) != 0 && (slots != len(msg.Value) || words != len(msg.Value[0])) {
+		return fmt.Errorf("cannot UnmarshalBinary: the number of slots and words must be the ones of the target Message")
+	}
+
+	// 8 * slots * words can overflow, hence the division.
+	if words == 0 || len(data[ptr:])%(8*words) != 0 || len(data[ptr:])/(8*words) != slots {
+		return fmt.Errorf("cannot UnmarshalBinary: len(data) does not match the number of slots")
+	}
+
+	value := newElementsWithWords(slots, words)
+	for i := range value {
+		for j := range value[i] {
+			value[i][j] = binary.BigEndian.Uint64(data[ptr:])
+			ptr += 8
+		}
+	}
+
+	msg.Value = value
+
+	return
+}
+
+// Check checks that msg has the number of slots and of words per slot of params, and that every slot is in [0, T).
+// It should be called on the Messages decoded from untrusted data.
+func (msg *Message) Check(params Parameters) (err error) {
+	T := make(Element, (params.T().BitLen()+63)/64)
+	bigToWords(params.T(), T)
+
+	if len(msg.Value) != params.Slots() {
+		return fmt.Errorf("%w: the number of slots must be the one of the parameters", ErrEncodingMismatch)
+	}
+
+	for i, v := range msg.Value {
+		if len(v) != len(T) {
+			return fmt.Errorf("%w: the number of words of slot %d must be the one of the parameters", ErrEncodingMismatch, i)
+		}
+
+		if !lessWords(v, T) {
+			return fmt.Errorf("%w: slot %d is not in [0, T)", ErrOverflow, i)
+		}
+	}
+
+	return
+}
+
+// unmarshalBinaryV1 decodes a Message written by the version 1 of the encoding of the matrix containers,
+// i.e. the number of slots (4 bytes) followed by each slot as a big-endian integer prefixed by its length (4 bytes).
+// The target Message must be allocated: the number of slots must be its own, and every slot must fit in its words.
+// The slots are not checked to be in [0, T): see Check.
+func (msg *Message) unmarshalBinaryV1(data []byte) (err error) {
+	if len(msg.Value) == 0 {
+		return fmt.Errorf("cannot UnmarshalBinary: the target Message must be allocated")
+	}
+
+	if len(data) < 4 {
+		return fmt.Errorf("cannot UnmarshalBinary: len(data) is too small")
+	}
+
+	slots, words := len(msg.Value), len(msg.Value[0])
+	if int(binary.BigEndian.Uint32(data)) != slots {
+		return fmt.Errorf("cannot UnmarshalBinary: the number of slots must be the one of the target Message")
+	}
+	ptr := 4
+
+	x := new(big.Int)
+	value := newElementsWithWords(slots, words)
+	for i := range value {
+		if len(data[ptr:]) < 4 {
+			return fmt.Errorf("cannot UnmarshalBinary: len(data) is too small")
+		}
+
+		size := int(binary.BigEndian.Uint32(data[ptr:]))
+		ptr += 4
+
+		if size > len(data[ptr:]) {
+			return fmt.Errorf("cannot UnmarshalBinary: len(data) is too small")
+		}
+
+		if x.SetBytes(data[ptr:ptr+size]).BitLen() > 64*words {
+			return fmt.Errorf("cannot UnmarshalBinary: %w: slot %d does not fit in %d words", ErrOverflow, i, words)
+		}
+		ptr += size
+
+		bigToWords(x, value[i])
+	}
+
+	if ptr != len(data) {
+		return fmt.Errorf("cannot UnmarshalBinary: remaining unparsed data")
+	}
+
+	msg.Value = value
+
+	return
 }
@@ -0,0 +1,477 @@
+package hpbfv
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+
+	"hp-bfv/ring"
+	"hp-bfv/rlwe"
+	"hp-bfv/utils"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	// PoPKSoundness is the statistical soundness (in bits) of the proofs of plaintext knowledge.
+	PoPKSoundness = 128
+	// PoPKZeroKnowledge is the statistical zero-knowledge (in bits) of the proofs of plaintext knowledge.
+	PoPKZeroKnowledge = 40
+)
+
+// PoPKWitness is a vector (x, u, e0, e1) of polynomials in R_Q in the coefficient domain.
+// It is the witness of the ciphertext (pk0 * u + e0 + Delta * x, pk1 * u + e1),
+// where Delta = round(Q / (X^d - b)) and x is the small representative of the plaintext.
+// The responses of a PoPKProof are also given as PoPKWitness.
+type PoPKWitness struct {
+	X  *ring.Poly
+	U  *ring.Poly
+	E0 *ring.Poly
+	E1 *ring.Poly
+}
+
+// NewPoPKWitness allocates a new PoPKWitness.
+func NewPoPKWitness(params Parameters) *PoPKWitness {
+	ringQ := params.RingQ()
+	return &PoPKWitness{X: ringQ.NewPoly(), U: ringQ.NewPoly(), E0: ringQ.NewPoly(), E1: ringQ.NewPoly()}
+}
+
+// PoPKProof is a non-interactive (Fiat-Shamir) TopGear-style proof of plaintext knowledge
+// for all the ciphertexts of a MatrixCiphertext.
+type PoPKProof struct {
+	Commitments []*rlwe.Ciphertext
+	Responses   []*PoPKWitness
+}
+
+// popkContext stores the values shared by the prover and the verifier.
+type popkContext struct {
+	params Parameters
+	pk     *rlwe.PublicKey
+
+	// delta is round(Q / (X^d - b)) in the NTT and Montgomery domain.
+	delta *ring.Poly
+	// masks is the number of masking ciphertexts of a proof.
+	masks int
+
+	boundX *big.Int
+	boundE *big.Int
+
+	buffX *ring.Poly
+	buffU *ring.Poly
+}
+
+func newPoPKContext(params Parameters, pk *rlwe.PublicKey) (ctx *popkContext) {
+	ringQ := params.RingQ()
+
+	ctx = new(popkContext)
+	ctx.params = params
+	ctx.pk = pk
+	ctx.masks = int(math.Ceil(float64(PoPKSoundness+2) / math.Log2(float64(2*params.N()+1))))
+
+	// 1/(X^d - b) = -(b^(k-1) + b^(k-2) X^d + ... + X^((k-1)d)) / T
+	d := params.Slots()
+	k := params.N() / d
+	Q := params.QBigInt()
+	T := params.T()
+	tHalf := new(big.Int).Rsh(T, 1)
+
+	coeffs := make([]*big.Int, params.N())
+	for i := range coeffs {
+		coeffs[i] = big.NewInt(0)
+	}
+	for i := 0; i < k; i++ {
+		c := coeffs[i*d]
+		c.Exp(params.b, big.NewInt(int64(k-i-1)), nil)
+		c.Mul(c, Q)
+		c.Add(c, tHalf)
+		c.Quo(c, T)
+		c.Neg(c)
+	}
+
+	ctx.delta = ringQ.NewPoly()
+	ringQ.SetCoefficientsBigint(coeffs, ctx.delta)
+	ringQ.NTT(ctx.delta, ctx.delta)
+	ringQ.MForm(ctx.delta, ctx.delta)
+
+	// the plaintexts are decomposed in balanced base b, with an extra carry on the lowest digit
+	ctx.boundX = new(big.Int).Rsh(params.b, 1)
+	ctx.boundX.Add(ctx.boundX, big.NewInt(1))
+	ctx.boundE = big.NewInt(int64(6 * params.Sigma()))
+
+	ctx.buffX = ringQ.NewPoly()
+	ctx.buffU = ringQ.NewPoly()
+
+	return
+}
+
+// encrypt computes the ciphertext (pk0 * u + e0 + Delta * x, pk1 * u + e1) of the witness w.
+func (ctx *popkContext) encrypt(w *PoPKWitness, ct *rlwe.Ciphertext) {
+	ringQ := ctx.params.RingQ()
+
+	ringQ.NTT(w.U, ctx.buffU)
+	ringQ.NTT(w.X, ctx.buffX)
+
+	ringQ.MulCoeffsMontgomery(ctx.buffU, ctx.pk.Value[0].Q, ct.Value[0])
+	ringQ.MulCoeffsMontgomeryAndAdd(ctx.buffX, ctx.delta, ct.Value[0])
+	ringQ.MulCoeffsMontgomery(ctx.buffU, ctx.pk.Value[1].Q, ct.Value[1])
+
+	ringQ.InvNTT(ct.Value[0], ct.Value[0])
+	ringQ.InvNTT(ct.Value[1], ct.Value[1])
+
+	ringQ.Add(ct.Value[0], w.E0, ct.Value[0])
+	ringQ.Add(ct.Value[1], w.E1, ct.Value[1])
+}
+
+// slackBound returns 2^PoPKZeroKnowledge * n * bound, the bound of the masks for n ciphertexts.
+func slackBound(n int, bound *big.Int) (rho *big.Int) {
+	rho = new(big.Int).Lsh(big.NewInt(int64(n)), PoPKZeroKnowledge)
+	return rho.Mul(rho, bound)
+}
+
+// challenges derives the challenge matrix from the parameters, the identifier id of the prover and of the session,
+// the statement, i.e. the public key, the metadata and the ciphertexts of cm, and the commitments.
+// Each entry is 0 or a signed monomial +/- X^i, encoded as an integer in [0, 2N]:
+// 0 is 0, 1 + i is X^i and 1 + N + i is -X^i.
+// It returns an error if the statement or the commitments cannot be hashed.
+func (ctx *popkContext) challenges(id []byte, cm *MatrixCiphertext, commitments []*rlwe.Ciphertext) (W [][]int, err error) {
+	hash, err := blake2b.New512(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint, err := ctx.params.Fingerprint()
+	if err != nil {
+		return nil, err
+	}
+	hash.Write(fingerprint[:])
+
+	// the identifier is prefixed by its length, so that it cannot be shifted into the statement
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(id)))
+	hash.Write(size[:])
+	hash.Write(id)
+
+	data, err := ctx.pk.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("cannot hash the public key: %w", err)
+	}
+	hash.Write(data)
+
+	if _, err = writeMatrixHeader(hash, matrixKindCiphertext, len(cm.Value), cm.Pack, cm.IsDiagonal, cm.Layout); err != nil {
+		return nil, fmt.Errorf("cannot hash the metadata of the ciphertexts: %w", err)
+	}
+
+	for k, ct := range cm.Value {
+		if data, err = ct.MarshalBinary(); err != nil {
+			return nil, fmt.Errorf("cannot hash ciphertext %d: %w", k, err)
+		}
+		hash.Write(data)
+	}
+
+	for v, ct := range commitments {
+		if data, err = ct.MarshalBinary(); err != nil {
+			return nil, fmt.Errorf("cannot hash commitment %d: %w", v, err)
+		}
+		hash.Write(data)
+	}
+
+	prng, err := utils.NewKeyedPRNG(hash.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	// rejection sampling of integers in [0, 2N]
+	choices := uint64(2*ctx.params.N() + 1)
+	limit := math.MaxUint64 - math.MaxUint64%choices
+	buff := make([]byte, 8)
+
+	W = make([][]int, len(commitments))
+	for v := range W {
+		W[v] = make([]int, len(cm.Value))
+		for k := range W[v] {
+			for {
+				if _, err = prng.Read(buff); err != nil {
+					return nil, err
+				}
+				if r := binary.LittleEndian.Uint64(buff); r < limit {
+					W[v][k] = int(r % choices)
+					break
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// mulByChallengeAndAdd computes p1 + c * p0 and writes the result on p1, where c is a challenge entry.
+func (ctx *popkContext) mulByChallengeAndAdd(p0 *ring.Poly, c int, p1 *ring.Poly) {
+	if c == 0 {
+		return
+	}
+
+	ringQ := ctx.params.RingQ()
+	N := ringQ.N
+
+	deg, neg := c-1, false
+	if deg >= N {
+		deg, neg = deg-N, true
+	}
+
+	for i, qi := range ringQ.Modulus {
+		p0tmp, p1tmp := p0.Coeffs[i], p1.Coeffs[i]
+		for j := 0; j < N; j++ {
+			idx, sub := j+deg, neg
+			if idx >= N {
+				idx, sub = idx-N, !neg
+			}
+
+			if sub {
+				p1tmp[idx] += qi - p0tmp[j]
+			} else {
+				p1tmp[idx] += p0tmp[j]
+			}
+
+			if p1tmp[idx] >= qi {
+				p1tmp[idx] -= qi
+			}
+		}
+	}
+}
+
+// PoPKProver encrypts MatrixMessages and proves the knowledge of their plaintexts.
+type PoPKProver struct {
+	*popkContext
+
+	ecd *Encoder
+
+	prng            utils.PRNG
+	ternarySampler  *ring.TernarySampler
+	gaussianSampler *ring.GaussianSampler
+}
+
+// NewPoPKProver creates a new PoPKProver encrypting under the public key pk.
+// It returns an error if the PRNG of the encryption randomness and of the masks cannot be created.
+func NewPoPKProver(params Parameters, pk *rlwe.PublicKey) (prv *PoPKProver, err error) {
+	prng, err := utils.NewPRNG()
+	if err != nil {
+		return nil, fmt.Errorf("cannot NewPoPKProver: %w", err)
+	}
+
+	prv = new(PoPKProver)
+	prv.popkContext = newPoPKContext(params, pk)
+	prv.ecd = NewEncoder(params)
+	prv.prng = prng
+	prv.ternarySampler = ring.NewTernarySampler(prng, params.RingQ(), 0.5, false)
+	prv.gaussianSampler = ring.NewGaussianSampler(prng, params.RingQ(), params.Sigma(), int(6*params.Sigma()))
+
+	return
+}
+
+// EncryptAndProveNew encrypts mm and returns the MatrixCiphertext along with a proof that
+// every ciphertext encrypts a valid plaintext with bounded encryption randomness.
+// id identifies the prover and the session, e.g. the index of the party followed by a session
+// nonce agreed upon by all the parties: the proof only verifies under the same id, so that a party
+// cannot replay the input of another party as its own.
+func (prv *PoPKProver) EncryptAndProveNew(id []byte, mm *MatrixMessage) (cm *MatrixCiphertext, proof *PoPKProof, err error) {
+	params := prv.params
+	n := len(mm.Value)
+
+	if cm, err = NewMatrixCiphertext(params, n, mm.IsDiagonal); err != nil {
+		return nil, nil, fmt.Errorf("cannot EncryptAndProveNew: %w", err)
+	}
+	cm.Pack = mm.Pack
+	cm.Layout = mm.Layout
+
+	for k := range mm.Value {
+		if len(mm.Value[k].Value) != params.Slots() {
+			return nil, nil, fmt.Errorf("cannot EncryptAndProveNew: %w: messages must have Slots() values", ErrEncodingMismatch)
+		}
+	}
+
+	witnesses := make([]*PoPKWitness, n)
+	for k := range witnesses {
+		witnesses[k] = NewPoPKWitness(params)
+		prv.ecd.encodeSmall(mm.Value[k], witnesses[k].X)
+		prv.ternarySampler.Read(witnesses[k].U)
+		prv.gaussianSampler.Read(witnesses[k].E0)
+		prv.gaussianSampler.Read(witnesses[k].E1)
+		prv.encrypt(witnesses[k], cm.Value[k].Ciphertext)
+	}
+
+	rhoX := slackBound(n, prv.boundX)
+	rhoU := slackBound(n, big.NewInt(1))
+	rhoE := slackBound(n, prv.boundE)
+
+	proof = &PoPKProof{
+		Commitments: make([]*rlwe.Ciphertext, prv.masks),
+		Responses:   make([]*PoPKWitness, prv.masks),
+	}
+	for v := 0; v < prv.masks; v++ {
+		y := NewPoPKWitness(params)
+		for _, mask := range []struct {
+			rho *big.Int
+			p   *ring.Poly
+		}{{rhoX, y.X}, {rhoU, y.U}, {rhoE, y.E0}, {rhoE, y.E1}} {
+			if err = prv.sampleUniform(mask.rho, mask.p); err != nil {
+				return nil, nil, fmt.Errorf("cannot EncryptAndProveNew: %w", err)
+			}
+		}
+
+		proof.Commitments[v] = rlwe.NewCiphertext(params.Parameters, 1, params.MaxLevel())
+		prv.encrypt(y, proof.Commitments[v])
+		proof.Responses[v] = y
+	}
+
+	W, err := prv.challenges(id, cm, proof.Commitments)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot EncryptAndProveNew: %w", err)
+	}
+
+	// z_v = y_v + sum_k W[v][k] * w_k
+	for v, z := range proof.Responses {
+		for k, w := range witnesses {
+			prv.mulByChallengeAndAdd(w.X, W[v][k], z.X)
+			prv.mulByChallengeAndAdd(w.U, W[v][k], z.U)
+			prv.mulByChallengeAndAdd(w.E0, W[v][k], z.E0)
+			prv.mulByChallengeAndAdd(w.E1, W[v][k], z.E1)
+		}
+	}
+
+	return
+}
+
+// sampleUniform samples a polynomial with coefficients uniform in [-rho, rho].
+// It returns an error if the PRNG of the prover fails.
+func (prv *PoPKProver) sampleUniform(rho *big.Int, pOut *ring.Poly) (err error) {
+	width := new(big.Int).Lsh(rho, 1)
+	width.Add(width, big.NewInt(1))
+
+	coeffs := make([]*big.Int, prv.params.N())
+	for i := range coeffs {
+		var c *big.Int
+		if c, err = rand.Int(prv.prng, width); err != nil {
+			return
+		}
+		coeffs[i] = c.Sub(c, rho)
+	}
+
+	prv.params.RingQ().SetCoefficientsBigint(coeffs, pOut)
+
+	return
+}
+
+// PoPKVerifier verifies the proofs of plaintext knowledge generated by a PoPKProver.
+type PoPKVerifier struct {
+	*popkContext
+
+	ctPool    *rlwe.Ciphertext
+	accPool   *rlwe.Ciphertext
+	coeffPool []*big.Int
+}
+
+// NewPoPKVerifier creates a new PoPKVerifier for the ciphertexts encrypted under the public key pk.
+func NewPoPKVerifier(params Parameters, pk *rlwe.PublicKey) (vrf *PoPKVerifier) {
+	vrf = new(PoPKVerifier)
+	vrf.popkContext = newPoPKContext(params, pk)
+	vrf.ctPool = rlwe.NewCiphertext(params.Parameters, 1, params.MaxLevel())
+	vrf.accPool = rlwe.NewCiphertext(params.Parameters, 1, params.MaxLevel())
+	vrf.coeffPool = make([]*big.Int, params.N())
+	for i := range vrf.coeffPool {
+		vrf.coeffPool[i] = big.NewInt(0)
+	}
+	return
+}
+
+// Verify checks that proof is a valid proof of plaintext knowledge for the ciphertexts of cm.
+// A party passing the verification knows, for each ciphertext, a plaintext and an encryption
+// randomness whose norms are bounded by 2 * 2^PoPKZeroKnowledge * len(cm.Value) times the
+// honest bounds, up to the soundness slack of the protocol. id must be the identifier given to
+// EncryptAndProveNew by the prover. Verify rejects an empty cm, and the proof if the ciphertexts
+// and the commitments cannot be hashed into the challenges.
+func (vrf *PoPKVerifier) Verify(id []byte, cm *MatrixCiphertext, proof *PoPKProof) bool {
+	params := vrf.params
+	ringQ := params.RingQ()
+
+	if cm == nil || len(cm.Value) == 0 {
+		return false
+	}
+	n := len(cm.Value)
+
+	if proof == nil || len(proof.Commitments) != vrf.masks || len(proof.Responses) != vrf.masks {
+		return false
+	}
+
+	for _, ct := range cm.Value {
+		if ct == nil || !vrf.isWellFormed(ct.Ciphertext) {
+			return false
+		}
+	}
+
+	for v := range proof.Commitments {
+		if !vrf.isWellFormed(proof.Commitments[v]) {
+			return false
+		}
+
+		if z := proof.Responses[v]; z == nil || !vrf.isWellFormedPoly(z.X) || !vrf.isWellFormedPoly(z.U) || !vrf.isWellFormedPoly(z.E0) || !vrf.isWellFormedPoly(z.E1) {
+			return false
+		}
+	}
+
+	rhoX := slackBound(n, vrf.boundX)
+	rhoU := slackBound(n, big.NewInt(1))
+	rhoE := slackBound(n, vrf.boundE)
+	rhoX.Lsh(rhoX, 1)
+	rhoU.Lsh(rhoU, 1)
+	rhoE.Lsh(rhoE, 1)
+
+	W, err := vrf.challenges(id, cm, proof.Commitments)
+	if err != nil {
+		return false
+	}
+
+	for v, z := range proof.Responses {
+		if !vrf.isBounded(z.X, rhoX) || !vrf.isBounded(z.U, rhoU) || !vrf.isBounded(z.E0, rhoE) || !vrf.isBounded(z.E1, rhoE) {
+			return false
+		}
+
+		// Enc(z_v) = a_v + sum_k W[v][k] * ct_k
+		vrf.encrypt(z, vrf.ctPool)
+
+		ring.Copy(proof.Commitments[v].Value[0], vrf.accPool.Value[0])
+		ring.Copy(proof.Commitments[v].Value[1], vrf.accPool.Value[1])
+		for k, ct := range cm.Value {
+			vrf.mulByChallengeAndAdd(ct.Value[0], W[v][k], vrf.accPool.Value[0])
+			vrf.mulByChallengeAndAdd(ct.Value[1], W[v][k], vrf.accPool.Value[1])
+		}
+
+		if !ringQ.Equal(vrf.ctPool.Value[0], vrf.accPool.Value[0]) || !ringQ.Equal(vrf.ctPool.Value[1], vrf.accPool.Value[1]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isWellFormed checks that ct is a degree one ciphertext at the maximum level.
+func (vrf *PoPKVerifier) isWellFormed(ct *rlwe.Ciphertext) bool {
+	return ct != nil && ct.Degree() == 1 && !ct.IsNTT && vrf.isWellFormedPoly(ct.Value[0]) && vrf.isWellFormedPoly(ct.Value[1])
+}
+
+// isWellFormedPoly checks that p is a polynomial of R_Q at the maximum level.
+func (vrf *PoPKVerifier) isWellFormedPoly(p *ring.Poly) bool {
+	return p != nil && p.Level() == vrf.params.MaxLevel() && p.N() == vrf.params.N()
+}
+
+// isBounded checks that the centered coefficients of p are at most rho in absolute value.
+func (vrf *PoPKVerifier) isBounded(p *ring.Poly, rho *big.Int) bool {
+	vrf.params.RingQ().PolyToBigintCenteredLvl(p.Level(), p, 1, vrf.coeffPool)
+	for _, c := range vrf.coeffPool {
+		if c.CmpAbs(rho) > 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package hpbfv_test
+
+import (
+	"crypto/rand"
+	"hp-bfv/hpbfv"
+	"testing"
+)
+
+func TestPoPK(t *testing.T) {
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.HPN13D10T128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dim := 4
+
+	kg := hpbfv.NewKeyGenerator(params)
+	sk, pk := kg.GenKeyPair()
+
+	mm, err := hpbfv.NewMatrixMessage(params, dim, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zt := hpbfv.NewZT(params)
+	for _, msg := range mm.Value {
+		if err = zt.SampleUniform(rand.Reader, msg.Value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prv, err := hpbfv.NewPoPKProver(params, pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vrf := hpbfv.NewPoPKVerifier(params, pk)
+
+	id := []byte("party 0, session 1")
+	cm, proof, err := prv.EncryptAndProveNew(id, mm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Decrypt", func(t *testing.T) {
+		dec := hpbfv.NewDecryptor(params, sk)
+		for k := range cm.Value {
+			msgOut := dec.DecryptToMsgNew(cm.Value[k])
+			for i := range msgOut.Value {
+				if !msgOut.Value[i].Equal(mm.Value[k].Value[i]) {
+					t.Fatalf("diagonal %d slot %d: expected %v, got %v", k, i, mm.Value[k].Value[i].Text(10), msgOut.Value[i].Text(10))
+				}
+			}
+		}
+	})
+
+	t.Run("Verify", func(t *testing.T) {
+		if !vrf.Verify(id, cm, proof) {
+			t.Fatal("valid proof rejected")
+		}
+	})
+
+	t.Run("Verify/WrongID", func(t *testing.T) {
+		for _, other := range [][]byte{[]byte("party 1, session 1"), []byte("party 0, session 2"), nil} {
+			if vrf.Verify(other, cm, proof) {
+				t.Fatalf("proof for %q accepted for %q", id, other)
+			}
+		}
+	})
+
+	t.Run("Verify/WrongMetadata", func(t *testing.T) {
+		layout, err := hpbfv.NewMatrixLayout(params, dim)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, relabeled := range map[string]*hpbfv.MatrixCiphertext{
+			"Pack":       {Value: cm.Value, Pack: cm.Pack - 1, IsDiagonal: cm.IsDiagonal, Layout: cm.Layout},
+			"IsDiagonal": {Value: cm.Value, Pack: cm.Pack, IsDiagonal: !cm.IsDiagonal, Layout: cm.Layout},
+			"Layout":     {Value: cm.Value, Pack: cm.Pack, IsDiagonal: cm.IsDiagonal, Layout: layout},
+		} {
+			if vrf.Verify(id, relabeled, proof) {
+				t.Errorf("%s: proof accepted for relabeled ciphertexts", name)
+			}
+		}
+
+		if vrf.Verify(id, &hpbfv.MatrixCiphertext{Pack: cm.Pack, IsDiagonal: cm.IsDiagonal}, proof) {
+			t.Errorf("proof accepted for no ciphertexts")
+		}
+	})
+
+	t.Run("Verify/WrongCiphertext", func(t *testing.T) {
+		ct := cm.Value[1].CopyNew()
+		params.RingQ().Add(cm.Value[1].Value[0], cm.Value[1].Value[0], cm.Value[1].Value[0])
+		defer func() { cm.Value[1] = ct }()
+
+		if vrf.Verify(id, cm, proof) {
+			t.Fatal("proof accepted for a modified ciphertext")
+		}
+	})
+
+	t.Run("Verify/WrongResponse", func(t *testing.T) {
+		z := proof.Responses[0].E0.CopyNew()
+		params.RingQ().AddScalar(proof.Responses[0].E0, 1, proof.Responses[0].E0)
+		defer func() { proof.Responses[0].E0 = z }()
+
+		if vrf.Verify(id, cm, proof) {
+			t.Fatal("proof accepted for a modified response")
+		}
+	})
+}
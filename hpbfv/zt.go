@@ -0,0 +1,392 @@
+package hpbfv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"math/bits"
+)
+
+// Element is an element of Z_T, for the plaintext modulus T = b^k + 1, stored as the little-endian 64-bit words
+// of its representative in [0, T). All the Elements of a parameter set have ZT.Words() words, and the methods
+// of ZT expect them to be reduced.
+type Element []uint64
+
+// BigInt returns the representative in [0, T) of el.
+func (el Element) BigInt() *big.Int {
+	w := make([]big.Word, len(el)*64/bits.UintSize)
+	for i := range w {
+		w[i] = big.Word(el[i*bits.UintSize/64] >> (uint(i*bits.UintSize) % 64))
+	}
+	return new(big.Int).SetBits(w)
+}
+
+// Text returns the representation of el in the given base, see big.Int.Text.
+func (el Element) Text(base int) string {
+	return el.BigInt().Text(base)
+}
+
+// Equal returns true if el and other are the same element of Z_T.
+func (el Element) Equal(other Element) bool {
+	if len(el) != len(other) {
+		return false
+	}
+	for i := range el {
+		if el[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// IsZero returns true if el is zero.
+func (el Element) IsZero() bool {
+	for _, w := range el {
+		if w != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Set copies other on el.
+func (el Element) Set(other Element) {
+	copy(el, other)
+}
+
+// ZT implements the arithmetic of Z_T on vectors of Elements, for the plaintext modulus T = b^k + 1 of 128 to
+// 4096 bits of the HP-BFV parameters. Products are reduced on fixed-width words, so that, but for Inverse,
+// no operation allocates, and each product costs a single reduction: a Barrett reduction for the product of
+// two Elements, and a Montgomery multiplication for the products by a constant kept in the Montgomery
+// domain, as in MulScalar and in the NTT of the Encoder and Decoder.
+//
+// The reduction does not use the form of T: b is not a power of two, so splitting a product at b^k to
+// subtract its high part, as b^k = -1 mod T, is a division by b^k and is not faster than big.Int, see
+// BenchmarkZT. The fixed-width products are faster than big.Int up to 1024 bits; above, the subquadratic
+// multiplication of big.Int catches up with them.
+// A ZT is not safe for concurrent use: use ShallowCopy to obtain one ZT per goroutine.
+type ZT struct {
+	mont *montgomery
+	half Element // (T - 1) / 2
+	buf  *montgomeryBuffer
+	tmp  Element
+}
+
+// NewZT creates a new ZT for the plaintext modulus of params.
+func NewZT(params Parameters) *ZT {
+	return newZT(newMontgomery(params.T()))
+}
+
+func newZT(mont *montgomery) (zt *ZT) {
+	zt = &ZT{mont: mont, half: make(Element, mont.n), buf: mont.newBuffer(), tmp: make(Element, mont.n)}
+	bigToWords(new(big.Int).Rsh(mont.mBig, 1), zt.half)
+	return
+}
+
+// ShallowCopy creates a shallow copy of ZT in which all the read-only data-structures are
+// shared with the receiver and the temporary buffers are reallocated. The receiver and the returned
+// ZT can be used concurrently.
+func (zt *ZT) ShallowCopy() *ZT {
+	return newZT(zt.mont)
+}
+
+// Modulus returns T.
+func (zt *ZT) Modulus() *big.Int {
+	return new(big.Int).Set(zt.mont.mBig)
+}
+
+// Words returns the number of 64-bit words of an Element.
+func (zt *ZT) Words() int {
+	return zt.mont.n
+}
+
+// NewElement allocates a new Element set to zero.
+func (zt *ZT) NewElement() Element {
+	return make(Element, zt.mont.n)
+}
+
+// NewVector allocates a vector of size Elements set to zero, backed by a single slice.
+func (zt *ZT) NewVector(size int) (v []Element) {
+	words := zt.mont.newElements(size)
+	v = make([]Element, size)
+	for i := range v {
+		v[i] = words[i]
+	}
+	return
+}
+
+// SetBigInt writes x mod T on el.
+func (zt *ZT) SetBigInt(x *big.Int, el Element) {
+	if x.Sign() < 0 || x.Cmp(zt.mont.mBig) >= 0 {
+		x = zt.buf.x.Mod(x, zt.mont.mBig)
+	}
+	bigToWords(x, el)
+}
+
+// SetBigInts writes x[i] mod T on v[i].
+func (zt *ZT) SetBigInts(x []*big.Int, v []Element) {
+	for i := range v {
+		zt.SetBigInt(x[i], v[i])
+	}
+}
+
+// SetInt64 writes x mod T on el.
+func (zt *ZT) SetInt64(x int64, el Element) {
+	for j := range el {
+		el[j] = 0
+	}
+
+	if x >= 0 {
+		el[0] = uint64(x)
+		return
+	}
+
+	el[0] = uint64(-(x + 1)) + 1 // |x|, without overflow for math.MinInt64
+	zt.mont.sub(zt.mont.m, el, el)
+}
+
+// SetUint64 writes x mod T on el.
+func (zt *ZT) SetUint64(x uint64, el Element) {
+	for j := range el {
+		el[j] = 0
+	}
+	el[0] = x
+}
+
+// SetFloat64 writes the nearest integer to x mod T on el, or returns an error wrapping ErrOverflow
+// if x is not finite or its nearest integer is not in (-T/2, T/2).
+func (zt *ZT) SetFloat64(x float64, el Element) (err error) {
+	v := math.Round(x)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Errorf("cannot SetFloat64: %w: %v is not finite", ErrOverflow, x)
+	}
+
+	if math.Abs(v) < 1<<63 {
+		zt.SetInt64(int64(v), el)
+		return
+	}
+
+	abs, _ := new(big.Float).SetFloat64(math.Abs(v)).Int(nil)
+	if abs.BitLen() > 64*zt.mont.n {
+		return fmt.Errorf("cannot SetFloat64: %w: %v does not fit in Z_T", ErrOverflow, x)
+	}
+
+	bigToWords(abs, el)
+	if lessWords(zt.half, el) {
+		return fmt.Errorf("cannot SetFloat64: %w: %v does not fit in Z_T", ErrOverflow, x)
+	}
+
+	if v < 0 {
+		zt.mont.sub(zt.mont.m, el, el)
+	}
+
+	return
+}
+
+// Int64 returns the centered representative of el in (-T/2, T/2), or an error wrapping ErrOverflow
+// if it does not fit in an int64.
+func (zt *ZT) Int64(el Element) (x int64, err error) {
+	abs, neg := zt.centered(el)
+	if !zt.isUint64(abs) || (!neg && abs[0] > math.MaxInt64) || (neg && abs[0] > 1<<63) {
+		return 0, fmt.Errorf("cannot Int64: %w: %s does not fit in an int64", ErrOverflow, zt.centeredText(el))
+	}
+
+	if neg {
+		return -int64(abs[0]-1) - 1, nil
+	}
+	return int64(abs[0]), nil
+}
+
+// Uint64 returns the centered representative of el in (-T/2, T/2), or an error wrapping ErrOverflow
+// if it does not fit in an uint64.
+func (zt *ZT) Uint64(el Element) (x uint64, err error) {
+	abs, neg := zt.centered(el)
+	if !zt.isUint64(abs) || (neg && abs[0] != 0) {
+		return 0, fmt.Errorf("cannot Uint64: %w: %s does not fit in an uint64", ErrOverflow, zt.centeredText(el))
+	}
+	return abs[0], nil
+}
+
+// Float64 returns the centered representative of el in (-T/2, T/2) rounded to the nearest float64,
+// which may be infinite.
+func (zt *ZT) Float64(el Element) (x float64) {
+	abs, neg := zt.centered(el)
+	x, _ = new(big.Float).SetInt(abs.BigInt()).Float64()
+	if neg {
+		x = -x
+	}
+	return
+}
+
+// centered returns the absolute value of the centered representative of el on the temporary buffer
+// of zt, and whether it is negative.
+func (zt *ZT) centered(el Element) (abs Element, neg bool) {
+	abs = zt.tmp
+	if lessWords(zt.half, el) {
+		zt.mont.sub(zt.mont.m, el, abs)
+		return abs, true
+	}
+	abs.Set(el)
+	return abs, false
+}
+
+// isUint64 returns true if el < 2^64.
+func (zt *ZT) isUint64(el Element) bool {
+	for _, w := range el[1:] {
+		if w != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// centeredText returns the centered representative of el in base 10.
+func (zt *ZT) centeredText(el Element) string {
+	abs, neg := zt.centered(el)
+	if neg {
+		return "-" + abs.Text(10)
+	}
+	return abs.Text(10)
+}
+
+// BigInts returns the representatives in [0, T) of the Elements of v.
+func BigInts(v []Element) (x []*big.Int) {
+	x = make([]*big.Int, len(v))
+	for i := range v {
+		x[i] = v[i].BigInt()
+	}
+	return
+}
+
+// NewMatricesFromBigInts returns the matrices x mod T as matrices of Elements.
+func (zt *ZT) NewMatricesFromBigInts(x [][][]*big.Int) (m [][][]Element) {
+	m = make([][][]Element, len(x))
+	for l := range x {
+		m[l] = make([][]Element, len(x[l]))
+		for i := range x[l] {
+			m[l][i] = zt.NewVector(len(x[l][i]))
+			zt.SetBigInts(x[l][i], m[l][i])
+		}
+	}
+	return
+}
+
+// MatricesToBigInts returns the representatives in [0, T) of the matrices of Elements m.
+func MatricesToBigInts(m [][][]Element) (x [][][]*big.Int) {
+	x = make([][][]*big.Int, len(m))
+	for l := range m {
+		x[l] = make([][]*big.Int, len(m[l]))
+		for i := range m[l] {
+			x[l][i] = BigInts(m[l][i])
+		}
+	}
+	return
+}
+
+// SampleUniform writes on v[i] uniformly random elements of Z_T, sampled by rejection from the bytes of r.
+func (zt *ZT) SampleUniform(r io.Reader, v []Element) (err error) {
+	n := zt.mont.n
+	top := uint(zt.mont.mBig.BitLen() - 64*(n-1))
+	b := zt.buf.bytes
+
+	for i := range v {
+		for {
+			if _, err = io.ReadFull(r, b); err != nil {
+				return fmt.Errorf("cannot SampleUniform: %w", err)
+			}
+
+			for j := 0; j < n; j++ {
+				v[i][j] = binary.LittleEndian.Uint64(b[8*j:])
+			}
+			if top < 64 {
+				v[i][n-1] &= (1 << top) - 1
+			}
+
+			if lessWords(v[i], zt.mont.m) {
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// Add writes a[i] + b[i] mod T on c[i].
+func (zt *ZT) Add(a, b, c []Element) {
+	for i := range c {
+		zt.mont.add(a[i], b[i], c[i], zt.buf)
+	}
+}
+
+// Sub writes a[i] - b[i] mod T on c[i].
+func (zt *ZT) Sub(a, b, c []Element) {
+	for i := range c {
+		zt.mont.sub(a[i], b[i], c[i])
+	}
+}
+
+// Neg writes -a[i] mod T on c[i].
+func (zt *ZT) Neg(a, c []Element) {
+	for i := range c {
+		if a[i].IsZero() {
+			c[i].Set(a[i])
+		} else {
+			zt.mont.sub(zt.mont.m, a[i], c[i])
+		}
+	}
+}
+
+// Mul writes a[i] * b[i] mod T on c[i].
+func (zt *ZT) Mul(a, b, c []Element) {
+	for i := range c {
+		zt.mul(a[i], b[i], c[i])
+	}
+}
+
+// MulScalar writes a[i] * s mod T on c[i].
+func (zt *ZT) MulScalar(a []Element, s Element, c []Element) {
+	// s * R mod T, so that a single Montgomery multiplication gives a[i] * s
+	zt.mont.mul(s, zt.mont.r2, zt.tmp, zt.buf)
+	for i := range c {
+		zt.mont.mul(a[i], zt.tmp, c[i], zt.buf)
+	}
+}
+
+// Inverse writes a[i]^-1 mod T on c[i]. It computes a single inversion for the whole vector with
+// Montgomery's batch inversion, and returns an error wrapping ErrNotInvertible if any a[i] is zero.
+func (zt *ZT) Inverse(a, c []Element) (err error) {
+	if len(c) == 0 {
+		return
+	}
+
+	// prefix[i] = a[0] * ... * a[i]
+	prefix := zt.NewVector(len(c))
+	prefix[0].Set(a[0])
+	for i := 1; i < len(c); i++ {
+		zt.mul(prefix[i-1], a[i], prefix[i])
+	}
+
+	if prefix[len(c)-1].IsZero() {
+		return fmt.Errorf("cannot Inverse: %w: zero has no inverse mod T", ErrNotInvertible)
+	}
+
+	inv := zt.NewElement()
+	bigToWords(new(big.Int).ModInverse(prefix[len(c)-1].BigInt(), zt.mont.mBig), inv)
+
+	// inv = (a[0] * ... * a[i])^-1
+	for i := len(c) - 1; i > 0; i-- {
+		zt.mul(inv, prefix[i-1], prefix[i])
+		zt.mul(inv, a[i], inv)
+		c[i].Set(prefix[i])
+	}
+	c[0].Set(inv)
+
+	return
+}
+
+// mul writes a * b mod T on c.
+func (zt *ZT) mul(a, b, c Element) {
+	zt.mont.mulMod(a, b, c, zt.buf)
+}
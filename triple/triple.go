@@ -0,0 +1,429 @@
+// Package triple implements the generation of SPDZ-style matrix triples (A, B, C = A*B)
+// on top of the matrix multiplication of the hpbfv package.
+//
+// Each party runs its own Party, which only holds its share of the secret key, and the
+// parties exchange the messages of the protocol:
+//
+//  1. every party broadcasts the Inputs returned by GenInputs, and the encryption of its
+//     share of the MAC key returned by GenMacKey for authenticated triples;
+//  2. the Product of the aggregated inputs is computed with Multiply, and authenticated with
+//     AggregateMacKeys and Authenticate for authenticated triples;
+//  3. every encrypted matrix is jointly decrypted into additive shares: one party, the combiner,
+//     generates its DecryptionShare with GenShare, the others with GenAdditiveShare, which also
+//     returns their additive shares, and the combiner obtains its additive share with Combine.
+//     The Macs returned by Authenticate are decrypted the same way with GenMacShare,
+//     GenAdditiveMacShare and CombineMacs, which set the MAC fields of the Share of the party.
+package triple
+
+import (
+	"fmt"
+	"math/big"
+
+	"hp-bfv/dhpbfv"
+	"hp-bfv/hpbfv"
+	"hp-bfv/rlwe"
+	"hp-bfv/utils"
+)
+
+// Share is the additive share of a party of a batch of matrix triples.
+// Each matrix field stores Pack matrices of size dim x dim with entries in Z_T, which
+// hpbfv.MatricesToBigInts converts to big.Int.
+// Alpha is the share of the MAC key of the party, and MacA, MacB and MacC are its
+// shares of alpha*A, alpha*B and alpha*C. They are only set for authenticated triples,
+// by GenAdditiveMacShare or CombineMacs.
+type Share struct {
+	A [][][]hpbfv.Element
+	B [][][]hpbfv.Element
+	C [][][]hpbfv.Element
+
+	Alpha hpbfv.Element
+	MacA  [][][]hpbfv.Element
+	MacB  [][][]hpbfv.Element
+	MacC  [][][]hpbfv.Element
+}
+
+// Inputs is the message broadcast by a party with the encryptions of its shares of A and B.
+type Inputs struct {
+	CtA *hpbfv.MatrixCiphertext
+	CtB *hpbfv.MatrixCiphertext
+}
+
+// Product stores the encryptions of the aggregated A and B and of their product C.
+type Product struct {
+	CtA *hpbfv.MatrixCiphertext
+	CtB *hpbfv.MatrixCiphertext
+	CtC *hpbfv.MatrixCiphertext
+}
+
+// Macs stores the encryptions of the MACs alpha*A, alpha*B and alpha*C of a Product, see Authenticate.
+type Macs struct {
+	CtMacA *hpbfv.MatrixCiphertext
+	CtMacB *hpbfv.MatrixCiphertext
+	CtMacC *hpbfv.MatrixCiphertext
+}
+
+// MacDecryptionShare is the share of a party in the distributed decryption of Macs,
+// with one DecryptionShare per MAC.
+type MacDecryptionShare struct {
+	MacA *DecryptionShare
+	MacB *DecryptionShare
+	MacC *DecryptionShare
+}
+
+// DecryptionShare is the share of a party in the distributed decryption of a MatrixCiphertext,
+// with one dhpbfv.DecryptionShare per diagonal.
+type DecryptionShare struct {
+	Value []*dhpbfv.DecryptionShare
+}
+
+// Party is a party of the generation of matrix triples. It stores the secret key share of
+// the party and its share of the MAC key.
+type Party struct {
+	params hpbfv.Parameters
+	dim    int
+	pack   int
+
+	prng utils.PRNG
+
+	zt    *hpbfv.ZT
+	ecd   *hpbfv.MatrixEncoder
+	enc   *hpbfv.MatrixEncryptor
+	eval  *hpbfv.MatrixEvaluator
+	cEnc  *hpbfv.Encryptor
+	cEval *hpbfv.Evaluator
+
+	alpha hpbfv.Element
+
+	dec *dhpbfv.ThresholdDecryptor
+	cmb *dhpbfv.Combiner
+}
+
+// NewParty creates a new Party for dim x dim matrices from the secret key share of the party.
+// pk must be the public key of the sum of the secret key shares of all the parties and rks
+// must be generated with GenRotationKeysForMatMul for the same dim. smudgingBound is the bound
+// of the smudging noise of the decryption shares, see dhpbfv.SmudgingBound, and must be sized
+// for the noise budget of the products, and of the MACs for authenticated triples.
+func NewParty(params hpbfv.Parameters, dim int, pk *rlwe.PublicKey, skShare *rlwe.SecretKey, rlk *rlwe.RelinearizationKey, rks *rlwe.RotationKeySet, smudgingBound *big.Int) (p *Party, err error) {
+	if dim < 1 || params.Slots()%dim != 0 {
+		return nil, fmt.Errorf("cannot NewParty: %w", hpbfv.ErrDimNotDivisor)
+	}
+
+	prng, err := utils.NewPRNG()
+	if err != nil {
+		return nil, fmt.Errorf("cannot NewParty: %w", err)
+	}
+
+	p = new(Party)
+	p.params = params
+	p.dim = dim
+	p.pack = params.Slots() / dim
+	p.prng = prng
+	p.zt = hpbfv.NewZT(params)
+	p.ecd = hpbfv.NewMatrixEncoder(params)
+	p.enc = hpbfv.NewMatrixEncryptor(params, pk, nil)
+	p.eval = hpbfv.NewMatrixEvaluator(params, rlk, rks)
+	p.cEnc = hpbfv.NewEncryptor(params, pk)
+	p.cEval = hpbfv.NewEvaluator(params)
+
+	p.alpha = p.zt.NewElement()
+	if err = p.sampleUniform([]hpbfv.Element{p.alpha}); err != nil {
+		return nil, fmt.Errorf("cannot NewParty: %w", err)
+	}
+
+	if p.dec, err = dhpbfv.NewThresholdDecryptor(params, skShare, smudgingBound); err != nil {
+		return nil, fmt.Errorf("cannot NewParty: %w", err)
+	}
+	p.cmb = dhpbfv.NewCombiner(params)
+
+	return
+}
+
+// Dim returns the dimension of the generated matrices.
+func (p *Party) Dim() int {
+	return p.dim
+}
+
+// Pack returns the number of triples generated at once.
+func (p *Party) Pack() int {
+	return p.pack
+}
+
+// GenInputs samples the shares of A and B of the party and returns them in a new Share,
+// along with their encryptions to broadcast to the other parties.
+func (p *Party) GenInputs() (share *Share, in *Inputs, err error) {
+	share = new(Share)
+	if share.A, err = p.SampleMatrices(); err != nil {
+		return nil, nil, fmt.Errorf("cannot GenInputs: %w", err)
+	}
+
+	if share.B, err = p.SampleMatrices(); err != nil {
+		return nil, nil, fmt.Errorf("cannot GenInputs: %w", err)
+	}
+
+	in = new(Inputs)
+	if in.CtA, err = p.encryptMatrices(share.A, true); err != nil {
+		return nil, nil, fmt.Errorf("cannot GenInputs: %w", err)
+	}
+
+	if in.CtB, err = p.encryptMatrices(share.B, false); err != nil {
+		return nil, nil, fmt.Errorf("cannot GenInputs: %w", err)
+	}
+
+	return
+}
+
+// Multiply aggregates the inputs of all the parties and returns the encryptions of A, B and C = A*B.
+func (p *Party) Multiply(inputs []*Inputs) (prod *Product, err error) {
+	if len(inputs) < 1 {
+		return nil, fmt.Errorf("cannot Multiply: the number of inputs must be positive")
+	}
+
+	prod = &Product{CtA: inputs[0].CtA, CtB: inputs[0].CtB}
+	for i := 1; i < len(inputs); i++ {
+		if i == 1 {
+			// Allocates the sums, so that the inputs are left unchanged.
+			if prod.CtA, err = p.eval.AddNew(prod.CtA, inputs[i].CtA); err != nil {
+				return nil, fmt.Errorf("cannot Multiply: %w", err)
+			}
+
+			if prod.CtB, err = p.eval.AddNew(prod.CtB, inputs[i].CtB); err != nil {
+				return nil, fmt.Errorf("cannot Multiply: %w", err)
+			}
+
+			continue
+		}
+
+		if err = p.eval.Add(prod.CtA, inputs[i].CtA, prod.CtA); err != nil {
+			return nil, fmt.Errorf("cannot Multiply: %w", err)
+		}
+
+		if err = p.eval.Add(prod.CtB, inputs[i].CtB, prod.CtB); err != nil {
+			return nil, fmt.Errorf("cannot Multiply: %w", err)
+		}
+	}
+
+	if prod.CtC, err = p.eval.MulNew(prod.CtA, prod.CtB); err != nil {
+		return nil, fmt.Errorf("cannot Multiply: %w", err)
+	}
+
+	return
+}
+
+// GenMacKey returns the encryption of the share of the MAC key of the party, in every slot,
+// to broadcast to the other parties.
+func (p *Party) GenMacKey() (ct *hpbfv.Ciphertext) {
+	msg := hpbfv.NewMessage(p.params)
+	for i := range msg.Value {
+		msg.Value[i].Set(p.alpha)
+	}
+	return p.cEnc.EncryptMsgNew(msg)
+}
+
+// AggregateMacKeys sums the encryptions of the shares of the MAC key of all the parties.
+func (p *Party) AggregateMacKeys(cts []*hpbfv.Ciphertext) (ctAlpha *hpbfv.Ciphertext, err error) {
+	if len(cts) < 1 {
+		return nil, fmt.Errorf("cannot AggregateMacKeys: the number of ciphertexts must be positive")
+	}
+
+	ctAlpha = cts[0].CopyNew()
+	for _, ct := range cts[1:] {
+		p.cEval.Add(ctAlpha, ct, ctAlpha)
+	}
+
+	return
+}
+
+// Authenticate multiplies the encryptions of A, B and C of prod by the encrypted MAC key ctAlpha,
+// see AggregateMacKeys, and returns the encryptions of their MACs.
+func (p *Party) Authenticate(ctAlpha *hpbfv.Ciphertext, prod *Product) (macs *Macs, err error) {
+	ctMacs, err := p.eval.AuthenticateNew(ctAlpha, prod.CtA, prod.CtB, prod.CtC)
+	if err != nil {
+		return nil, fmt.Errorf("cannot Authenticate: %w", err)
+	}
+
+	return &Macs{CtMacA: ctMacs[0], CtMacB: ctMacs[1], CtMacC: ctMacs[2]}, nil
+}
+
+// Alpha returns a copy of the share of the MAC key of the party.
+func (p *Party) Alpha() (alpha hpbfv.Element) {
+	alpha = p.zt.NewElement()
+	alpha.Set(p.alpha)
+	return
+}
+
+// GenShare returns the decryption share of the party for ct, for the party that combines the
+// decryption shares, see Combine.
+func (p *Party) GenShare(ct *hpbfv.MatrixCiphertext) (share *DecryptionShare, err error) {
+	share = &DecryptionShare{Value: make([]*dhpbfv.DecryptionShare, len(ct.Value))}
+	for k := range ct.Value {
+		if share.Value[k], err = p.dec.GenShareNew(ct.Value[k]); err != nil {
+			return nil, fmt.Errorf("cannot GenShare: %w", err)
+		}
+	}
+	return
+}
+
+// GenAdditiveShare returns the decryption share of the party for ct, to send to the party that
+// combines the decryption shares, along with the additive share mod T of the party of the matrices of ct.
+func (p *Party) GenAdditiveShare(ct *hpbfv.MatrixCiphertext) (share *DecryptionShare, matrices [][][]hpbfv.Element, err error) {
+	mask, err := hpbfv.NewMatrixMessage(p.params, len(ct.Value), ct.IsDiagonal)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot GenAdditiveShare: %w", err)
+	}
+
+	share = &DecryptionShare{Value: make([]*dhpbfv.DecryptionShare, len(ct.Value))}
+	for k := range ct.Value {
+		share.Value[k] = dhpbfv.AllocateShare(p.params)
+		if err = p.dec.GenAdditiveShare(ct.Value[k], mask.Value[k], share.Value[k]); err != nil {
+			return nil, nil, fmt.Errorf("cannot GenAdditiveShare: %w", err)
+		}
+	}
+
+	if matrices, err = p.ecd.DecodeMatrixMessageElementsNew(mask); err != nil {
+		return nil, nil, fmt.Errorf("cannot GenAdditiveShare: %w", err)
+	}
+
+	return
+}
+
+// Combine aggregates the decryption shares of all the parties for ct, including the one of the
+// party returned by GenShare, and returns the additive share mod T of the party of the matrices of ct.
+func (p *Party) Combine(ct *hpbfv.MatrixCiphertext, shares []*DecryptionShare) (matrices [][][]hpbfv.Element, err error) {
+	if len(shares) < 1 {
+		return nil, fmt.Errorf("cannot Combine: the number of shares must be positive")
+	}
+
+	for _, share := range shares {
+		if len(share.Value) != len(ct.Value) {
+			return nil, fmt.Errorf("cannot Combine: %w: expected %d decryption shares, got %d", hpbfv.ErrEncodingMismatch, len(ct.Value), len(share.Value))
+		}
+	}
+
+	msg, err := hpbfv.NewMatrixMessage(p.params, len(ct.Value), ct.IsDiagonal)
+	if err != nil {
+		return nil, fmt.Errorf("cannot Combine: %w", err)
+	}
+
+	agg := dhpbfv.AllocateShare(p.params)
+	for k := range ct.Value {
+		agg.Value.Copy(shares[0].Value[k].Value)
+		for _, share := range shares[1:] {
+			dhpbfv.AggregateShares(p.params, agg, share.Value[k], agg)
+		}
+		if err = p.cmb.Combine(ct.Value[k], agg, msg.Value[k]); err != nil {
+			return nil, fmt.Errorf("cannot Combine: %w", err)
+		}
+	}
+
+	if matrices, err = p.ecd.DecodeMatrixMessageElementsNew(msg); err != nil {
+		return nil, fmt.Errorf("cannot Combine: %w", err)
+	}
+
+	return
+}
+
+// GenMacShare returns the decryption share of the party for macs, for the party that combines the
+// decryption shares, see CombineMacs.
+func (p *Party) GenMacShare(macs *Macs) (share *MacDecryptionShare, err error) {
+	share = new(MacDecryptionShare)
+
+	if share.MacA, err = p.GenShare(macs.CtMacA); err != nil {
+		return nil, fmt.Errorf("cannot GenMacShare: %w", err)
+	}
+
+	if share.MacB, err = p.GenShare(macs.CtMacB); err != nil {
+		return nil, fmt.Errorf("cannot GenMacShare: %w", err)
+	}
+
+	if share.MacC, err = p.GenShare(macs.CtMacC); err != nil {
+		return nil, fmt.Errorf("cannot GenMacShare: %w", err)
+	}
+
+	return
+}
+
+// GenAdditiveMacShare returns the decryption share of the party for macs, to send to the party that
+// combines the decryption shares, and sets the share of the MAC key and the additive shares mod T of
+// the MACs of the party on tripleShare.
+func (p *Party) GenAdditiveMacShare(macs *Macs, tripleShare *Share) (share *MacDecryptionShare, err error) {
+	share = new(MacDecryptionShare)
+
+	var macA, macB, macC [][][]hpbfv.Element
+	if share.MacA, macA, err = p.GenAdditiveShare(macs.CtMacA); err != nil {
+		return nil, fmt.Errorf("cannot GenAdditiveMacShare: %w", err)
+	}
+
+	if share.MacB, macB, err = p.GenAdditiveShare(macs.CtMacB); err != nil {
+		return nil, fmt.Errorf("cannot GenAdditiveMacShare: %w", err)
+	}
+
+	if share.MacC, macC, err = p.GenAdditiveShare(macs.CtMacC); err != nil {
+		return nil, fmt.Errorf("cannot GenAdditiveMacShare: %w", err)
+	}
+
+	tripleShare.Alpha = p.Alpha()
+	tripleShare.MacA, tripleShare.MacB, tripleShare.MacC = macA, macB, macC
+
+	return
+}
+
+// CombineMacs aggregates the decryption shares of all the parties for macs, including the one of the
+// party returned by GenMacShare, and sets the share of the MAC key and the additive shares mod T of
+// the MACs of the party on tripleShare.
+func (p *Party) CombineMacs(macs *Macs, shares []*MacDecryptionShare, tripleShare *Share) (err error) {
+	sharesA := make([]*DecryptionShare, len(shares))
+	sharesB := make([]*DecryptionShare, len(shares))
+	sharesC := make([]*DecryptionShare, len(shares))
+	for i, share := range shares {
+		sharesA[i], sharesB[i], sharesC[i] = share.MacA, share.MacB, share.MacC
+	}
+
+	var macA, macB, macC [][][]hpbfv.Element
+	if macA, err = p.Combine(macs.CtMacA, sharesA); err != nil {
+		return fmt.Errorf("cannot CombineMacs: %w", err)
+	}
+
+	if macB, err = p.Combine(macs.CtMacB, sharesB); err != nil {
+		return fmt.Errorf("cannot CombineMacs: %w", err)
+	}
+
+	if macC, err = p.Combine(macs.CtMacC, sharesC); err != nil {
+		return fmt.Errorf("cannot CombineMacs: %w", err)
+	}
+
+	tripleShare.Alpha = p.Alpha()
+	tripleShare.MacA, tripleShare.MacB, tripleShare.MacC = macA, macB, macC
+
+	return
+}
+
+// encryptMatrices encodes and encrypts matrices in the diagonal or shifted-diagonal encoding.
+func (p *Party) encryptMatrices(matrices [][][]hpbfv.Element, isDiagonal bool) (ct *hpbfv.MatrixCiphertext, err error) {
+	pt, err := p.ecd.EncodeMatrixElementsNew(matrices, isDiagonal)
+	if err != nil {
+		return nil, err
+	}
+	return p.enc.EncryptNew(pt)
+}
+
+// sampleUniform samples uniformly random elements of Z_T on v.
+// It returns an error if the PRNG of the party fails.
+func (p *Party) sampleUniform(v []hpbfv.Element) (err error) {
+	return p.zt.SampleUniform(p.prng, v)
+}
+
+// SampleMatrices samples Pack uniformly random dim x dim matrices over Z_T.
+// It returns an error if the PRNG of the party fails.
+func (p *Party) SampleMatrices() (matrices [][][]hpbfv.Element, err error) {
+	matrices = make([][][]hpbfv.Element, p.pack)
+	for l := range matrices {
+		matrices[l] = make([][]hpbfv.Element, p.dim)
+		for i := range matrices[l] {
+			matrices[l][i] = p.zt.NewVector(p.dim)
+			if err = p.sampleUniform(matrices[l][i]); err != nil {
+				return nil, fmt.Errorf("cannot SampleMatrices: %w", err)
+			}
+		}
+	}
+	return
+}
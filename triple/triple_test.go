@@ -0,0 +1,231 @@
+package triple_test
+
+import (
+	"math/big"
+	"testing"
+
+	"hp-bfv/dhpbfv"
+	"hp-bfv/hpbfv"
+	"hp-bfv/rlwe"
+	"hp-bfv/triple"
+)
+
+// minNoiseBudget is a lower bound on the noise budget of the products and MACs of the tests,
+// whose smallest budget, that of the MACs of C, is about 117 bits with HPN13D10T128 and dim 4.
+const minNoiseBudget = 100
+
+// smudgingBound returns the smudging bound of the decryption shares of the tests.
+func smudgingBound(t *testing.T, params hpbfv.Parameters, parties int) *big.Int {
+	bound, err := dhpbfv.SmudgingBound(params, minNoiseBudget, 40, parties)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bound
+}
+
+// reconstruct sums the shares selected by get modulo T.
+func reconstruct(shares []*triple.Share, get func(*triple.Share) [][][]hpbfv.Element, T *big.Int) (matrices [][][]*big.Int) {
+	parts := make([][][][]*big.Int, len(shares))
+	for k := range shares {
+		parts[k] = hpbfv.MatricesToBigInts(get(shares[k]))
+	}
+
+	first := parts[0]
+	matrices = make([][][]*big.Int, len(first))
+	for l := range first {
+		matrices[l] = make([][]*big.Int, len(first[l]))
+		for i := range first[l] {
+			matrices[l][i] = make([]*big.Int, len(first[l][i]))
+			for j := range first[l][i] {
+				matrices[l][i][j] = big.NewInt(0)
+				for _, part := range parts {
+					matrices[l][i][j].Add(matrices[l][i][j], part[l][i][j])
+				}
+				matrices[l][i][j].Mod(matrices[l][i][j], T)
+			}
+		}
+	}
+	return
+}
+
+// checkProduct checks that C = A * B mod T for every packed matrix.
+func checkProduct(t *testing.T, A, B, C [][][]*big.Int, T *big.Int) {
+	dim := len(A[0])
+	for l := range A {
+		for i := 0; i < dim; i++ {
+			for j := 0; j < dim; j++ {
+				want := big.NewInt(0)
+				for k := 0; k < dim; k++ {
+					want.Add(want, new(big.Int).Mul(A[l][i][k], B[l][k][j]))
+				}
+				want.Mod(want, T)
+				if C[l][i][j].Cmp(want) != 0 {
+					t.Fatalf("matrix %d entry (%d, %d): expected %v, got %v", l, i, j, want, C[l][i][j])
+				}
+			}
+		}
+	}
+}
+
+// newParties creates parties parties, each holding only its own secret key share.
+// The public, relinearization and rotation keys of the sum of the shares are generated
+// with the sum of the shares for the sake of the tests.
+func newParties(t *testing.T, params hpbfv.Parameters, dim, parties int) (ps []*triple.Party) {
+	kg := hpbfv.NewKeyGenerator(params)
+	sk := rlwe.NewSecretKey(params.Parameters)
+	skShares := make([]*rlwe.SecretKey, parties)
+	for i := range skShares {
+		skShares[i] = kg.GenSecretKey()
+		params.RingQP().AddLvl(params.QCount()-1, params.PCount()-1, sk.Value, skShares[i].Value, sk.Value)
+	}
+	pk := kg.GenPublicKey(sk)
+	rlk := kg.GenRelinearizationKey(sk, 1)
+	rks, err := kg.GenRotationKeysForMatMul(sk, dim)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ps = make([]*triple.Party, parties)
+	for i := range ps {
+		if ps[i], err = triple.NewParty(params, dim, pk, skShares[i], rlk, rks, smudgingBound(t, params, parties)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return
+}
+
+// genTriples runs the generation of triples among the parties and returns their shares along
+// with the Product, which is computed by the first party and broadcast to the others.
+func genTriples(t *testing.T, parties []*triple.Party) (shares []*triple.Share, prod *triple.Product) {
+	shares = make([]*triple.Share, len(parties))
+	inputs := make([]*triple.Inputs, len(parties))
+	for i, p := range parties {
+		var err error
+		if shares[i], inputs[i], err = p.GenInputs(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prod, err := parties[0].Multiply(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	C := decryptToShares(t, parties, prod.CtC)
+	for i := range shares {
+		shares[i].C = C[i]
+	}
+	return
+}
+
+// decryptToShares jointly decrypts ct into additive shares, with the first party as the combiner.
+func decryptToShares(t *testing.T, parties []*triple.Party, ct *hpbfv.MatrixCiphertext) (matrices [][][][]hpbfv.Element) {
+	matrices = make([][][][]hpbfv.Element, len(parties))
+	decShares := make([]*triple.DecryptionShare, len(parties))
+
+	var err error
+	if decShares[0], err = parties[0].GenShare(ct); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(parties); i++ {
+		if decShares[i], matrices[i], err = parties[i].GenAdditiveShare(ct); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if matrices[0], err = parties[0].Combine(ct, decShares); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestGenTriples(t *testing.T) {
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.HPN13D10T128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dim := 4
+
+	for _, parties := range []int{1, 3} {
+		shares, _ := genTriples(t, newParties(t, params, dim, parties))
+
+		T := params.T()
+		A := reconstruct(shares, func(s *triple.Share) [][][]hpbfv.Element { return s.A }, T)
+		B := reconstruct(shares, func(s *triple.Share) [][][]hpbfv.Element { return s.B }, T)
+		C := reconstruct(shares, func(s *triple.Share) [][][]hpbfv.Element { return s.C }, T)
+
+		checkProduct(t, A, B, C, T)
+	}
+}
+
+// checkMac checks that Mac = alpha * M mod T for every packed matrix.
+func checkMac(t *testing.T, alpha *big.Int, M, Mac [][][]*big.Int, T *big.Int) {
+	for l := range M {
+		for i := range M[l] {
+			for j := range M[l][i] {
+				want := new(big.Int).Mul(alpha, M[l][i][j])
+				want.Mod(want, T)
+				if Mac[l][i][j].Cmp(want) != 0 {
+					t.Fatalf("matrix %d entry (%d, %d): expected MAC %v, got %v", l, i, j, want, Mac[l][i][j])
+				}
+			}
+		}
+	}
+}
+
+func TestGenAuthTriples(t *testing.T) {
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.HPN13D10T128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dim := 4
+
+	parties := newParties(t, params, dim, 3)
+
+	ctAlphas := make([]*hpbfv.Ciphertext, len(parties))
+	for i, p := range parties {
+		ctAlphas[i] = p.GenMacKey()
+	}
+
+	shares, prod := genTriples(t, parties)
+
+	ctAlpha, err := parties[0].AggregateMacKeys(ctAlphas)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	macs, err := parties[0].Authenticate(ctAlpha, prod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	macShares := make([]*triple.MacDecryptionShare, len(parties))
+	if macShares[0], err = parties[0].GenMacShare(macs); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(parties); i++ {
+		if macShares[i], err = parties[i].GenAdditiveMacShare(macs, shares[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = parties[0].CombineMacs(macs, macShares, shares[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	T := params.T()
+	alpha := big.NewInt(0)
+	for _, share := range shares {
+		alpha.Add(alpha, share.Alpha.BigInt())
+	}
+	alpha.Mod(alpha, T)
+
+	A := reconstruct(shares, func(s *triple.Share) [][][]hpbfv.Element { return s.A }, T)
+	B := reconstruct(shares, func(s *triple.Share) [][][]hpbfv.Element { return s.B }, T)
+	C := reconstruct(shares, func(s *triple.Share) [][][]hpbfv.Element { return s.C }, T)
+	checkProduct(t, A, B, C, T)
+
+	checkMac(t, alpha, A, reconstruct(shares, func(s *triple.Share) [][][]hpbfv.Element { return s.MacA }, T), T)
+	checkMac(t, alpha, B, reconstruct(shares, func(s *triple.Share) [][][]hpbfv.Element { return s.MacB }, T), T)
+	checkMac(t, alpha, C, reconstruct(shares, func(s *triple.Share) [][][]hpbfv.Element { return s.MacC }, T), T)
+}